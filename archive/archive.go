@@ -0,0 +1,224 @@
+// Package archive stores finished races and their points in compressed self-contained files.
+//
+// An archive is a gzip stream of:
+//   - the ARCHIVE_MAGIC bytes and the format version
+//   - the length of the race metadata and the metadata, models.Race as JSON
+//   - blocks of at most BLOCK_POINTS points: the count of points of the block, then their columns, written by the
+//     columnar package with the columnar.PLANES encoding
+//   - an empty block, a count of 0, after the last block
+//
+// Lengths and counts are unsigned varints.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/columnar"
+	"forzatelemetry/models"
+)
+
+const ARCHIVE_MAGIC = "FTRACE"
+const ARCHIVE_VERSION = 1
+
+// Points per block, a minute at 60 points per second. Only a block of points is in memory while writing or reading an archive.
+const BLOCK_POINTS = 3600
+
+// Extension of the archive files, named after the race ID
+const ARCHIVE_EXTENSION = ".race.gz"
+
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// Encode writes the race and its points, in the order of the sequence, a block at a time.
+// The first error of the sequence stops the encoding and is returned.
+func Encode(w io.Writer, race models.Race, points iter.Seq2[models.Point, error]) error {
+	metadata, err := json.Marshal(race)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	buf := append([]byte(ARCHIVE_MAGIC), ARCHIVE_VERSION)
+	buf = binary.AppendUvarint(buf, uint64(len(metadata)))
+	buf = append(buf, metadata...)
+	_, err = gz.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	block := make([]models.Point, 0, BLOCK_POINTS)
+	writeBlock := func() error {
+		buf = binary.AppendUvarint(buf[:0], uint64(len(block)))
+		buf = columnar.Append(buf, block, columnar.PLANES)
+		block = block[:0]
+		_, err := gz.Write(buf)
+		return err
+	}
+	for point, err := range points {
+		if err != nil {
+			return err
+		}
+		block = append(block, point)
+		if len(block) == BLOCK_POINTS {
+			err = writeBlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(block) > 0 {
+		err = writeBlock()
+		if err != nil {
+			return err
+		}
+	}
+	// The empty block ending the points, a count without columns
+	_, err = gz.Write([]byte{0})
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Reader reads an archive written by Encode, the race when it's created then its points a block at a time
+type Reader struct {
+	Race models.Race
+
+	gz     *gzip.Reader
+	br     *bufio.Reader
+	done   bool
+	closer io.Closer // optional, closed with the reader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	reader := &Reader{gz: gz, br: bufio.NewReader(gz)}
+
+	header := make([]byte, len(ARCHIVE_MAGIC)+1)
+	_, err = io.ReadFull(reader.br, header)
+	if err != nil || string(header[:len(ARCHIVE_MAGIC)]) != ARCHIVE_MAGIC {
+		return nil, fmt.Errorf("%w: missing magic bytes", ErrInvalidArchive)
+	}
+	if version := header[len(ARCHIVE_MAGIC)]; version != ARCHIVE_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, version)
+	}
+
+	metadata, err := columnar.ReadBytes(reader.br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %w", ErrInvalidArchive, err)
+	}
+	err = json.Unmarshal(metadata, &reader.Race)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %w", ErrInvalidArchive, err)
+	}
+	return reader, nil
+}
+
+// Next returns the points of the next block, io.EOF after the last block
+func (r *Reader) Next() ([]models.Point, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	count, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	if count == 0 {
+		// The end of the gzip stream checks its checksum
+		r.done = true
+		_, err = r.br.ReadByte()
+		if err == nil {
+			return nil, fmt.Errorf("%w: data after the last block", ErrInvalidArchive)
+		} else if !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		return nil, io.EOF
+	} else if count > BLOCK_POINTS {
+		return nil, fmt.Errorf("%w: %d points in a block", ErrInvalidArchive, count)
+	}
+
+	points := make([]models.Point, count)
+	for i := range points {
+		points[i].Race = r.Race.ID
+	}
+	err = columnar.Read(r.br, points, columnar.PLANES)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	return points, nil
+}
+
+func (r *Reader) Close() error {
+	err := r.gz.Close()
+	if r.closer != nil {
+		err = errors.Join(err, r.closer.Close())
+	}
+	return err
+}
+
+// Dir keeps the archives of the races in a local directory
+type Dir struct {
+	path string
+}
+
+// NewDir creates the directory if needed
+func NewDir(path string) (*Dir, error) {
+	err := os.MkdirAll(path, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating archive directory: %w", err)
+	}
+	return &Dir{path: path}, nil
+}
+
+func (d *Dir) Path(id uuid.UUID) string {
+	return filepath.Join(d.path, id.String()+ARCHIVE_EXTENSION)
+}
+
+// Write archives the race, replacing a previous archive only once the new one is complete
+func (d *Dir) Write(race models.Race, points iter.Seq2[models.Point, error]) error {
+	path := d.Path(race.ID)
+	f, err := os.CreateTemp(d.path, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = Encode(f, race, points)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		return fmt.Errorf("failed archiving race %s: %w", race.ID, err)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Open returns a reader of the archived race, an error wrapping os.ErrNotExist when the race isn't archived.
+// The reader must be closed.
+func (d *Dir) Open(id uuid.UUID) (*Reader, error) {
+	f, err := os.Open(d.Path(id))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	reader.closer = f
+	return reader, nil
+}
@@ -0,0 +1,191 @@
+package archive_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"iter"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/archive"
+	"forzatelemetry/models"
+	"forzatelemetry/testutils"
+)
+
+func testRace(n int) (models.Race, []models.Point) {
+	race := models.Race{
+		ID:         uuid.New(),
+		SessionID:  uuid.New(),
+		Game:       models.GAME_FM8,
+		FinishedAt: time.Now().Truncate(time.Second).UTC(),
+		Car:        100,
+		Track:      2,
+		BestLap:    92.5,
+		Archived:   true,
+	}
+	start := time.Now()
+	points := make([]models.Point, n)
+	for i := range points {
+		points[i] = testutils.Point(race.ID, start.Add(time.Duration(i)*16*time.Millisecond), 1)
+		points[i].CurrentRaceTime = float32(i) / 60
+		points[i].LapNumber = uint16(i / 100)
+		points[i].Gear = uint8(i % 7)
+		points[i].Steer = int8(i%256 - 128)
+		points[i].EngineCurrentRPM = float32(math.Inf(-1))
+	}
+	return race, points
+}
+
+// seq returns the points as the sequence of points read from the database
+func seq(points []models.Point) iter.Seq2[models.Point, error] {
+	return func(yield func(models.Point, error) bool) {
+		for _, point := range points {
+			if !yield(point, nil) {
+				return
+			}
+		}
+	}
+}
+
+// readAll returns the points of every block of the archive
+func readAll(r *archive.Reader) ([]models.Point, error) {
+	var points []models.Point
+	for {
+		block, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return points, nil
+		} else if err != nil {
+			return points, err
+		}
+		points = append(points, block...)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	race, points := testRace(2*archive.BLOCK_POINTS + 100)
+
+	var buf bytes.Buffer
+	err := archive.Encode(&buf, race, seq(points))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	raw := len(points) * int(reflect.TypeFor[models.TelemetryPoint]().Size())
+	if buf.Len() >= raw/4 {
+		t.Errorf("expected less than %v bytes got %v", raw/4, buf.Len())
+	}
+
+	reader, err := archive.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(reader.Race, race) {
+		t.Errorf("expected %+v got %+v", race, reader.Race)
+	}
+	decoded, err := readAll(reader)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(decoded) != len(points) {
+		t.Fatalf("expected %v got %v", len(points), len(decoded))
+	}
+	for i := range points {
+		if decoded[i].TelemetryPoint != points[i].TelemetryPoint || decoded[i].Race != race.ID || !decoded[i].CreatedAt.Equal(points[i].CreatedAt) {
+			t.Fatalf("expected %+v got %+v", points[i], decoded[i])
+		}
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	race, _ := testRace(0)
+	failing := func(yield func(models.Point, error) bool) {
+		yield(models.Point{}, errors.New("failing"))
+	}
+	err := archive.Encode(io.Discard, race, failing)
+	if err == nil || err.Error() != "failing" {
+		t.Errorf("expected failing got %v", err)
+	}
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	race, points := testRace(10)
+	var valid bytes.Buffer
+	err := archive.Encode(&valid, race, seq(points))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	runs := map[string][]byte{
+		"not gzip":  []byte("FTRACE"),
+		"magic":     gzipped([]byte("NOPE")),
+		"version":   gzipped([]byte("FTRACE\x02")),
+		"metadata":  gzipped([]byte("FTRACE\x01\x02{]")),
+		"block":     gzipped(append([]byte("FTRACE\x01\x02{}"), binary.AppendUvarint(nil, archive.BLOCK_POINTS+1)...)),
+		"truncated": valid.Bytes()[:valid.Len()-20],
+	}
+	for name, data := range runs {
+		t.Run(name, func(t *testing.T) {
+			reader, err := archive.NewReader(bytes.NewReader(data))
+			if err == nil {
+				_, err = readAll(reader)
+			}
+			if err == nil {
+				t.Errorf("expected error got nil")
+			}
+		})
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir, err := archive.NewDir(t.TempDir() + "/archive")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	race, points := testRace(10)
+	err = dir.Write(race, seq(points))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// replaced
+	err = dir.Write(race, seq(points[:5]))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	reader, err := dir.Open(race.ID)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	read, err := readAll(reader)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(read) != 5 {
+		t.Errorf("expected 5 got %v", len(read))
+	}
+	err = reader.Close()
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	_, err = dir.Open(uuid.New())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v got %v", os.ErrNotExist, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"forzatelemetry/telemetry"
+)
+
+// replay sends recorded captures to a telemetry server
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	target := flags.String("target", "127.0.0.1:8000", "address of the telemetry server")
+	speed := flags.Float64("speed", 1, "replay speed, 1 is real time, 0 is as fast as possible")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay [options] capture...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || *speed < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	con, err := net.Dial("udp", *target)
+	if err != nil {
+		slog.Error("failed to connect", "error", err, "target", *target)
+		return 1
+	}
+	defer con.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	for _, path := range flags.Args() {
+		err := replayFile(ctx, path, con, *speed)
+		if err != nil {
+			slog.Error("failed to replay capture", "error", err, "path", path)
+			return 1
+		}
+	}
+	return 0
+}
+
+func replayFile(ctx context.Context, path string, con net.Conn, speed float64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := telemetry.NewCaptureReader(f)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("replaying capture", "path", path, "capture", reader.Header.CaptureID, "source", reader.Header.Source, "startedAt", reader.Header.StartedAt)
+	sent, err := telemetry.Replay(ctx, reader, con, speed)
+	slog.Info("replayed capture", "path", path, "datagrams", sent)
+	return err
+}
@@ -3,15 +3,20 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"forzatelemetry/archive"
 	"forzatelemetry/models"
 	"forzatelemetry/storage"
 	"forzatelemetry/storage/migrations"
@@ -53,8 +58,347 @@ func configureLogger() {
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
 }
 
+// configureCapture reads the raw datagrams capture configuration. Capture is disabled without CAPTURE_DIR.
+// CAPTURE_SOURCES is either "*" for all sources or a comma separated list of IP or IP:port.
+func configureCapture() (*telemetry.Capture, error) {
+	dir := os.Getenv("CAPTURE_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+
+	config := telemetry.CaptureConfig{Dir: dir}
+	sources := os.Getenv("CAPTURE_SOURCES")
+	if sources == "*" {
+		config.All = true
+	} else if sources != "" {
+		config.Sources = strings.Split(sources, ",")
+	}
+
+	var err error
+	if maxFileSize := os.Getenv("CAPTURE_MAX_FILE_SIZE"); maxFileSize != "" {
+		config.MaxFileSize, err = strconv.ParseInt(maxFileSize, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("CAPTURE_MAX_FILE_SIZE: %w", err)
+		}
+	}
+	if maxSize := os.Getenv("CAPTURE_MAX_SIZE"); maxSize != "" {
+		config.MaxSize, err = strconv.ParseInt(maxSize, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("CAPTURE_MAX_SIZE: %w", err)
+		}
+	}
+
+	return telemetry.NewCapture(config)
+}
+
+// configureRelay reads the datagrams forwarding configuration. Forwarding is disabled without RELAY_DESTINATIONS.
+// RELAY_DESTINATIONS is a comma separated list of host:port, optionally followed by "=" and the "|" separated
+// IP or IP:port of the sources to forward, e.g. "127.0.0.1:9000,10.0.0.5:9000=10.0.0.2|10.0.0.3".
+func configureRelay() (*telemetry.Relay, error) {
+	value := os.Getenv("RELAY_DESTINATIONS")
+	if value == "" {
+		return nil, nil
+	}
+
+	var destinations []telemetry.RelayDestination
+	for _, entry := range strings.Split(value, ",") {
+		addr, sources, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if addr == "" {
+			return nil, fmt.Errorf("RELAY_DESTINATIONS: missing address in %q", entry)
+		}
+		destination := telemetry.RelayDestination{Addr: addr}
+		if found && sources != "" {
+			destination.Sources = strings.Split(sources, "|")
+		}
+		destinations = append(destinations, destination)
+	}
+	return telemetry.NewRelay(destinations)
+}
+
+// configureLimits reads the sources allowed to send telemetry and the limits per source.
+// TELEMETRY_ALLOW and TELEMETRY_DENY are comma separated CIDR prefixes or IPs, every source is allowed by default.
+// TELEMETRY_MAX_SESSIONS (default 100) caps the concurrent sessions, TELEMETRY_PACKET_RATE (default 120) the packets per second
+// of a source and TELEMETRY_PACKET_BURST (default the rate) the packets above the rate at once. 0 disables a limit.
+func configureLimits() (telemetry.Limits, error) {
+	limits := telemetry.Limits{MaxSessions: 100, PacketRate: 120}
+	var err error
+
+	limits.Allow, err = telemetry.ParsePrefixes(os.Getenv("TELEMETRY_ALLOW"))
+	if err != nil {
+		return limits, fmt.Errorf("TELEMETRY_ALLOW: %w", err)
+	}
+	limits.Deny, err = telemetry.ParsePrefixes(os.Getenv("TELEMETRY_DENY"))
+	if err != nil {
+		return limits, fmt.Errorf("TELEMETRY_DENY: %w", err)
+	}
+
+	if value := os.Getenv("TELEMETRY_MAX_SESSIONS"); value != "" {
+		limits.MaxSessions, err = strconv.Atoi(value)
+		if err != nil {
+			return limits, fmt.Errorf("TELEMETRY_MAX_SESSIONS: %w", err)
+		}
+	}
+	if value := os.Getenv("TELEMETRY_PACKET_RATE"); value != "" {
+		limits.PacketRate, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return limits, fmt.Errorf("TELEMETRY_PACKET_RATE: %w", err)
+		}
+	}
+	if value := os.Getenv("TELEMETRY_PACKET_BURST"); value != "" {
+		limits.PacketBurst, err = strconv.Atoi(value)
+		if err != nil {
+			return limits, fmt.Errorf("TELEMETRY_PACKET_BURST: %w", err)
+		}
+	}
+	return limits, nil
+}
+
+// configureBatches reads how the points of every session are coalesced before being written.
+// BATCH_SIZE (default 2000) points or BATCH_INTERVAL (default 1s) trigger a write, BATCH_MAX_PENDING (default 50000) points
+// are kept in memory and a failed write is attempted BATCH_MAX_RETRIES (default 5) more times.
+func configureBatches() (telemetry.BatchConfig, error) {
+	config := telemetry.DEFAULT_BATCH_CONFIG
+	var err error
+
+	config.Interval, err = durationEnv("BATCH_INTERVAL", config.Interval)
+	if err != nil {
+		return config, err
+	}
+	for name, value := range map[string]*int{
+		"BATCH_SIZE":        &config.Size,
+		"BATCH_MAX_PENDING": &config.MaxPending,
+		"BATCH_MAX_RETRIES": &config.MaxRetries,
+	} {
+		if env := os.Getenv(name); env != "" {
+			*value, err = strconv.Atoi(env)
+			if err != nil {
+				return config, fmt.Errorf("%s: %w", name, err)
+			}
+			if *value < 0 {
+				return config, fmt.Errorf("%s: must not be negative", name)
+			}
+		}
+	}
+	return config, nil
+}
+
+// How often the finished laps are packed in lap chunks with the "laps" points layout
+const COMPACT_INTERVAL = time.Minute
+
+// configurePointsLayout reads how the points of the finished laps are stored, POINTS_LAYOUT is "rows" (default) or "laps".
+// With "laps", the finished laps are packed in compressed lap chunks every COMPACT_INTERVAL, they aren't visible to the Grafana dashboards.
+func configurePointsLayout() (storage.PointsLayout, error) {
+	switch layout := os.Getenv("POINTS_LAYOUT"); layout {
+	case "", "rows":
+		return storage.LAYOUT_ROWS, nil
+	case "laps":
+		return storage.LAYOUT_LAPS, nil
+	default:
+		return storage.LAYOUT_ROWS, fmt.Errorf("POINTS_LAYOUT: unknown layout %q", layout)
+	}
+}
+
+// retentionConfig reads how often the database is cleaned up, RETENTION_INTERVAL (default 24h), and what is deleted.
+// RETENTION_MAX_AGE (default 90d, 0 disables it) deletes the races finished longer ago, it's a duration or a count of days like "30d".
+// RETENTION_MAX_POINTS and RETENTION_MAX_BYTES, like "50GB", delete the oldest races above the limits, they are disabled by default.
+// RETENTION_DRY_RUN=true only logs what would be deleted. Pinned races are never deleted.
+// With ARCHIVE_DIR, races are archived in the directory and only their points are deleted, they can be restored with the API.
+type retentionConfig struct {
+	interval time.Duration
+	policy   storage.RetentionPolicy
+	archive  *archive.Dir
+}
+
+func configureRetention() (retentionConfig, error) {
+	config := retentionConfig{policy: storage.DEFAULT_RETENTION_POLICY}
+	var err error
+
+	config.interval, err = durationEnv("RETENTION_INTERVAL", 24*time.Hour)
+	if err != nil {
+		return config, err
+	}
+
+	config.policy.MaxAge, err = ageEnv("RETENTION_MAX_AGE", config.policy.MaxAge)
+	if err != nil {
+		return config, err
+	}
+
+	if value := os.Getenv("RETENTION_MAX_POINTS"); value != "" {
+		config.policy.MaxPoints, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("RETENTION_MAX_POINTS: %w", err)
+		}
+	}
+	if value := os.Getenv("RETENTION_MAX_BYTES"); value != "" {
+		config.policy.MaxBytes, err = parseBytes(value)
+		if err != nil {
+			return config, fmt.Errorf("RETENTION_MAX_BYTES: %w", err)
+		}
+	}
+	if value := os.Getenv("RETENTION_DRY_RUN"); value != "" {
+		config.policy.DryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			return config, fmt.Errorf("RETENTION_DRY_RUN: %w", err)
+		}
+	}
+
+	if dir := os.Getenv("ARCHIVE_DIR"); dir != "" {
+		config.archive, err = archive.NewDir(dir)
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// configureTimescale reads whether the points table is converted to a TimescaleDB hypertable, TIMESCALE=true converts it at start.
+// It's opt-in and can't be undone. Chunks older than TIMESCALE_COMPRESS_AFTER (default 7d, 0 disables it) are compressed.
+type timescaleConfig struct {
+	enabled       bool
+	compressAfter time.Duration
+}
+
+func configureTimescale() (timescaleConfig, error) {
+	config := timescaleConfig{compressAfter: 7 * 24 * time.Hour}
+	var err error
+
+	if value := os.Getenv("TIMESCALE"); value != "" {
+		config.enabled, err = strconv.ParseBool(value)
+		if err != nil {
+			return config, fmt.Errorf("TIMESCALE: %w", err)
+		}
+	}
+	config.compressAfter, err = ageEnv("TIMESCALE_COMPRESS_AFTER", config.compressAfter)
+	return config, err
+}
+
+// parseBytes parses a size in bytes with an optional KB, MB, GB or TB unit, multiples of 1000
+func parseBytes(value string) (int64, error) {
+	unit := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			value = number
+			unit = int64(math.Pow(1000, float64(i+1)))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * unit, nil
+}
+
+// configureDrivers reads the drivers bound to source addresses, like "alice=192.168.1.10|192.168.1.11:5000,bob=192.168.1.12".
+// A source without port matches every port of the IP.
+func configureDrivers() ([]models.Driver, error) {
+	value := os.Getenv("DRIVERS")
+	if value == "" {
+		return nil, nil
+	}
+
+	var drivers []models.Driver
+	for _, entry := range strings.Split(value, ",") {
+		name, sources, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		if name == "" || sources == "" {
+			return nil, fmt.Errorf("DRIVERS: missing name or sources in %q", entry)
+		}
+		driver := models.Driver{Name: name}
+		for _, source := range strings.Split(sources, "|") {
+			source, err := models.ParseSource(source)
+			if err != nil {
+				return nil, fmt.Errorf("DRIVERS: %w", err)
+			}
+			driver.Sources = append(driver.Sources, models.DriverSource{Source: source})
+		}
+		drivers = append(drivers, driver)
+	}
+	return drivers, nil
+}
+
+// ageEnv reads a duration or a count of days like "30d", "0" disables what it limits
+func ageEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "0" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%s: invalid count of days %q", name, value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return durationEnv(name, fallback)
+}
+
+// durationEnv parses a duration like "5s" from an environment variable, fallback when it's not set
+func durationEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s: must be positive", name)
+	}
+	return d, nil
+}
+
+// sessionsConfig reads how often sessions are checkpointed and how long a source can be silent before its session is closed.
+// A source reconnecting within the resume grace period carries on its race, SESSION_RESUME_GRACE_PERIOD=0 disables it.
+// SEGMENTATION_RULES lists the rules splitting races, like "raceTimeReset,car,track". The default rules are used when it's not set.
+// The write-ahead log of the points not checkpointed yet is disabled without WAL_DIR.
+type sessionsConfig struct {
+	flushInterval     time.Duration
+	idleTimeout       time.Duration
+	resumeGracePeriod time.Duration
+	rules             []telemetry.SegmentationRule
+	wal               *telemetry.WAL
+}
+
+func configureSessions() (sessionsConfig, error) {
+	var config sessionsConfig
+	var err error
+	config.flushInterval, err = durationEnv("SESSION_FLUSH_INTERVAL", 5*time.Second)
+	if err != nil {
+		return config, err
+	}
+	config.idleTimeout, err = durationEnv("SESSION_IDLE_TIMEOUT", time.Minute)
+	if err != nil {
+		return config, err
+	}
+	if os.Getenv("SESSION_RESUME_GRACE_PERIOD") != "0" {
+		config.resumeGracePeriod, err = durationEnv("SESSION_RESUME_GRACE_PERIOD", 2*time.Minute)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	if value := os.Getenv("SEGMENTATION_RULES"); value != "" {
+		config.rules, err = telemetry.ParseSegmentationRules(value)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	if dir := os.Getenv("WAL_DIR"); dir != "" {
+		config.wal, err = telemetry.NewWAL(dir)
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
 func main() {
 	configureLogger()
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
 	os.Exit(run())
 }
 
@@ -77,6 +421,62 @@ func run() int {
 		slog.Warn("missing configuration: GRAFANA_BASE_URL")
 		return 1
 	}
+	// Admin routes are disabled without a token
+	adminToken := os.Getenv("ADMIN_TOKEN")
+
+	capture, err := configureCapture()
+	if err != nil {
+		slog.Warn("invalid capture configuration", "error", err)
+		return 1
+	}
+
+	relay, err := configureRelay()
+	if err != nil {
+		slog.Warn("invalid relay configuration", "error", err)
+		return 1
+	}
+
+	sessions, err := configureSessions()
+	if err != nil {
+		slog.Warn("invalid sessions configuration", "error", err)
+		return 1
+	}
+
+	drivers, err := configureDrivers()
+	if err != nil {
+		slog.Warn("invalid drivers configuration", "error", err)
+		return 1
+	}
+
+	limits, err := configureLimits()
+	if err != nil {
+		slog.Warn("invalid limits configuration", "error", err)
+		return 1
+	}
+
+	retention, err := configureRetention()
+	if err != nil {
+		slog.Warn("invalid retention configuration", "error", err)
+		return 1
+	}
+
+	batches, err := configureBatches()
+	if err != nil {
+		slog.Warn("invalid batches configuration", "error", err)
+		return 1
+	}
+
+	layout, err := configurePointsLayout()
+	if err != nil {
+		slog.Warn("invalid points configuration", "error", err)
+		return 1
+	}
+
+	timescale, err := configureTimescale()
+	if err != nil {
+		slog.Warn("invalid timescale configuration", "error", err)
+		return 1
+	}
 
 	var wg sync.WaitGroup
 	errorC := make(chan bool, 2)
@@ -91,6 +491,26 @@ func run() int {
 		slog.Error("failed to migrate database", "error", err)
 		return 1
 	}
+	if layout == storage.LAYOUT_LAPS {
+		db.SetPointsLayout(layout)
+		err = db.Migrate(migrations.LapsMigrations)
+		if err != nil {
+			slog.Error("failed to migrate points to lap chunks", "error", err)
+			return 1
+		}
+	}
+	if timescale.enabled {
+		err = db.Migrate(migrations.TimescaleMigrations)
+		if err != nil {
+			slog.Error("failed to migrate points to a hypertable", "error", err)
+			return 1
+		}
+		err = db.SetCompressionPolicy(context.Background(), timescale.compressAfter)
+		if err != nil {
+			slog.Error("failed to set the compression policy", "error", err)
+			return 1
+		}
+	}
 	err = db.UpsertTracks(models.Tracks, context.Background())
 	if err != nil {
 		slog.Error("failed to sync tracks", "error", err)
@@ -106,9 +526,18 @@ func run() int {
 		slog.Error("failed to sync car classes", "error", err)
 		return 1
 	}
+	if retention.archive != nil {
+		db.SetArchive(retention.archive)
+	}
+	// Drivers can also be managed with the API, the configured ones are restored at every start
+	err = db.UpsertDrivers(context.Background(), drivers...)
+	if err != nil {
+		slog.Error("failed to sync drivers", "error", err)
+		return 1
+	}
 
 	wg.Add(1)
-	cleanupTicker := time.NewTicker(24 * time.Hour)
+	cleanupTicker := time.NewTicker(retention.interval)
 	cleanupDone := make(chan struct{})
 	go func() {
 		defer wg.Done()
@@ -117,17 +546,56 @@ func run() int {
 			case <-cleanupDone:
 				return
 			case <-cleanupTicker.C:
-				err := db.Cleanup(context.Background())
-				if err == nil {
-					slog.Info("database cleanup complete")
-				} else {
+				report, err := db.Cleanup(context.Background(), retention.policy)
+				if err != nil {
 					slog.Error("failed to cleanup database", "error", err)
+				} else if report.DryRun {
+					slog.Info("database cleanup dry run, nothing deleted", "races", len(report.Races), "points", report.Points, "ids", report.Races)
+				} else {
+					slog.Info("database cleanup complete", "races", len(report.Races), "points", report.Points, "archived", report.Archived, "chunks", report.Chunks)
 				}
 			}
 		}
 	}()
 
-	telemetryServer := telemetry.NewServer(telemetryAddr, db, 5*time.Second)
+	// Packing the laps is kept out of the batches of points, their latency doesn't depend on the length of the laps
+	compactDone := make(chan struct{})
+	if layout == storage.LAYOUT_LAPS {
+		wg.Add(1)
+		compactTicker := time.NewTicker(COMPACT_INTERVAL)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-compactDone:
+					return
+				case <-compactTicker.C:
+					err := db.CompactLaps(context.Background())
+					if err != nil {
+						slog.Error("failed to pack laps", "error", err)
+					}
+				}
+			}
+		}()
+	}
+
+	telemetryServer := telemetry.NewServer(telemetryAddr, db, sessions.flushInterval)
+	telemetryServer.SetIdleTimeout(sessions.idleTimeout)
+	telemetryServer.SetResumeGracePeriod(sessions.resumeGracePeriod)
+	telemetryServer.SetLimits(limits)
+	telemetryServer.SetBatchConfig(batches)
+	if sessions.rules != nil {
+		telemetryServer.SetSegmentationRules(sessions.rules)
+	}
+	if sessions.wal != nil {
+		telemetryServer.SetWAL(sessions.wal)
+	}
+	if capture != nil {
+		telemetryServer.SetCapture(capture)
+	}
+	if relay != nil {
+		telemetryServer.SetRelay(relay)
+	}
 	wg.Add(1)
 	go func() {
 		slog.Info("starting telemetry server", "addr", telemetryAddr)
@@ -141,7 +609,7 @@ func run() int {
 		wg.Done()
 	}()
 
-	router := fthttp.Router(db, revision, dashboardBaseUrl)
+	router := fthttp.Router(db, telemetryServer, revision, dashboardBaseUrl, adminToken)
 	httpServer := &http.Server{
 		Addr:    httpAddr,
 		Handler: router,
@@ -175,6 +643,7 @@ func run() int {
 	defer cancel()
 
 	close(cleanupDone)
+	close(compactDone)
 
 	wg.Add(1)
 	go func() {
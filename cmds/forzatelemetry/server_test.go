@@ -3,7 +3,12 @@ package main
 import (
 	"log/slog"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"forzatelemetry/storage"
+	"forzatelemetry/telemetry"
 )
 
 func TestMainDummy(t *testing.T) {
@@ -46,3 +51,326 @@ func TestRunMissingEnv(t *testing.T) {
 		t.Errorf("expected exit code 1 for missing env, got %d", code)
 	}
 }
+
+func TestConfigureCapture(t *testing.T) {
+	t.Setenv("CAPTURE_DIR", "")
+	capture, err := configureCapture()
+	if err != nil || capture != nil {
+		t.Errorf("expected no capture got %v %v", capture, err)
+	}
+
+	t.Setenv("CAPTURE_DIR", t.TempDir())
+	t.Setenv("CAPTURE_SOURCES", "*")
+	t.Setenv("CAPTURE_MAX_SIZE", "1000")
+	capture, err = configureCapture()
+	if err != nil || capture == nil {
+		t.Errorf("expected capture got %v %v", capture, err)
+	}
+
+	t.Setenv("CAPTURE_MAX_FILE_SIZE", "a")
+	_, err = configureCapture()
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+}
+
+func TestReplayUsage(t *testing.T) {
+	if code := replay([]string{}); code != 2 {
+		t.Errorf("expected exit code 2 without capture, got %d", code)
+	}
+	if code := replay([]string{"-speed", "-1", "capture.fcap"}); code != 2 {
+		t.Errorf("expected exit code 2 for a negative speed, got %d", code)
+	}
+	if code := replay([]string{filepath.Join(t.TempDir(), "missing.fcap")}); code != 1 {
+		t.Errorf("expected exit code 1 for a missing capture, got %d", code)
+	}
+}
+
+func TestConfigureRelay(t *testing.T) {
+	t.Setenv("RELAY_DESTINATIONS", "")
+	relay, err := configureRelay()
+	if err != nil || relay != nil {
+		t.Errorf("expected no relay got %v %v", relay, err)
+	}
+
+	t.Setenv("RELAY_DESTINATIONS", "127.0.0.1:9000, 127.0.0.1:9001=10.0.0.2|10.0.0.3:1000")
+	relay, err = configureRelay()
+	if err != nil || relay == nil {
+		t.Fatalf("expected relay got %v %v", relay, err)
+	}
+	defer relay.Close()
+	stats := relay.Stats()
+	if len(stats) != 2 || stats[0].Addr != "127.0.0.1:9000" || stats[1].Addr != "127.0.0.1:9001" {
+		t.Errorf("unexpected destinations %v", stats)
+	}
+
+	t.Setenv("RELAY_DESTINATIONS", "=10.0.0.2")
+	_, err = configureRelay()
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+}
+
+func TestConfigureSessions(t *testing.T) {
+	t.Setenv("SESSION_FLUSH_INTERVAL", "")
+	t.Setenv("SESSION_IDLE_TIMEOUT", "")
+	t.Setenv("SESSION_RESUME_GRACE_PERIOD", "")
+	t.Setenv("SEGMENTATION_RULES", "")
+	t.Setenv("WAL_DIR", "")
+	config, err := configureSessions()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.flushInterval != 5*time.Second || config.idleTimeout != time.Minute || config.resumeGracePeriod != 2*time.Minute || config.rules != nil || config.wal != nil {
+		t.Errorf("unexpected config %v", config)
+	}
+
+	t.Setenv("SESSION_FLUSH_INTERVAL", "1s")
+	t.Setenv("SESSION_IDLE_TIMEOUT", "5m")
+	t.Setenv("SESSION_RESUME_GRACE_PERIOD", "0")
+	t.Setenv("SEGMENTATION_RULES", "raceTimeReset,car")
+	t.Setenv("WAL_DIR", t.TempDir())
+	config, err = configureSessions()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.flushInterval != time.Second || config.idleTimeout != 5*time.Minute || config.resumeGracePeriod != 0 || len(config.rules) != 2 || config.wal == nil {
+		t.Errorf("unexpected config %v", config)
+	}
+
+	for _, value := range []string{"a", "-1s", "0"} {
+		t.Setenv("SESSION_IDLE_TIMEOUT", value)
+		_, err = configureSessions()
+		if err == nil {
+			t.Errorf("%s: expected error got nil", value)
+		}
+	}
+
+	t.Setenv("SESSION_IDLE_TIMEOUT", "")
+	t.Setenv("SEGMENTATION_RULES", "car,unknown")
+	_, err = configureSessions()
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+}
+
+func TestConfigureDrivers(t *testing.T) {
+	t.Setenv("DRIVERS", "")
+	drivers, err := configureDrivers()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if drivers != nil {
+		t.Errorf("expected no drivers got %v", drivers)
+	}
+
+	t.Setenv("DRIVERS", "alice=192.168.1.10|192.168.1.11:5000, bob=192.168.1.12")
+	drivers, err = configureDrivers()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(drivers) != 2 || drivers[0].Name != "alice" || len(drivers[0].Sources) != 2 || drivers[1].Name != "bob" || drivers[1].Sources[0].Source != "192.168.1.12" {
+		t.Errorf("unexpected drivers %v", drivers)
+	}
+
+	for _, value := range []string{"alice", "=192.168.1.10", "alice=", "alice=localhost", "alice=192.168.1.10:port"} {
+		t.Setenv("DRIVERS", value)
+		_, err = configureDrivers()
+		if err == nil {
+			t.Errorf("%s: expected error got nil", value)
+		}
+	}
+}
+
+func TestConfigureLimits(t *testing.T) {
+	for _, name := range []string{"TELEMETRY_ALLOW", "TELEMETRY_DENY", "TELEMETRY_MAX_SESSIONS", "TELEMETRY_PACKET_RATE", "TELEMETRY_PACKET_BURST"} {
+		t.Setenv(name, "")
+	}
+	limits, err := configureLimits()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if limits.Allow != nil || limits.Deny != nil || limits.MaxSessions != 100 || limits.PacketRate != 120 || limits.PacketBurst != 0 {
+		t.Errorf("unexpected limits %+v", limits)
+	}
+
+	t.Setenv("TELEMETRY_ALLOW", "10.0.0.0/8, 192.168.1.10")
+	t.Setenv("TELEMETRY_DENY", "10.0.0.1")
+	t.Setenv("TELEMETRY_MAX_SESSIONS", "0")
+	t.Setenv("TELEMETRY_PACKET_RATE", "60.5")
+	t.Setenv("TELEMETRY_PACKET_BURST", "10")
+	limits, err = configureLimits()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(limits.Allow) != 2 || len(limits.Deny) != 1 || limits.MaxSessions != 0 || limits.PacketRate != 60.5 || limits.PacketBurst != 10 {
+		t.Errorf("unexpected limits %+v", limits)
+	}
+
+	for name, value := range map[string]string{
+		"TELEMETRY_ALLOW":        "10.0.0.0/33",
+		"TELEMETRY_DENY":         "a",
+		"TELEMETRY_MAX_SESSIONS": "a",
+		"TELEMETRY_PACKET_RATE":  "a",
+		"TELEMETRY_PACKET_BURST": "1.5",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err = configureLimits()
+			if err == nil {
+				t.Errorf("expected error got nil")
+			}
+		})
+	}
+}
+
+func TestConfigureBatches(t *testing.T) {
+	for _, name := range []string{"BATCH_SIZE", "BATCH_INTERVAL", "BATCH_MAX_PENDING", "BATCH_MAX_RETRIES"} {
+		t.Setenv(name, "")
+	}
+	config, err := configureBatches()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config != telemetry.DEFAULT_BATCH_CONFIG {
+		t.Errorf("expected %+v got %+v", telemetry.DEFAULT_BATCH_CONFIG, config)
+	}
+
+	t.Setenv("BATCH_SIZE", "500")
+	t.Setenv("BATCH_INTERVAL", "200ms")
+	t.Setenv("BATCH_MAX_PENDING", "1000")
+	t.Setenv("BATCH_MAX_RETRIES", "0")
+	config, err = configureBatches()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.Size != 500 || config.Interval != 200*time.Millisecond || config.MaxPending != 1000 || config.MaxRetries != 0 {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	for name, value := range map[string]string{
+		"BATCH_SIZE":        "a",
+		"BATCH_INTERVAL":    "0s",
+		"BATCH_MAX_PENDING": "-1",
+		"BATCH_MAX_RETRIES": "1.5",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err = configureBatches()
+			if err == nil {
+				t.Errorf("expected error got nil")
+			}
+		})
+	}
+}
+
+func TestConfigurePointsLayout(t *testing.T) {
+	runs := []struct {
+		value  string
+		layout storage.PointsLayout
+		err    bool
+	}{
+		{"", storage.LAYOUT_ROWS, false},
+		{"rows", storage.LAYOUT_ROWS, false},
+		{"laps", storage.LAYOUT_LAPS, false},
+		{"columns", storage.LAYOUT_ROWS, true},
+	}
+	for _, run := range runs {
+		t.Setenv("POINTS_LAYOUT", run.value)
+		layout, err := configurePointsLayout()
+		if (err != nil) != run.err || layout != run.layout {
+			t.Errorf("%q: expected %v %v got %v %v", run.value, run.layout, run.err, layout, err)
+		}
+	}
+}
+
+func TestConfigureTimescale(t *testing.T) {
+	t.Setenv("TIMESCALE", "")
+	t.Setenv("TIMESCALE_COMPRESS_AFTER", "")
+	config, err := configureTimescale()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.enabled || config.compressAfter != 7*24*time.Hour {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	t.Setenv("TIMESCALE", "true")
+	for value, expected := range map[string]time.Duration{"30d": 30 * 24 * time.Hour, "12h": 12 * time.Hour, "0": 0} {
+		t.Setenv("TIMESCALE_COMPRESS_AFTER", value)
+		config, err = configureTimescale()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !config.enabled || config.compressAfter != expected {
+			t.Errorf("%s: unexpected config %+v", value, config)
+		}
+	}
+
+	for name, value := range map[string]string{
+		"TIMESCALE":                "maybe",
+		"TIMESCALE_COMPRESS_AFTER": "-1d",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err = configureTimescale()
+			if err == nil {
+				t.Errorf("expected error got nil")
+			}
+		})
+	}
+}
+
+func TestConfigureRetention(t *testing.T) {
+	for _, name := range []string{"RETENTION_INTERVAL", "RETENTION_MAX_AGE", "RETENTION_MAX_POINTS", "RETENTION_MAX_BYTES", "RETENTION_DRY_RUN", "ARCHIVE_DIR"} {
+		t.Setenv(name, "")
+	}
+	config, err := configureRetention()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.interval != 24*time.Hour || config.policy != storage.DEFAULT_RETENTION_POLICY || config.archive != nil {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	t.Setenv("RETENTION_INTERVAL", "1h")
+	t.Setenv("RETENTION_MAX_AGE", "30d")
+	t.Setenv("RETENTION_MAX_POINTS", "1000000")
+	t.Setenv("RETENTION_MAX_BYTES", "50GB")
+	t.Setenv("RETENTION_DRY_RUN", "true")
+	config, err = configureRetention()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := storage.RetentionPolicy{MaxAge: 30 * 24 * time.Hour, MaxPoints: 1000000, MaxBytes: 50_000_000_000, DryRun: true}
+	if config.interval != time.Hour || config.policy != expected {
+		t.Errorf("expected %+v got %+v", expected, config.policy)
+	}
+
+	t.Setenv("RETENTION_MAX_AGE", "0")
+	t.Setenv("RETENTION_MAX_BYTES", "1024")
+	t.Setenv("ARCHIVE_DIR", filepath.Join(t.TempDir(), "archive"))
+	config, err = configureRetention()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.policy.MaxAge != 0 || config.policy.MaxBytes != 1024 || config.archive == nil {
+		t.Errorf("unexpected policy %+v", config.policy)
+	}
+
+	for name, value := range map[string]string{
+		"RETENTION_INTERVAL":   "0s",
+		"RETENTION_MAX_AGE":    "ad",
+		"RETENTION_MAX_POINTS": "a",
+		"RETENTION_MAX_BYTES":  "1.5GB",
+		"RETENTION_DRY_RUN":    "maybe",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err = configureRetention()
+			if err == nil {
+				t.Errorf("expected error got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Package columnar encodes points in columns, one column per field of models.TelemetryPoint.
+//
+// The columns are written one after the other, after their count. A column is the length of its name, its name,
+// the size of its values and its values. Sizes and counts are unsigned varints. The values are split in byte planes,
+// optionally after replacing every value by its difference with the previous one.
+// The CREATED_AT_COLUMN values are the signed varint differences in nanoseconds with the previous point, of size 0.
+// Columns unknown to the reader are skipped, fields without column are left to zero,
+// so encoded points outlive the changes of models.TelemetryPoint.
+package columnar
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+
+	"forzatelemetry/models"
+)
+
+// Name of the column of the creation times of the points, the only column which isn't a field of models.TelemetryPoint
+const CREATED_AT_COLUMN = "CreatedAt"
+
+// Encoding of the values of the columns, the reader must use the encoding of the writer
+type Encoding int
+
+const (
+	// Values split in byte planes
+	PLANES Encoding = iota
+	// Differences with the previous values split in byte planes: XOR for floats, subtraction for integers.
+	// Telemetry channels change slowly, most of the high bytes of the differences are 0.
+	DELTA_PLANES
+)
+
+// A column holds the values of a field of models.TelemetryPoint for every point
+type column struct {
+	name  string
+	index int // of the field in models.TelemetryPoint
+	size  int // bytes per value
+	kind  reflect.Kind
+}
+
+// columns of the fields of models.TelemetryPoint, in the order of the struct
+var columns = telemetryColumns()
+
+var columnsByName = func() map[string]column {
+	byName := make(map[string]column, len(columns))
+	for _, c := range columns {
+		byName[c.name] = c
+	}
+	return byName
+}()
+
+func telemetryColumns() []column {
+	typ := reflect.TypeFor[models.TelemetryPoint]()
+	result := make([]column, 0, typ.NumField())
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		result = append(result, column{name: field.Name, index: i, size: int(field.Type.Size()), kind: field.Type.Kind()})
+	}
+	return result
+}
+
+// Append appends the columns of the points, in the order of the slice
+func Append(buf []byte, points []models.Point, encoding Encoding) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(columns)+1))
+
+	buf = appendColumnHeader(buf, CREATED_AT_COLUMN, 0)
+	buf = appendCreatedAt(buf, points)
+	for _, c := range columns {
+		buf = appendColumnHeader(buf, c.name, c.size)
+		buf = appendColumn(buf, c, points, encoding)
+	}
+	return buf
+}
+
+func appendColumnHeader(buf []byte, name string, size int) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(name)))
+	buf = append(buf, name...)
+	return binary.AppendUvarint(buf, uint64(size))
+}
+
+// Read reads the columns written by Append into points, which must have the length of the slice appended
+func Read(br *bufio.Reader, points []models.Point, encoding Encoding) error {
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+	for range count {
+		err = readColumnOf(br, points, encoding)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readColumnOf(br *bufio.Reader, points []models.Point, encoding Encoding) error {
+	name, err := ReadBytes(br)
+	if err != nil {
+		return err
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+
+	if string(name) == CREATED_AT_COLUMN {
+		return readCreatedAt(br, points)
+	}
+	c, ok := columnsByName[string(name)]
+	if !ok {
+		_, err = br.Discard(int(size) * len(points))
+		return err
+	}
+	if uint64(c.size) != size {
+		return fmt.Errorf("column %s: expected %d bytes values got %d", name, c.size, size)
+	}
+	return readColumn(br, c, points, encoding)
+}
+
+// ReadBytes reads a slice prefixed by its length as an unsigned varint
+func ReadBytes(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(br, b)
+	return b, err
+}
+
+// bits returns the value of the column as an unsigned integer, the size of the column is kept when it's written
+func (c column) bits(point reflect.Value) uint64 {
+	v := point.Field(c.index)
+	switch c.kind {
+	case reflect.Float32:
+		return uint64(math.Float32bits(float32(v.Float())))
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return uint64(v.Int())
+	default:
+		return v.Uint()
+	}
+}
+
+func (c column) set(point reflect.Value, bits uint64) {
+	v := point.Field(c.index)
+	switch c.kind {
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(bits))))
+	case reflect.Int8:
+		v.SetInt(int64(int8(bits)))
+	case reflect.Int16:
+		v.SetInt(int64(int16(bits)))
+	case reflect.Int32:
+		v.SetInt(int64(int32(bits)))
+	default:
+		v.SetUint(bits)
+	}
+}
+
+// delta returns the difference between bits and the previous value, only the bytes of the size of the column are kept
+func (c column) delta(bits, previous uint64) uint64 {
+	if c.kind == reflect.Float32 {
+		return bits ^ previous
+	}
+	return bits - previous
+}
+
+func (c column) undelta(delta, previous uint64) uint64 {
+	if c.kind == reflect.Float32 {
+		return delta ^ previous
+	}
+	return delta + previous
+}
+
+// appendColumn appends the values of the column, split in byte planes: the first byte of every value, then the second byte...
+// Neighbouring values of telemetry share their high bytes, the planes compress much better than the values.
+func appendColumn(buf []byte, c column, points []models.Point, encoding Encoding) []byte {
+	n := len(points)
+	start := len(buf)
+	buf = append(buf, make([]byte, n*c.size)...)
+	planes := buf[start:]
+	var previous uint64
+	for i := range points {
+		bits := c.bits(reflect.ValueOf(&points[i].TelemetryPoint).Elem())
+		value := bits
+		if encoding == DELTA_PLANES {
+			value = c.delta(bits, previous)
+			previous = bits
+		}
+		for b := range c.size {
+			planes[b*n+i] = byte(value >> (8 * b))
+		}
+	}
+	return buf
+}
+
+// readColumn reads the byte planes of a column into points
+func readColumn(r io.Reader, c column, points []models.Point, encoding Encoding) error {
+	n := len(points)
+	planes := make([]byte, n*c.size)
+	_, err := io.ReadFull(r, planes)
+	if err != nil {
+		return fmt.Errorf("column %s: %w", c.name, err)
+	}
+	var previous uint64
+	for i := range points {
+		var bits uint64
+		for b := range c.size {
+			bits |= uint64(planes[b*n+i]) << (8 * b)
+		}
+		if encoding == DELTA_PLANES {
+			bits = c.undelta(bits, previous) & (1<<(8*c.size) - 1)
+			previous = bits
+		}
+		c.set(reflect.ValueOf(&points[i].TelemetryPoint).Elem(), bits)
+	}
+	return nil
+}
+
+// appendCreatedAt appends the creation times as the difference in nanoseconds with the previous point
+func appendCreatedAt(buf []byte, points []models.Point) []byte {
+	var previous int64
+	for _, point := range points {
+		t := point.CreatedAt.UnixNano()
+		buf = binary.AppendVarint(buf, t-previous)
+		previous = t
+	}
+	return buf
+}
+
+func readCreatedAt(r io.ByteReader, points []models.Point) error {
+	var previous int64
+	for i := range points {
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", CREATED_AT_COLUMN, err)
+		}
+		previous += delta
+		points[i].CreatedAt = time.Unix(0, previous)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package columnar_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/columnar"
+	"forzatelemetry/models"
+	"forzatelemetry/testutils"
+)
+
+func testPoints(n int) []models.Point {
+	start := time.Now()
+	points := make([]models.Point, n)
+	for i := range points {
+		points[i] = testutils.Point(uuid.Nil, start.Add(time.Duration(i)*16*time.Millisecond), 1)
+		points[i].TimestampMS = math.MaxUint32 - 100 + uint32(i) // overflows to 0
+		points[i].Speed = 50 + float32(math.Sin(float64(i)/100))
+		points[i].Steer = int8(i%256 - 128)
+		points[i].NormalizedDrivingLine = int8(-i)
+		points[i].CurrentLap = float32(i) / 60
+		points[i].EngineCurrentRPM = float32(math.NaN())
+	}
+	return points
+}
+
+func TestAppendRead(t *testing.T) {
+	points := testPoints(500)
+	for _, encoding := range []columnar.Encoding{columnar.PLANES, columnar.DELTA_PLANES} {
+		buf := columnar.Append(nil, points, encoding)
+
+		read := make([]models.Point, len(points))
+		err := columnar.Read(bufio.NewReader(bytes.NewReader(buf)), read, encoding)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for i := range points {
+			// NaN isn't equal to itself
+			if math.Float32bits(read[i].EngineCurrentRPM) != math.Float32bits(points[i].EngineCurrentRPM) {
+				t.Fatalf("%v: point %v: expected %v got %v", encoding, i, points[i].EngineCurrentRPM, read[i].EngineCurrentRPM)
+			}
+			expected := points[i]
+			expected.EngineCurrentRPM, read[i].EngineCurrentRPM = 0, 0
+			if read[i].TelemetryPoint != expected.TelemetryPoint || !read[i].CreatedAt.Equal(points[i].CreatedAt) {
+				t.Fatalf("%v: point %v: expected %+v got %+v", encoding, i, points[i], read[i])
+			}
+		}
+	}
+}
+
+func TestDeltaPlanesSize(t *testing.T) {
+	points := testPoints(3600)
+	compressed := func(encoding columnar.Encoding) int {
+		var buf bytes.Buffer
+		w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		w.Write(columnar.Append(nil, points, encoding))
+		w.Close()
+		return buf.Len()
+	}
+	planes, delta := compressed(columnar.PLANES), compressed(columnar.DELTA_PLANES)
+	if delta >= planes {
+		t.Errorf("expected less than %v bytes got %v", planes, delta)
+	}
+}
+
+func TestReadUnknownColumn(t *testing.T) {
+	buf := binary.AppendUvarint(nil, 2)
+	// unknown column
+	buf = binary.AppendUvarint(buf, 7)
+	buf = append(buf, "Removed"...)
+	buf = binary.AppendUvarint(buf, 4)
+	buf = append(buf, make([]byte, 2*4)...)
+	// known column
+	buf = binary.AppendUvarint(buf, 5)
+	buf = append(buf, "Speed"...)
+	buf = binary.AppendUvarint(buf, 4)
+	// 1.0 twice, the planes of the bytes of 0x3f800000 and of the XOR with the previous value, 0
+	buf = append(buf, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x3f, 0x00)
+
+	points := make([]models.Point, 2)
+	err := columnar.Read(bufio.NewReader(bytes.NewReader(buf)), points, columnar.DELTA_PLANES)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if points[0].Speed != 1 || points[1].Speed != 1 {
+		t.Errorf("expected 1 1 got %v %v", points[0].Speed, points[1].Speed)
+	}
+}
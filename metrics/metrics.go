@@ -0,0 +1,280 @@
+// Package metrics implements the counters, gauges and histograms we export in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buckets for durations in seconds, from 1ms to 10s
+var DURATION_BUCKETS = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+type Registry struct {
+	m       sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(m metric) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, ok := r.metrics[m.name()]; ok {
+		panic(fmt.Sprintf("metric %s already registered", m.name()))
+	}
+	r.metrics[m.name()] = m
+}
+
+// Write writes every metric in the Prometheus text format, ordered by name
+func (r *Registry) Write(w io.Writer) {
+	r.m.Lock()
+	metrics := make([]metric, 0, len(r.metrics))
+	for _, name := range sortedKeys(r.metrics) {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.m.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// desc holds what is common to all the metric types: the name, the help and the labels
+type desc struct {
+	m      sync.Mutex
+	n      string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.n
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.n, d.help, d.n, d.typ)
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values got %d", d.n, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// format returns the labels of a series, extra is added as is, e.g. `le="0.1"`
+func (d *desc) format(key string, extra string) string {
+	var parts []string
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			parts = append(parts, d.labels[i]+`="`+labelEscaper.Replace(value)+`"`)
+		}
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labelEscaper escapes label values the way the Prometheus text format does, other characters are written as is
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a value that only goes up, one series per combination of label values
+type Counter struct {
+	desc
+	values map[string]float64
+}
+
+func NewCounter(registry *Registry, name string, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{n: name, help: help, typ: "counter", labels: labels}, values: make(map[string]float64)}
+	registry.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+func (c *Counter) Add(v float64, labels ...string) {
+	key := c.key(labels)
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.values[key] += v
+}
+
+func (c *Counter) Value(labels ...string) float64 {
+	key := c.key(labels)
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.header(w)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.n, c.format(key, ""), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge is a value that goes up and down, one series per combination of label values
+type Gauge struct {
+	desc
+	values map[string]float64
+}
+
+func NewGauge(registry *Registry, name string, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{n: name, help: help, typ: "gauge", labels: labels}, values: make(map[string]float64)}
+	registry.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labels ...string) {
+	key := g.key(labels)
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.values[key] = v
+}
+
+func (g *Gauge) Add(v float64, labels ...string) {
+	key := g.key(labels)
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.values[key] += v
+}
+
+func (g *Gauge) Value(labels ...string) float64 {
+	key := g.key(labels)
+	g.m.Lock()
+	defer g.m.Unlock()
+	return g.values[key]
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	g.header(w)
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.n, g.format(key, ""), formatFloat(g.values[key]))
+	}
+}
+
+// Histogram counts observations in cumulative buckets, one series per combination of label values
+type Histogram struct {
+	desc
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(registry *Registry, name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{n: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	registry.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := h.key(labels)
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		value.counts[i]++
+	}
+	value.count++
+	value.sum += v
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count(labels ...string) uint64 {
+	key := h.key(labels)
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	value, ok := h.values[key]
+	if !ok {
+		return 0
+	}
+	return value.count
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.header(w)
+	for _, key := range sortedKeys(h.values) {
+		value := h.values[key]
+		var cumulative uint64
+		for i, bucket := range h.buckets {
+			cumulative += value.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, h.format(key, fmt.Sprintf("le=%q", formatFloat(bucket))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, h.format(key, `le="+Inf"`), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.n, h.format(key, ""), formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.n, h.format(key, ""), value.count)
+	}
+}
@@ -0,0 +1,77 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"forzatelemetry/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := metrics.NewRegistry()
+	counter := metrics.NewCounter(registry, "test_counter_total", "A counter.", "reason")
+	gauge := metrics.NewGauge(registry, "test_gauge", "A gauge.")
+	histogram := metrics.NewHistogram(registry, "test_duration_seconds", "A histogram.", []float64{0.1, 1}, "route")
+
+	counter.Inc("b")
+	counter.Add(2, "a\"\\\né")
+	gauge.Set(5)
+	gauge.Add(-1)
+	histogram.Observe(0.05, "/")
+	histogram.Observe(0.5, "/")
+	histogram.Observe(5, "/")
+
+	if counter.Value("b") != 1 {
+		t.Errorf("expected 1 got %v", counter.Value("b"))
+	}
+	if gauge.Value() != 4 {
+		t.Errorf("expected 4 got %v", gauge.Value())
+	}
+	if histogram.Count("/") != 3 {
+		t.Errorf("expected 3 got %v", histogram.Count("/"))
+	}
+
+	var buf bytes.Buffer
+	registry.Write(&buf)
+	expected := `# HELP test_counter_total A counter.
+# TYPE test_counter_total counter
+test_counter_total{reason="a\"\\\né"} 2
+test_counter_total{reason="b"} 1
+# HELP test_duration_seconds A histogram.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/",le="0.1"} 1
+test_duration_seconds_bucket{route="/",le="1"} 2
+test_duration_seconds_bucket{route="/",le="+Inf"} 3
+test_duration_seconds_sum{route="/"} 5.55
+test_duration_seconds_count{route="/"} 3
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 4
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, buf.String())
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	registry := metrics.NewRegistry()
+	metrics.NewCounter(registry, "test_total", "A counter.")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+	metrics.NewGauge(registry, "test_total", "A gauge.")
+}
+
+func TestLabelsMismatch(t *testing.T) {
+	counter := metrics.NewCounter(metrics.NewRegistry(), "test_total", "A counter.", "reason")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+	counter.Inc()
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/uptrace/bun"
+)
+
+// Driver is who drove the races sent from its sources
+type Driver struct {
+	bun.BaseModel
+
+	ID      int64          `bun:",pk,autoincrement" json:"id"`
+	Name    string         `bun:",unique,notnull" json:"name"`
+	Sources []DriverSource `bun:"rel:has-many,join:id=driver_id" json:"sources,omitempty"`
+}
+
+// DriverSource binds a source address to a driver. Source is an IP, matching every port, or an IP:port pair.
+type DriverSource struct {
+	bun.BaseModel
+
+	Source   string `bun:",pk" json:"source"`
+	DriverID int64  `bun:",notnull" json:"-"`
+}
+
+// ParseSource validates a driver source, an IP or an IP:port pair, and returns it in the form telemetry sources are matched with
+func ParseSource(source string) (string, error) {
+	if addr, err := netip.ParseAddr(source); err == nil {
+		return addr.Unmap().String(), nil
+	}
+	addrPort, err := netip.ParseAddrPort(source)
+	if err != nil {
+		return "", fmt.Errorf("invalid source %q, expected an IP or an IP:port pair", source)
+	}
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()).String(), nil
+}
+
+// SourceCandidates returns the sources matching a telemetry source address, the most specific first
+func SourceCandidates(addr string) []string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{addr}
+	}
+	return []string{addr, host}
+}
@@ -0,0 +1,32 @@
+package models_test
+
+import (
+	"testing"
+
+	"forzatelemetry/models"
+)
+
+func TestParseSource(t *testing.T) {
+	for source, expected := range map[string]string{
+		"192.168.1.10":        "192.168.1.10",
+		"192.168.1.10:5000":   "192.168.1.10:5000",
+		"::ffff:192.168.1.10": "192.168.1.10",
+		"[FE80::1]:5000":      "[fe80::1]:5000",
+		"":                    "",
+		"localhost":           "",
+		"192.168.1.256":       "",
+		"192.168.1.10:port":   "",
+		"192.168.1.0/24":      "",
+	} {
+		parsed, err := models.ParseSource(source)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("%q: expected error got %v", source, parsed)
+			}
+			continue
+		}
+		if err != nil || parsed != expected {
+			t.Errorf("%q: expected %v got %v %v", source, expected, parsed, err)
+		}
+	}
+}
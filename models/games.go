@@ -0,0 +1,82 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Game identifies the Forza title that sent the telemetry
+type Game string
+
+const (
+	GAME_FM7 Game = "fm7" // Forza Motorsport 7
+	GAME_FM8 Game = "fm8" // Forza Motorsport (2023)
+	GAME_FH  Game = "fh"  // Forza Horizon 4 and 5 share the same packet layout
+)
+
+// Horizon packets don't carry a track. Use an ordinal no track has so races are not attributed to track 0
+const UNKNOWN_TRACK int32 = -1
+
+// Fields is a set of the TelemetryPoint sections a packet format carries.
+// Sections a format lacks are left to zero in TelemetryPoint, Fields tells them apart from real zero values.
+type Fields uint8
+
+const (
+	FIELDS_SLED      Fields = 1 << iota // OnTrack to NumCylinders
+	FIELDS_DASH                         // PositionX to NormalizedAIBrakeDifference
+	FIELDS_TIRE_WEAR                    // TireWearFrontLeft to TireWearRearRight
+	FIELDS_TRACK                        // TrackOrdinal
+
+	FIELDS_ALL = FIELDS_SLED | FIELDS_DASH | FIELDS_TIRE_WEAR | FIELDS_TRACK
+)
+
+var fieldsNames = []struct {
+	fields Fields
+	name   string
+}{
+	{FIELDS_SLED, "sled"},
+	{FIELDS_DASH, "dash"},
+	{FIELDS_TIRE_WEAR, "tireWear"},
+	{FIELDS_TRACK, "track"},
+}
+
+func (f Fields) Has(fields Fields) bool {
+	return f&fields == fields
+}
+
+func (f Fields) Names() []string {
+	names := []string{}
+	for _, n := range fieldsNames {
+		if f.Has(n.fields) {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (f Fields) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.Names())
+}
+
+func (f *Fields) UnmarshalJSON(data []byte) error {
+	var names []string
+	err := json.Unmarshal(data, &names)
+	if err != nil {
+		return err
+	}
+
+	*f = 0
+	for _, name := range names {
+		found := false
+		for _, n := range fieldsNames {
+			if n.name == name {
+				*f |= n.fields
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown fields %q", name)
+		}
+	}
+	return nil
+}
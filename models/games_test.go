@@ -0,0 +1,43 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"forzatelemetry/models"
+)
+
+func TestFieldsJSON(t *testing.T) {
+	fields := models.FIELDS_SLED | models.FIELDS_DASH
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if string(data) != `["sled","dash"]` {
+		t.Errorf("expected %v got %v", `["sled","dash"]`, string(data))
+	}
+
+	var decoded models.Fields
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if decoded != fields {
+		t.Errorf("expected %v got %v", fields, decoded)
+	}
+
+	err = json.Unmarshal([]byte(`["foo"]`), &decoded)
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+}
+
+func TestFieldsHas(t *testing.T) {
+	if !models.FIELDS_ALL.Has(models.FIELDS_TRACK) {
+		t.Errorf("expected true got false")
+	}
+	if models.FIELDS_SLED.Has(models.FIELDS_SLED | models.FIELDS_DASH) {
+		t.Errorf("expected false got true")
+	}
+}
@@ -148,6 +148,23 @@ type Point struct {
 	CreatedAt time.Time
 }
 
+// LapChunk holds the points of a finished lap of a race, compressed in columns
+type LapChunk struct {
+	bun.BaseModel `bun:"table:lap_chunks"`
+
+	Race      uuid.UUID `bun:"type:uuid,pk"`
+	LapNumber uint16    `bun:"type:INTEGER,pk"`
+	StartedAt time.Time // creation time of the first point
+	// Last point of the lap
+	FinishedAt      time.Time
+	CurrentLap      float32
+	CurrentRaceTime float32
+	RacePosition    uint8
+
+	Points int    // count of points
+	Data   []byte // points encoded by the storage package
+}
+
 func (p Point) ToProto() *ApiPoint {
 	return &ApiPoint{
 		RaceTime:           p.CurrentRaceTime,
@@ -12,19 +12,28 @@ type Race struct {
 
 	ID        uuid.UUID `bun:"type:uuid,unique,pk"`
 	SessionID uuid.UUID `bun:"type:uuid" json:"sessionID"`
+	Game      Game      `bun:",nullzero,notnull,default:'fm8'" json:"game"`
+	Fields    Fields    `bun:",nullzero,notnull,default:15" json:"fields"` // telemetry sections sent by the game, others are zero
 
 	Paused     bool `json:"paused"`
 	InProgress bool `json:"inProgress"`
+	Pinned     bool `bun:",notnull" json:"pinned"`   // kept by the cleanup
+	Archived   bool `bun:",notnull" json:"archived"` // points moved to an archive by the cleanup
+	Compacted  bool `bun:",notnull" json:"-"`        // every lap is packed in lap chunks, with storage.LAYOUT_LAPS
 
 	StartedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"startedAt"`
 	FinishedAt time.Time `json:"finishedAt" bun:",nullzero"`
+	RestoredAt time.Time `json:"restoredAt" bun:",nullzero"` // last import of the points from the archive
 
 	Car                 int32 `json:"car"`
 	CarClass            int32 `json:"carClass"`
 	CarPerformanceIndex int32 `json:"carPerformanceIndex"`
+	Drivetrain          int32 `bun:",notnull" json:"drivetrain"` // 0 = FWD, 1 = RWD, 2 = AWD
 
 	Track int32 `json:"track"`
 
+	Driver int64 `bun:",nullzero" json:"driver"` // 0 when the source isn't bound to a driver
+
 	BestLap          float32 `json:"bestLap"`
 	RaceTime         float32 `json:"raceTime"`
 	Position         uint8   `json:"position"`
@@ -45,15 +54,18 @@ func (r Race) End(point Point) Race {
 	return r.Update(point)
 }
 
-func MakeRace(p TelemetryPoint, sessionId uuid.UUID) Race {
+func MakeRace(p TelemetryPoint, sessionId uuid.UUID, game Game, fields Fields) Race {
 	race := Race{
 		ID:                  uuid.New(),
 		Paused:              p.OnTrack == 0,
 		InProgress:          true,
 		SessionID:           sessionId,
+		Game:                game,
+		Fields:              fields,
 		Car:                 p.CarOrdinal,
 		CarClass:            p.CarClass,
 		CarPerformanceIndex: p.CarPerformanceIndex,
+		Drivetrain:          p.DrivetrainType,
 		Track:               p.TrackOrdinal,
 	}
 	return race
@@ -66,6 +78,7 @@ type APIRace struct {
 	TrackMetadata    Track    `json:"trackMetadata" bun:"rel:belongs-to,join:track=ordinal"`
 	CarMetadata      Car      `json:"carMetadata" bun:"rel:belongs-to,join:car=ordinal"`
 	CarClassMetadata CarClass `json:"carClassMetadata" bun:"rel:belongs-to,join:car_class=id"`
+	DriverMetadata   Driver   `json:"driverMetadata" bun:"rel:belongs-to,join:driver=id"`
 	Dashboard        string   `json:"dashboard"`
 }
 
@@ -11,7 +11,7 @@ func TestRace(t *testing.T) {
 	point := testutils.Point(testutils.ParseUUID("7f753007-0eda-4aec-8d25-de6ac96220fc"), testutils.ParseTime("2024-09-08T17:39:10Z"), 0)
 	session := testutils.ParseUUID("0c5d98f4-3df8-4a60-88d7-a1ecdecea57c")
 
-	race := models.MakeRace(point.TelemetryPoint, session)
+	race := models.MakeRace(point.TelemetryPoint, session, models.GAME_FM8, models.FIELDS_ALL)
 
 	if !race.Paused {
 		t.Errorf("expected %v got %v", true, race.Paused)
@@ -35,7 +35,7 @@ func TestRaceDetailled(t *testing.T) {
 	point := testutils.Point(testutils.ParseUUID("7f753007-0eda-4aec-8d25-de6ac96220fc"), testutils.ParseTime("2024-09-08T17:39:10Z"), 0)
 	session := testutils.ParseUUID("0c5d98f4-3df8-4a60-88d7-a1ecdecea57c")
 
-	race := models.MakeRace(point.TelemetryPoint, session)
+	race := models.MakeRace(point.TelemetryPoint, session, models.GAME_FM8, models.FIELDS_ALL)
 	raceDetailled := models.MakeRaceDetailled(models.APIRace{Race: race}, nil)
 
 	if raceDetailled.Laps == nil {
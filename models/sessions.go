@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Session is the stream of packets of a telemetry source, from its first packet until it goes silent or changes format.
+// Races of a session share its SessionID, a race resumed by the next session of the source moves to that session.
+type Session struct {
+	bun.BaseModel
+
+	ID     uuid.UUID `bun:"type:uuid,unique,pk" json:"id"`
+	Source string    `json:"source"` // address the packets came from
+	Game   Game      `bun:",nullzero,notnull,default:'fm8'" json:"game"`
+	Format string    `json:"format"`
+	// ID of the capture of the packets of the source, if captured. The sessions of a format change share the capture.
+	Capture uuid.UUID `bun:"type:uuid,nullzero" json:"capture,omitempty"`
+
+	StartedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"startedAt"`
+	FinishedAt time.Time `bun:",nullzero" json:"finishedAt"`
+
+	Received int64 `json:"received"` // packets processed by the session
+	Dropped  int64 `json:"dropped"`  // packets dropped because the session channel was full
+}
+
+type APISession struct {
+	bun.BaseModel `bun:"table:sessions,alias:sessions"`
+	Session
+
+	Races int `bun:",scanonly" json:"races"`
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+
+	"forzatelemetry/models"
+
+	"github.com/uptrace/bun"
+)
+
+// Rows per INSERT of points. A point has about 90 columns, keeps the statements below the SQLite limit of 32766 parameters.
+const POINTS_INSERT_ROWS = 300
+
+// WriteBatch saves sessions, races and points in one transaction, a failed batch can be written again.
+// Sessions and races are upserted, points are inserted with COPY on PostgreSQL.
+// With LAYOUT_LAPS, the points are packed in lap chunks later by CompactLaps, a batch costs the same whatever the length of the laps.
+// Their races are flagged to be checked again by CompactLaps.
+func (s *Store) WriteBatch(ctx context.Context, sessions []models.Session, races []models.Race, points []models.Point) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if len(sessions) > 0 {
+			err := upsertSessions(ctx, tx, sessions)
+			if err != nil {
+				return err
+			}
+		}
+		if len(races) > 0 {
+			err := upsertRaces(ctx, tx, races)
+			if err != nil {
+				return err
+			}
+		}
+		err := s.insertPoints(ctx, conn, tx, points)
+		if err != nil || s.layout != LAYOUT_LAPS || len(points) == 0 {
+			return err
+		}
+		return uncompact(ctx, tx, points)
+	})
+}
@@ -0,0 +1,87 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+	"forzatelemetry/storage"
+	"forzatelemetry/testutils"
+)
+
+func TestWriteBatch(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+	testWriteBatch(t, db)
+}
+
+// Points are sent with COPY on PostgreSQL, only tested with a database to write to
+func TestWriteBatchPG(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	db, err := storage.NewPGStore(dsn)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer db.Close()
+	err = db.CreateTables(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	testWriteBatch(t, db)
+}
+
+func testWriteBatch(t *testing.T, db *storage.Store) {
+	session := models.Session{
+		ID:        uuid.New(),
+		Source:    "127.0.0.1:5000",
+		Game:      models.GAME_FM8,
+		StartedAt: time.Now(),
+	}
+	race := models.Race{
+		ID:        uuid.New(),
+		SessionID: session.ID,
+	}
+	// more points than a single INSERT
+	points := make([]models.Point, 2*storage.POINTS_INSERT_ROWS+10)
+	for i := range points {
+		points[i] = testutils.Point(race.ID, time.Now().Add(time.Duration(i)*time.Millisecond), 1)
+	}
+
+	err := db.WriteBatch(context.Background(), []models.Session{session}, []models.Race{race}, points)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// upserted again with the next batch
+	race.InProgress = true
+	err = db.WriteBatch(context.Background(), []models.Session{session}, []models.Race{race}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	saved, err := db.SelectSession(session.ID.String(), context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if saved.Races != 1 {
+		t.Errorf("expected 1 got %v", saved.Races)
+	}
+
+	count := 0
+	for _, err := range db.IterPoints(race.ID.String(), []storage.Where{}, context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		count++
+	}
+	if count != len(points) {
+		t.Errorf("expected %v got %v", len(points), count)
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"forzatelemetry/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+)
+
+// insertPoints inserts points with COPY on PostgreSQL and POINTS_INSERT_ROWS at a time with INSERT on SQLite.
+// db is conn or a transaction started on conn, COPY runs on the connection of the transaction.
+func (s *Store) insertPoints(ctx context.Context, conn bun.Conn, db bun.IDB, points []models.Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	if s.IsPG() {
+		return s.copyPoints(ctx, conn, points)
+	}
+
+	for start := 0; start < len(points); start += POINTS_INSERT_ROWS {
+		chunk := points[start:min(start+POINTS_INSERT_ROWS, len(points))]
+		_, err := db.NewInsert().Model(&chunk).Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyPoints sends points with the binary COPY protocol, much cheaper than an INSERT of about 90 columns per point
+func (s *Store) copyPoints(ctx context.Context, conn bun.Conn, points []models.Point) error {
+	table := s.db.Table(reflect.TypeFor[models.Point]())
+	columns := make([]string, len(table.Fields))
+	for i, field := range table.Fields {
+		columns[i] = field.Name
+	}
+
+	return conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("copy points: unexpected driver connection %T", driverConn)
+		}
+		_, err := c.Conn().CopyFrom(ctx, pgx.Identifier{table.Name}, columns, &pointsSource{
+			fields: table.Fields,
+			points: points,
+			values: make([]any, len(table.Fields)),
+			index:  -1,
+		})
+		return err
+	})
+}
+
+// pointsSource reads the values of the points in the order of the columns of the points table
+type pointsSource struct {
+	fields []*schema.Field
+	points []models.Point
+	values []any
+	index  int
+}
+
+func (s *pointsSource) Next() bool {
+	s.index++
+	return s.index < len(s.points)
+}
+
+func (s *pointsSource) Values() ([]any, error) {
+	point := reflect.ValueOf(&s.points[s.index]).Elem()
+	for i, field := range s.fields {
+		s.values[i] = field.Value(point).Interface()
+	}
+	return s.values, nil
+}
+
+func (s *pointsSource) Err() error {
+	return nil
+}
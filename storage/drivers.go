@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+
+	"forzatelemetry/models"
+
+	"github.com/uptrace/bun"
+)
+
+// UpsertDrivers creates the drivers or updates them by name. The sources of each driver are replaced,
+// a source bound to another driver is moved.
+func (s *Store) UpsertDrivers(ctx context.Context, drivers ...models.Driver) error {
+	return s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, driver := range drivers {
+			_, err := tx.NewInsert().Model(&driver).On("CONFLICT (name) DO UPDATE").Set("name = EXCLUDED.name").Returning("id").Exec(ctx)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.NewDelete().Model((*models.DriverSource)(nil)).Where("driver_id = ?", driver.ID).Exec(ctx)
+			if err != nil {
+				return err
+			}
+			if len(driver.Sources) == 0 {
+				continue
+			}
+			for i := range driver.Sources {
+				driver.Sources[i].DriverID = driver.ID
+			}
+			_, err = tx.NewInsert().Model(&driver.Sources).On("CONFLICT (source) DO UPDATE").Set("driver_id = EXCLUDED.driver_id").Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) SelectDrivers(ctx context.Context) ([]models.Driver, error) {
+	drivers := make([]models.Driver, 0)
+	err := s.db.NewSelect().Model(&drivers).Relation("Sources").Order("name ASC").Scan(ctx)
+	return drivers, err
+}
+
+// SelectDriverBySource returns the driver bound to the source address, an IP:port binding is preferred to an IP binding
+func (s *Store) SelectDriverBySource(addr string, ctx context.Context) (models.Driver, error) {
+	var driver models.Driver
+	err := s.db.NewSelect().Model(&driver).
+		Join("JOIN driver_sources AS ds ON ds.driver_id = driver.id").
+		Where("ds.source IN (?)", bun.In(models.SourceCandidates(addr))).
+		OrderExpr("length(ds.source) DESC").Limit(1).Scan(ctx)
+	return driver, err
+}
+
+// DeleteDriver deletes the driver and its sources, its races are kept
+func (s *Store) DeleteDriver(name string, ctx context.Context) error {
+	return s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var driver models.Driver
+		err := tx.NewSelect().Model(&driver).Where("name = ?", name).Scan(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().Model((*models.DriverSource)(nil)).Where("driver_id = ?", driver.ID).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().Model(&driver).WherePK().Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,80 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"forzatelemetry/models"
+	"forzatelemetry/testutils"
+)
+
+func TestSelectDriverBySource(t *testing.T) {
+	db := testutils.NewStore("drivers.yaml")
+	defer db.Close()
+
+	for source, expected := range map[string]string{
+		"192.168.1.10:4000": "alice",
+		"192.168.1.10:5000": "bob",
+		"192.168.1.11:4000": "bob",
+		"192.168.1.12:4000": "",
+	} {
+		driver, err := db.SelectDriverBySource(source, context.Background())
+		if expected == "" {
+			if err == nil {
+				t.Errorf("%s: expected error got %v", source, driver)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", source, err)
+		}
+		if driver.Name != expected {
+			t.Errorf("%s: expected %v got %v", source, expected, driver.Name)
+		}
+	}
+}
+
+func TestUpsertDrivers(t *testing.T) {
+	db := testutils.NewStore("drivers.yaml")
+	defer db.Close()
+
+	// alice takes the source of bob and a new one, carol is created
+	err := db.UpsertDrivers(context.Background(),
+		models.Driver{Name: "alice", Sources: []models.DriverSource{{Source: "192.168.1.11"}, {Source: "192.168.1.12"}}},
+		models.Driver{Name: "carol"},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	drivers, err := db.SelectDrivers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(drivers) != 3 {
+		t.Fatalf("expected 3 got %v", len(drivers))
+	}
+	if drivers[0].Name != "alice" || drivers[0].ID != 1 || len(drivers[0].Sources) != 2 {
+		t.Errorf("unexpected driver %+v", drivers[0])
+	}
+	if drivers[1].Name != "bob" || len(drivers[1].Sources) != 1 || drivers[1].Sources[0].Source != "192.168.1.10:5000" {
+		t.Errorf("unexpected driver %+v", drivers[1])
+	}
+	if drivers[2].Name != "carol" || len(drivers[2].Sources) != 0 {
+		t.Errorf("unexpected driver %+v", drivers[2])
+	}
+
+	err = db.DeleteDriver("bob", context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// alice doesn't have 192.168.1.10 anymore
+	_, err = db.SelectDriverBySource("192.168.1.10:5000", context.Background())
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+	err = db.DeleteDriver("bob", context.Background())
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+}
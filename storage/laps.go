@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"forzatelemetry/columnar"
+	"forzatelemetry/models"
+)
+
+// Format of the data of the lap chunks, the first byte of the data, before the compressed columns
+const LAP_CHUNK_VERSION = 1
+
+// PointsLayout decides how the points of the finished laps are stored.
+// Points are always read from both layouts, a race can have laps in both.
+type PointsLayout int
+
+const (
+	// A row of the points table per point, the layout read by the Grafana dashboards
+	LAYOUT_ROWS PointsLayout = iota
+	// Points are written to the points table, then the points of every finished lap are packed in a row of the lap_chunks
+	// table, their channels delta encoded in compressed columns. Laps are finished when a point of a later lap is written,
+	// every lap of a race is finished when the race is.
+	LAYOUT_LAPS
+)
+
+// encodeLapChunk packs the points of a lap, sorted by creation time
+func encodeLapChunk(points []models.Point) (models.LapChunk, error) {
+	first, last := points[0], points[len(points)-1]
+	chunk := models.LapChunk{
+		Race:            first.Race,
+		LapNumber:       first.LapNumber,
+		StartedAt:       first.CreatedAt,
+		FinishedAt:      last.CreatedAt,
+		CurrentLap:      last.CurrentLap,
+		CurrentRaceTime: last.CurrentRaceTime,
+		RacePosition:    last.RacePosition,
+		Points:          len(points),
+	}
+
+	buf := bytes.NewBuffer([]byte{LAP_CHUNK_VERSION})
+	w, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return chunk, err
+	}
+	_, err = w.Write(columnar.Append(nil, points, columnar.DELTA_PLANES))
+	if err != nil {
+		return chunk, err
+	}
+	err = w.Close()
+	chunk.Data = buf.Bytes()
+	return chunk, err
+}
+
+// decodeLapChunk unpacks the points of a lap, sorted by creation time
+func decodeLapChunk(chunk models.LapChunk) ([]models.Point, error) {
+	if len(chunk.Data) == 0 || chunk.Data[0] != LAP_CHUNK_VERSION || chunk.Points < 0 {
+		return nil, fmt.Errorf("invalid chunk of lap %d of race %s", chunk.LapNumber, chunk.Race)
+	}
+
+	points := make([]models.Point, chunk.Points)
+	for i := range points {
+		points[i].Race = chunk.Race
+	}
+	r := flate.NewReader(bytes.NewReader(chunk.Data[1:]))
+	defer r.Close()
+	err := columnar.Read(bufio.NewReader(r), points, columnar.DELTA_PLANES)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chunk of lap %d of race %s: %w", chunk.LapNumber, chunk.Race, err)
+	}
+	return points, nil
+}
+
+// iterLapChunks streams the points of the lap chunks of a race, oldest first, one chunk at a time
+func (s *Store) iterLapChunks(race string, where []Where, ctx context.Context) iter.Seq2[models.Point, error] {
+	query := s.db.NewSelect().Model((*models.LapChunk)(nil)).Column("*").Where("race = ?", race)
+	query = addWhere(query, where).Order("started_at ASC")
+
+	return func(yield func(models.Point, error) bool) {
+		rows, err := query.Rows(ctx)
+		if err != nil {
+			yield(models.Point{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var chunk models.LapChunk
+			err = s.db.ScanRow(ctx, rows, &chunk)
+			if err != nil {
+				yield(models.Point{}, err)
+				return
+			}
+			points, err := decodeLapChunk(chunk)
+			if err != nil {
+				yield(models.Point{}, err)
+				return
+			}
+			for _, point := range points {
+				if !yield(point, nil) {
+					return
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(models.Point{}, err)
+		}
+	}
+}
+
+// selectLastLapChunkPoint returns the last point of the last lap chunk of a race
+func (s *Store) selectLastLapChunkPoint(race string, ctx context.Context) (models.Point, error) {
+	var chunk models.LapChunk
+	err := s.db.NewSelect().Model(&chunk).Where("race = ?", race).Order("finished_at DESC").Limit(1).Scan(ctx)
+	if err != nil {
+		return models.Point{}, err
+	}
+	points, err := decodeLapChunk(chunk)
+	if err != nil {
+		return models.Point{}, err
+	} else if len(points) == 0 {
+		return models.Point{}, sql.ErrNoRows
+	}
+	return points[len(points)-1], nil
+}
+
+// compactRace packs the points of the laps of a race below the lap in lap chunks, every lap when the lap is negative
+func (s *Store) compactRace(ctx context.Context, db bun.IDB, race uuid.UUID, below int) error {
+	var laps []uint16
+	query := db.NewSelect().Model((*models.Point)(nil)).ColumnExpr("DISTINCT lap_number").Where("race = ?", race)
+	if below >= 0 {
+		query = query.Where("lap_number < ?", below)
+	}
+	err := query.Scan(ctx, &laps)
+	if err != nil {
+		return err
+	}
+
+	for _, lap := range laps {
+		err = s.compactLap(ctx, db, race, lap)
+		if err != nil {
+			return fmt.Errorf("failed packing lap %d of race %s: %w", lap, race, err)
+		}
+	}
+	return nil
+}
+
+// compactLap packs the points of a lap, points written after the lap was packed are merged with its chunk
+func (s *Store) compactLap(ctx context.Context, db bun.IDB, race uuid.UUID, lap uint16) error {
+	var points []models.Point
+	err := db.NewSelect().Model(&points).Where("race = ?", race).Where("lap_number = ?", lap).Order("created_at ASC").Scan(ctx)
+	if err != nil || len(points) == 0 {
+		return err
+	}
+
+	var chunk models.LapChunk
+	err = db.NewSelect().Model(&chunk).Where("race = ?", race).Where("lap_number = ?", lap).Scan(ctx)
+	if err == nil {
+		packed, err := decodeLapChunk(chunk)
+		if err != nil {
+			return err
+		}
+		points = append(packed, points...)
+		slices.SortStableFunc(points, func(a, b models.Point) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	chunk, err = encodeLapChunk(points)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewInsert().Model(&chunk).On("CONFLICT (race, lap_number) DO UPDATE").Set("started_at = EXCLUDED.started_at").Set("finished_at = EXCLUDED.finished_at").Set("current_lap = EXCLUDED.current_lap").Set("current_race_time = EXCLUDED.current_race_time").Set("race_position = EXCLUDED.race_position").Set("points = EXCLUDED.points").Set("data = EXCLUDED.data").Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewDelete().Model((*models.Point)(nil)).Where("race = ?", race).Where("lap_number = ?", lap).Exec(ctx)
+	return err
+}
+
+// uncompact clears the compacted flag of the races of the points, CompactLaps packs their new points
+func uncompact(ctx context.Context, db bun.IDB, points []models.Point) error {
+	var races []uuid.UUID
+	for _, point := range points {
+		if !slices.Contains(races, point.Race) {
+			races = append(races, point.Race)
+		}
+	}
+	_, err := db.NewUpdate().Model((*models.Race)(nil)).Set("compacted = ?", false).Where("compacted").Where("id IN (?)", bun.In(races)).Exec(ctx)
+	return err
+}
+
+// CompactLaps packs the finished laps of the races in lap chunks, one race per transaction.
+// Every lap of the races ended is finished, every lap but the last of the races in progress.
+// Races ended are flagged as compacted once packed, only the races in progress and the races with new points are checked.
+func (s *Store) CompactLaps(ctx context.Context) error {
+	var races []struct {
+		ID         uuid.UUID `bun:"type:uuid"`
+		InProgress bool
+		LastLap    int
+	}
+	err := s.db.NewSelect().Model((*models.Race)(nil)).Column("id", "in_progress").
+		ColumnExpr("COALESCE((SELECT MAX(lap_number) FROM points WHERE points.race = race.id), 0) AS last_lap").
+		Where("NOT compacted").Scan(ctx, &races)
+	if err != nil {
+		return err
+	}
+
+	for _, race := range races {
+		below := -1
+		if race.InProgress {
+			below = race.LastLap
+		}
+		err = s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			// Flagged before reading the points, a batch writing points meanwhile clears the flag again
+			if !race.InProgress {
+				_, err := tx.NewUpdate().Model((*models.Race)(nil)).Set("compacted = ?", true).Where("id = ?", race.ID).Where("NOT in_progress").Exec(ctx)
+				if err != nil {
+					return err
+				}
+			}
+			return s.compactRace(ctx, tx, race.ID, below)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
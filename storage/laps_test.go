@@ -0,0 +1,191 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+	"forzatelemetry/storage"
+	"forzatelemetry/testutils"
+)
+
+// lapPoints returns count points per lap of the race, starting at lap first
+func lapPoints(race uuid.UUID, start time.Time, first, laps, count int) []models.Point {
+	var points []models.Point
+	for lap := first; lap < first+laps; lap++ {
+		for i := range count {
+			point := testutils.Point(race, start.Add(time.Duration(lap*count+i)*time.Millisecond), 1)
+			point.LapNumber = uint16(lap)
+			point.CurrentLap = float32(i)
+			point.CurrentRaceTime = float32(lap*count + i)
+			point.Steer = int8(i - count/2)
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+func checkPoints(t *testing.T, db *storage.Store, race uuid.UUID, expected []models.Point) {
+	t.Helper()
+	i := 0
+	for point, err := range db.IterPoints(race.String(), nil, context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if i >= len(expected) {
+			t.Fatalf("expected %v points got more", len(expected))
+		}
+		if point.TelemetryPoint != expected[i].TelemetryPoint || point.Race != race || !point.CreatedAt.Equal(expected[i].CreatedAt) {
+			t.Fatalf("point %v: expected %+v got %+v", i, expected[i], point)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("expected %v points got %v", len(expected), i)
+	}
+}
+
+func TestWriteBatchLaps(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+	db.SetPointsLayout(storage.LAYOUT_LAPS)
+
+	race := models.Race{ID: uuid.New(), InProgress: true}
+	start := time.Now().Truncate(time.Millisecond)
+	points := lapPoints(race.ID, start, 0, 3, 50)
+
+	// the points of lap 2 finish laps 0 and 1, they are packed by CompactLaps
+	err := db.WriteBatch(context.Background(), nil, []models.Race{race}, points[:120])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	checkPoints(t, db, race.ID, points[:120])
+	err = db.CompactLaps(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	checkPoints(t, db, race.ID, points[:120])
+	laps, err := db.SelectLaps(race.ID.String(), context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(laps) != 3 || laps[0].LapTime != 49 || !laps[1].FinishedAt.Equal(points[99].CreatedAt) || laps[2].RaceTime != 119 {
+		t.Errorf("unexpected laps %+v", laps)
+	}
+
+	// the race ends, every lap is packed
+	race.InProgress = false
+	err = db.WriteBatch(context.Background(), nil, []models.Race{race}, points[120:])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = db.CompactLaps(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	checkPoints(t, db, race.ID, points)
+	last, err := db.SelectLastPoint(race.ID.String(), context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !last.CreatedAt.Equal(points[149].CreatedAt) {
+		t.Errorf("expected %v got %v", points[149].CreatedAt, last.CreatedAt)
+	}
+
+	// a late point is merged with the chunk of its lap
+	late := testutils.Point(race.ID, start.Add(10*time.Microsecond), 1)
+	late.LapNumber = 0
+	err = db.WriteBatch(context.Background(), nil, []models.Race{race}, []models.Point{late})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = db.CompactLaps(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	checkPoints(t, db, race.ID, append([]models.Point{points[0], late}, points[1:]...))
+
+	// lap chunks are counted and deleted by the cleanup
+	report, err := db.Cleanup(context.Background(), storage.RetentionPolicy{MaxPoints: 1})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(report.Races) != 1 || report.Points != 151 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	for _, err := range db.IterPoints(race.ID.String(), nil, context.Background()) {
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected %v got %v", sql.ErrNoRows, err)
+		}
+	}
+}
+
+func TestCompactLaps(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+	db.SetPointsLayout(storage.LAYOUT_LAPS)
+
+	start := time.Now().Truncate(time.Millisecond)
+	ended := models.Race{ID: uuid.New()}
+	inProgress := models.Race{ID: uuid.New(), InProgress: true}
+	endedPoints := lapPoints(ended.ID, start, 0, 2, 20)
+	inProgressPoints := lapPoints(inProgress.ID, start, 0, 2, 20)
+
+	err := db.WriteBatch(context.Background(), nil, []models.Race{ended, inProgress}, append(endedPoints, inProgressPoints...))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	err = db.CompactLaps(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	checkPoints(t, db, ended.ID, endedPoints)
+	checkPoints(t, db, inProgress.ID, inProgressPoints)
+
+	// only the last lap of the race in progress is left in the points table
+	last, err := db.SelectLastPoint(inProgress.ID.String(), context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !last.CreatedAt.Equal(inProgressPoints[39].CreatedAt) {
+		t.Errorf("expected %v got %v", inProgressPoints[39].CreatedAt, last.CreatedAt)
+	}
+
+	compacted := func(race uuid.UUID) bool {
+		t.Helper()
+		selected, err := db.SelectRace(race.String(), context.Background(), "")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return selected.Compacted
+	}
+	// the race ended is skipped by the next runs, the race in progress isn't
+	if !compacted(ended.ID) || compacted(inProgress.ID) {
+		t.Errorf("expected only the race ended to be compacted")
+	}
+
+	// a late point flags the race again, it's merged by the next run
+	late := testutils.Point(ended.ID, start.Add(10*time.Microsecond), 1)
+	late.LapNumber = 0
+	err = db.InsertPoints([]models.Point{late}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if compacted(ended.ID) {
+		t.Errorf("expected the race to be checked again")
+	}
+	err = db.CompactLaps(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !compacted(ended.ID) {
+		t.Errorf("expected the race to be compacted")
+	}
+	checkPoints(t, db, ended.ID, append([]models.Point{endedPoints[0], late}, endedPoints[1:]...))
+}
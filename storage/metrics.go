@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/uptrace/bun"
+
+	"forzatelemetry/metrics"
+)
+
+var (
+	dbErrors = metrics.NewCounter(metrics.Default, "forzatelemetry_db_errors_total", "Failed database queries, per operation.", "operation")
+
+	cleanupRuns          = metrics.NewCounter(metrics.Default, "forzatelemetry_cleanup_runs_total", "Database cleanups, per result.", "result")
+	cleanupDuration      = metrics.NewGauge(metrics.Default, "forzatelemetry_cleanup_duration_seconds", "Duration of the last database cleanup.")
+	cleanupLastSuccess   = metrics.NewGauge(metrics.Default, "forzatelemetry_cleanup_last_success_timestamp_seconds", "Time of the last successful database cleanup.")
+	cleanupDeleted       = metrics.NewCounter(metrics.Default, "forzatelemetry_cleanup_deleted_total", "Rows deleted by the database cleanups, per table.", "table")
+	cleanupArchived      = metrics.NewCounter(metrics.Default, "forzatelemetry_cleanup_archived_races_total", "Races archived by the database cleanups.")
+	cleanupDroppedChunks = metrics.NewCounter(metrics.Default, "forzatelemetry_cleanup_dropped_chunks_total", "TimescaleDB chunks of points dropped by the database cleanups.")
+)
+
+// metricsHook counts the failed queries. Missing rows are expected and not counted.
+type metricsHook struct{}
+
+func (h metricsHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (h metricsHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	if event.Err != nil && !errors.Is(event.Err, sql.ErrNoRows) {
+		dbErrors.Inc(event.Operation())
+	}
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+
+	"forzatelemetry/models"
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		// All the races recorded before were sent by Forza Motorsport 8, with all the fields
+		query := db.NewAddColumn().Model((*models.Race)(nil))
+
+		var err error
+		if db.Dialect().Name() == dialect.SQLite {
+			query = query.ColumnExpr("COLUMN fields SMALLINT NOT NULL DEFAULT ?", models.FIELDS_ALL)
+			_, err = query.Exec(ctx)
+			if err != nil && err.Error() == "SQL logic error: duplicate column name: fields (1)" {
+				err = nil
+			}
+		} else {
+			query = query.ColumnExpr("COLUMN IF NOT EXISTS fields SMALLINT NOT NULL DEFAULT ?", models.FIELDS_ALL)
+			_, err = query.Exec(ctx)
+		}
+		return err
+	}, func(ctx context.Context, db *bun.DB) error {
+		return fmt.Errorf("no migration exist")
+	})
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+
+	"forzatelemetry/models"
+	"forzatelemetry/storage"
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		err := storage.NewStore(db).CreateTables(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Races recorded before are anonymous
+		query := db.NewAddColumn().Model((*models.Race)(nil))
+		if db.Dialect().Name() == dialect.SQLite {
+			query = query.ColumnExpr("COLUMN driver BIGINT")
+			_, err = query.Exec(ctx)
+			if err != nil && err.Error() == "SQL logic error: duplicate column name: driver (1)" {
+				err = nil
+			}
+		} else {
+			query = query.ColumnExpr("COLUMN IF NOT EXISTS driver BIGINT")
+			_, err = query.Exec(ctx)
+		}
+		return err
+	}, func(ctx context.Context, db *bun.DB) error {
+		return fmt.Errorf("no migration exist")
+	})
+}
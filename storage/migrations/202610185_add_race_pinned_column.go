@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+
+	"forzatelemetry/models"
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		// Races recorded before aren't pinned
+		query := db.NewAddColumn().Model((*models.Race)(nil))
+
+		var err error
+		if db.Dialect().Name() == dialect.SQLite {
+			query = query.ColumnExpr("COLUMN pinned BOOLEAN NOT NULL DEFAULT false")
+			_, err = query.Exec(ctx)
+			if err != nil && err.Error() == "SQL logic error: duplicate column name: pinned (1)" {
+				err = nil
+			}
+		} else {
+			query = query.ColumnExpr("COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT false")
+			_, err = query.Exec(ctx)
+		}
+		return err
+	}, func(ctx context.Context, db *bun.DB) error {
+		return fmt.Errorf("no migration exist")
+	})
+}
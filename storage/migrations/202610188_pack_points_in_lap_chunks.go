@@ -0,0 +1,19 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+
+	"forzatelemetry/storage"
+)
+
+func init() {
+	LapsMigrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		// Finished laps of the races saved before, the laps of the races in progress are packed by the next batches
+		return storage.NewStore(db).CompactLaps(ctx)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return fmt.Errorf("no migration exist")
+	})
+}
@@ -0,0 +1,19 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+
+	"forzatelemetry/storage"
+)
+
+func init() {
+	TimescaleMigrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		// Points saved before are moved to the chunks of the hypertable
+		return storage.NewStore(db).CreateHypertable(ctx)
+	}, func(ctx context.Context, db *bun.DB) error {
+		return fmt.Errorf("no migration exist")
+	})
+}
@@ -6,3 +6,10 @@ import (
 
 // A collection of migrations.
 var Migrations = migrate.NewMigrations()
+
+// Migrations of the points to storage.LAYOUT_LAPS, applied after Migrations when the points are stored by lap
+var LapsMigrations = migrate.NewMigrations()
+
+// Migrations of the points table to a TimescaleDB hypertable, applied after Migrations when TimescaleDB is enabled.
+// Hypertables can't be converted back to tables.
+var TimescaleMigrations = migrate.NewMigrations()
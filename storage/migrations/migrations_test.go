@@ -1,8 +1,10 @@
 package migrations_test
 
 import (
+	"context"
 	"testing"
 
+	"forzatelemetry/models"
 	"forzatelemetry/storage"
 	"forzatelemetry/storage/migrations"
 	"forzatelemetry/testutils"
@@ -41,3 +43,41 @@ func TestMigrateExistingStore(t *testing.T) {
 		t.Fatalf("unexpected error %s", err)
 	}
 }
+
+func TestMigrateLaps(t *testing.T) {
+	store := testutils.NewStore("races.yaml", "points.yaml")
+	defer store.Close()
+
+	id := "44e22d85-3883-4552-9ff4-91a7211e0639"
+	var before []models.Point
+	for point, err := range store.IterPoints(id, nil, context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		before = append(before, point)
+	}
+
+	err := store.Migrate(migrations.Migrations)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	err = store.Migrate(migrations.LapsMigrations)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	// the points are read back from the lap chunks
+	i := 0
+	for point, err := range store.IterPoints(id, nil, context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if point.TelemetryPoint != before[i].TelemetryPoint || !point.CreatedAt.Equal(before[i].CreatedAt) {
+			t.Fatalf("expected %+v got %+v", before[i], point)
+		}
+		i++
+	}
+	if i != len(before) {
+		t.Errorf("expected %v points got %v", len(before), i)
+	}
+}
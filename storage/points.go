@@ -3,44 +3,145 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"iter"
-	"log/slog"
+	"slices"
 
 	"forzatelemetry/models"
+
+	"github.com/uptrace/bun"
 )
 
+// Rows fetched at once from the cursor of IterPoints on PostgreSQL
+const POINTS_FETCH_ROWS = 1000
+
 func (s *Store) SelectLastPoint(race string, ctx context.Context) (models.Point, error) {
 	var point models.Point
 	err := s.db.NewSelect().Model(&point).Where("race = ?", race).Order("created_at DESC").Limit(1).Scan(ctx)
-	return point, err
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return point, err
+	}
+
+	packed, packedErr := s.selectLastLapChunkPoint(race, ctx)
+	if errors.Is(packedErr, sql.ErrNoRows) {
+		return point, err
+	} else if packedErr != nil {
+		return point, packedErr
+	}
+	if err != nil || packed.CreatedAt.After(point.CreatedAt) {
+		return packed, nil
+	}
+	return point, nil
 }
 
+// IterPoints streams the points of a race, oldest first, sql.ErrNoRows is yielded when there are none.
+// Points are scanned one row at a time on SQLite and fetched POINTS_FETCH_ROWS at a time from a server-side cursor on PostgreSQL,
+// the points of the lap chunks one chunk at a time, the memory used doesn't depend on the length of the race.
+// where filters both the points and the lap chunks, on the columns they share. Canceling ctx stops the queries.
 func (s *Store) IterPoints(race string, where []Where, ctx context.Context) iter.Seq2[models.Point, error] {
-	points := []models.Point{}
+	query := s.db.NewSelect().Model((*models.Point)(nil)).Column("*").Where("race = ?", race)
+	query = addWhere(query, where).Order("created_at ASC")
 
-	query := s.db.NewSelect().Model(&points).Column("*").Where("race = ?", race)
-	query = addWhere(query, where)
+	return func(yield func(models.Point, error) bool) {
+		chunks, stopChunks := iter.Pull2(s.iterLapChunks(race, where, ctx))
+		defer stopChunks()
+		packed, chunkErr, more := chunks()
 
-	err := query.Order("created_at ASC").Scan(ctx)
+		count := 0
+		stopped := false
+		emit := func(point models.Point) bool {
+			count++
+			stopped = !yield(point, nil)
+			return !stopped
+		}
+		// emitPacked yields the points of the lap chunks created before the point, all of them without point
+		emitPacked := func(before *models.Point) bool {
+			for more && chunkErr == nil && (before == nil || packed.CreatedAt.Before(before.CreatedAt)) {
+				if !emit(packed) {
+					return false
+				}
+				packed, chunkErr, more = chunks()
+			}
+			return chunkErr == nil
+		}
+		each := func(point models.Point) bool {
+			return emitPacked(&point) && emit(point)
+		}
 
-	return func(yield func(models.Point, error) bool) {
+		var err error
+		if s.IsPG() {
+			err = s.iterCursor(ctx, query, each)
+		} else {
+			err = s.iterRows(ctx, query, each)
+		}
+		if err == nil && !stopped {
+			emitPacked(nil)
+		}
+		if stopped {
+			return
+		}
+		if err == nil {
+			err = chunkErr
+		}
 		if err != nil {
 			yield(models.Point{}, err)
-			return
+		} else if count == 0 {
+			yield(models.Point{}, sql.ErrNoRows)
 		}
+	}
+}
 
-		if len(points) == 0 {
-			yield(models.Point{}, sql.ErrNoRows)
-			return
+func (s *Store) iterRows(ctx context.Context, query *bun.SelectQuery, each func(models.Point) bool) error {
+	rows, err := query.Rows(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	_, err = s.scanPoints(ctx, rows, each)
+	return err
+}
+
+// iterCursor fetches the points from a cursor, in a read only transaction rolled back at the end
+func (s *Store) iterCursor(ctx context.Context, query *bun.SelectQuery, each func(models.Point) bool) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.NewRaw("DECLARE points_cursor NO SCROLL CURSOR FOR ?", query).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		rows, err := tx.QueryContext(ctx, "FETCH FORWARD ? FROM points_cursor", POINTS_FETCH_ROWS)
+		if err != nil {
+			return err
+		}
+		n, err := s.scanPoints(ctx, rows, each)
+		rows.Close()
+		if err != nil || n < POINTS_FETCH_ROWS {
+			return err
 		}
+	}
+}
 
-		slog.Info("points query info", "len", len(points), "cap", cap(points))
-		for _, point := range points {
-			if !yield(point, nil) {
-				return
-			}
+// scanPoints scans the rows until each returns false and returns the count of rows scanned
+func (s *Store) scanPoints(ctx context.Context, rows *sql.Rows, each func(models.Point) bool) (int, error) {
+	n := 0
+	for rows.Next() {
+		var point models.Point
+		err := s.db.ScanRow(ctx, rows, &point)
+		if err != nil {
+			return n, err
+		}
+		n++
+		if !each(point) {
+			// stopped, nothing else is fetched
+			return 0, nil
 		}
 	}
+	return n, rows.Err()
 }
 
 func (s *Store) SelectLaps(race string, ctx context.Context) (map[uint16]models.Lap, error) {
@@ -49,18 +150,42 @@ func (s *Store) SelectLaps(race string, ctx context.Context) (map[uint16]models.
 	maxQ := s.db.NewSelect().Model(&models.Point{}).ColumnExpr("MAX(created_at) as max").Column("lap_number", "race").Where("race = ?", race).Group("lap_number", "race") // Group by race as well to benefit from the points_race_createdat index on the join
 	err := s.db.NewSelect().Model(&models.Point{}).Column("lap_number", "current_lap", "created_at", "race_position", "current_race_time").Join("INNER JOIN (?) AS m", maxQ).JoinOn("m.max = point.created_at").JoinOn("m.race = point.race").Scan(ctx, &_laps)
 
+	if err != nil {
+		return nil, err
+	}
+	// Laps of the lap chunks, points written after a lap was packed are still in the points table
+	var packed []models.Lap
+	err = s.db.NewSelect().Model((*models.LapChunk)(nil)).Column("lap_number", "current_lap", "race_position", "current_race_time").ColumnExpr("finished_at AS created_at").Where("race = ?", race).Scan(ctx, &packed)
+
 	laps := make(map[uint16]models.Lap)
-	if len(_laps) == 0 {
+	if len(_laps) == 0 && len(packed) == 0 {
 		return laps, sql.ErrNoRows
 	}
 
-	for _, l := range _laps {
-		laps[l.LapNumber] = l
+	for _, l := range slices.Concat(packed, _laps) {
+		if previous, ok := laps[l.LapNumber]; !ok || l.FinishedAt.After(previous.FinishedAt) {
+			laps[l.LapNumber] = l
+		}
 	}
 	return laps, err
 }
 
+// InsertPoints inserts points with COPY on PostgreSQL, with INSERT on SQLite.
+// With LAYOUT_LAPS, their races are flagged to be checked again by CompactLaps.
 func (s *Store) InsertPoints(points []models.Point, ctx context.Context) error {
-	_, err := s.db.NewInsert().Model(&points).Exec(ctx)
-	return err
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if s.layout != LAYOUT_LAPS || len(points) == 0 {
+		return s.insertPoints(ctx, conn, conn, points)
+	}
+	return conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := s.insertPoints(ctx, conn, tx, points)
+		if err != nil {
+			return err
+		}
+		return uncompact(ctx, tx, points)
+	})
 }
@@ -2,10 +2,14 @@ package storage_test
 
 import (
 	"context"
+	"errors"
+	"os"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+
 	"forzatelemetry/models"
 	"forzatelemetry/storage"
 	"forzatelemetry/testutils"
@@ -117,6 +121,95 @@ func TestIterPointsStopEarly(t *testing.T) {
 	}
 }
 
+func TestIterPointsLongRace(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+	testIterPointsLongRace(t, db)
+}
+
+// Points are fetched from a cursor on PostgreSQL, only tested with a database to write to
+func TestIterPointsPG(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	db, err := storage.NewPGStore(dsn)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer db.Close()
+	err = db.CreateTables(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	testIterPointsLongRace(t, db)
+}
+
+func testIterPointsLongRace(t *testing.T, db *storage.Store) {
+	race := uuid.New()
+	start := time.Now().Truncate(time.Millisecond)
+	// more points than a single fetch from the cursor
+	points := make([]models.Point, 2*storage.POINTS_FETCH_ROWS+10)
+	for i := range points {
+		points[i] = testutils.Point(race, start.Add(time.Duration(i)*time.Millisecond), 1)
+	}
+	err := db.InsertPoints(points, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	count := 0
+	for point, err := range db.IterPoints(race.String(), nil, context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !point.CreatedAt.Equal(points[count].CreatedAt) {
+			t.Fatalf("expected %v got %v", points[count].CreatedAt, point.CreatedAt)
+		}
+		count++
+	}
+	if count != len(points) {
+		t.Errorf("expected %v got %v", len(points), count)
+	}
+
+	// stopped in the middle of a fetch
+	count = 0
+	for _, err := range db.IterPoints(race.String(), nil, context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		count++
+		if count == storage.POINTS_FETCH_ROWS+1 {
+			break
+		}
+	}
+	if count != storage.POINTS_FETCH_ROWS+1 {
+		t.Errorf("expected %v got %v", storage.POINTS_FETCH_ROWS+1, count)
+	}
+
+	// the query is stopped with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	count = 0
+	var iterErr error
+	for _, err := range db.IterPoints(race.String(), nil, ctx) {
+		if err != nil {
+			iterErr = err
+			break
+		}
+		count++
+		if count == 10 {
+			cancel()
+		}
+	}
+	cancel()
+	if !errors.Is(iterErr, context.Canceled) {
+		t.Errorf("expected %v got %v", context.Canceled, iterErr)
+	}
+	if count == len(points) {
+		t.Errorf("expected fewer points than %v", len(points))
+	}
+}
+
 type selectLapsRun struct {
 	race   string
 	laps   map[uint16]models.Lap
@@ -14,7 +14,7 @@ import (
 
 func (s *Store) makeRaceDetailledQuery(query *bun.SelectQuery) *bun.SelectQuery {
 	query = query.ColumnExpr("races.*")
-	query = query.Relation("TrackMetadata").Relation("CarMetadata").Relation("CarClassMetadata")
+	query = query.Relation("TrackMetadata").Relation("CarMetadata").Relation("CarClassMetadata").Relation("DriverMetadata")
 	return query
 }
 
@@ -67,8 +67,25 @@ func (s *Store) SelectRaceLaps(id string, ctx context.Context, dashboardBaseUrl
 	return models.MakeRaceDetailled(race, laps), nil
 }
 
+// SelectOrphanedRaces returns the races still in progress without points created since before, in the points or lap chunks tables
+func (s *Store) SelectOrphanedRaces(before time.Time, ctx context.Context) ([]models.Race, error) {
+	var races []models.Race
+	err := s.db.NewSelect().Model(&races).
+		Where("in_progress = ?", true).
+		Where("started_at < ?", before).
+		Where("NOT EXISTS (SELECT 1 FROM points WHERE points.race = race.id AND points.created_at >= ?)", before).
+		Where("NOT EXISTS (SELECT 1 FROM lap_chunks WHERE lap_chunks.race = race.id AND lap_chunks.finished_at >= ?)", before).
+		Order("started_at ASC").
+		Scan(ctx)
+	return races, err
+}
+
 func (s *Store) UpsertRaces(ctx context.Context, races ...models.Race) error {
-	_, err := s.db.NewInsert().Model(&races).On(
-		"CONFLICT (id) DO UPDATE").Set("paused = EXCLUDED.paused").Set("in_progress = EXCLUDED.in_progress").Set("finished_at = EXCLUDED.finished_at").Set("best_lap = EXCLUDED.best_lap").Set("race_time = EXCLUDED.race_time").Set("position = EXCLUDED.position").Set("distance_traveled = EXCLUDED.distance_traveled").Exec(context.Background())
+	return upsertRaces(ctx, s.db, races)
+}
+
+func upsertRaces(ctx context.Context, db bun.IDB, races []models.Race) error {
+	_, err := db.NewInsert().Model(&races).On(
+		"CONFLICT (id) DO UPDATE").Set("session_id = EXCLUDED.session_id").Set("paused = EXCLUDED.paused").Set("in_progress = EXCLUDED.in_progress").Set("finished_at = EXCLUDED.finished_at").Set("best_lap = EXCLUDED.best_lap").Set("race_time = EXCLUDED.race_time").Set("position = EXCLUDED.position").Set("distance_traveled = EXCLUDED.distance_traveled").Exec(ctx)
 	return err
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"forzatelemetry/models"
 	"forzatelemetry/storage"
@@ -127,6 +128,8 @@ func TestSelectRaceLaps(t *testing.T) {
 				Race: models.Race{
 					ID:        testutils.ParseUUID("982e6f1d-efe2-4b67-b420-67c08e705994"),
 					SessionID: testutils.ParseUUID("b1f08eb3-544c-46f9-a005-aaff6c41c215"),
+					Game:      models.GAME_FM8,
+					Fields:    models.FIELDS_ALL,
 					Car:       108,
 					StartedAt: testutils.ParseTime("2024-09-16T17:37:10.0Z"),
 				},
@@ -143,6 +146,8 @@ func TestSelectRaceLaps(t *testing.T) {
 				Race: models.Race{
 					ID:         testutils.ParseUUID("3cb31256-f9fb-481b-90bc-9b7440441105"),
 					SessionID:  testutils.ParseUUID("869696cb-d3da-4ed9-a353-111f75cccf77"),
+					Game:       models.GAME_FM8,
+					Fields:     models.FIELDS_ALL,
 					Car:        107,
 					StartedAt:  testutils.ParseTime("2024-09-14T17:37:10.0Z"),
 					FinishedAt: testutils.ParseTime("2024-09-15T17:37:10.0Z"),
@@ -192,23 +197,23 @@ func TestUpsertRaces(t *testing.T) {
 	runs := map[string]upsertRacesRun{
 		"noRaces": {errMsg: "bun: Insert(empty *reflect.rtype)"},
 		"insert": {
-			result: []models.Race{{ID: testutils.ParseUUID("6fc02281-be30-4e6b-b4bc-4a36ae5ec933"), Position: 2, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
+			result: []models.Race{{ID: testutils.ParseUUID("6fc02281-be30-4e6b-b4bc-4a36ae5ec933"), Game: models.GAME_FM8, Fields: models.FIELDS_ALL, Position: 2, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
 			races:  []models.Race{{ID: testutils.ParseUUID("6fc02281-be30-4e6b-b4bc-4a36ae5ec933"), Position: 2, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
 		},
 		"update": {
-			result: []models.Race{{ID: testutils.ParseUUID("894b1a37-6510-42ed-a2fa-47a8bde0939e"), Position: 3, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
+			result: []models.Race{{ID: testutils.ParseUUID("894b1a37-6510-42ed-a2fa-47a8bde0939e"), Game: models.GAME_FM8, Fields: models.FIELDS_ALL, Position: 3, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
 			races:  []models.Race{{ID: testutils.ParseUUID("894b1a37-6510-42ed-a2fa-47a8bde0939e"), Position: 2, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
 			update: []models.Race{{ID: testutils.ParseUUID("894b1a37-6510-42ed-a2fa-47a8bde0939e"), Position: 3, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
 		},
 		"updateInvalidField": {
-			result: []models.Race{{ID: testutils.ParseUUID("b364576c-dedc-497f-a281-6fc942f091bf"), Car: 200, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
+			result: []models.Race{{ID: testutils.ParseUUID("b364576c-dedc-497f-a281-6fc942f091bf"), Game: models.GAME_FM8, Fields: models.FIELDS_ALL, Car: 200, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
 			races:  []models.Race{{ID: testutils.ParseUUID("b364576c-dedc-497f-a281-6fc942f091bf"), Car: 200, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
 			update: []models.Race{{ID: testutils.ParseUUID("b364576c-dedc-497f-a281-6fc942f091bf"), Car: 300, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")}},
 		},
 		"insertMultiple": {
 			result: []models.Race{
-				{ID: testutils.ParseUUID("c2074981-5e3a-490a-883d-487ca097ddd6"), Position: 2, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
-				{ID: testutils.ParseUUID("93670cd5-f0bd-4c19-a75c-5ca0883155ba"), Position: 3, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
+				{ID: testutils.ParseUUID("c2074981-5e3a-490a-883d-487ca097ddd6"), Game: models.GAME_FM8, Fields: models.FIELDS_ALL, Position: 2, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
+				{ID: testutils.ParseUUID("93670cd5-f0bd-4c19-a75c-5ca0883155ba"), Game: models.GAME_FM8, Fields: models.FIELDS_ALL, Position: 3, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
 			},
 			races: []models.Race{
 				{ID: testutils.ParseUUID("c2074981-5e3a-490a-883d-487ca097ddd6"), Position: 2, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
@@ -217,8 +222,8 @@ func TestUpsertRaces(t *testing.T) {
 		},
 		"updateMultiple": {
 			result: []models.Race{
-				{ID: testutils.ParseUUID("91589f7b-fb17-4c85-9099-28006c27fd4e"), Position: 20, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
-				{ID: testutils.ParseUUID("00d95823-40e3-446f-889e-7500e5b2494a"), Position: 30, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
+				{ID: testutils.ParseUUID("91589f7b-fb17-4c85-9099-28006c27fd4e"), Game: models.GAME_FM8, Fields: models.FIELDS_ALL, Position: 20, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
+				{ID: testutils.ParseUUID("00d95823-40e3-446f-889e-7500e5b2494a"), Game: models.GAME_FM8, Fields: models.FIELDS_ALL, Position: 30, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
 			},
 			races: []models.Race{
 				{ID: testutils.ParseUUID("91589f7b-fb17-4c85-9099-28006c27fd4e"), Position: 2, StartedAt: testutils.ParseTime("2024-09-08T17:41:10.000000Z")},
@@ -267,3 +272,62 @@ func TestUpsertRaces(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectOrphanedRaces(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	now := time.Now()
+	races := []models.Race{
+		// no points
+		{ID: testutils.ParseUUID("0a7c43f4-8d4f-4bde-9a7b-5a1b8d1c2f01"), InProgress: true, StartedAt: now.Add(-time.Hour)},
+		// old points
+		{ID: testutils.ParseUUID("0a7c43f4-8d4f-4bde-9a7b-5a1b8d1c2f02"), InProgress: true, StartedAt: now.Add(-2 * time.Hour)},
+		// recent points
+		{ID: testutils.ParseUUID("0a7c43f4-8d4f-4bde-9a7b-5a1b8d1c2f03"), InProgress: true, StartedAt: now.Add(-time.Hour)},
+		// just started
+		{ID: testutils.ParseUUID("0a7c43f4-8d4f-4bde-9a7b-5a1b8d1c2f04"), InProgress: true, StartedAt: now},
+		// finished
+		{ID: testutils.ParseUUID("0a7c43f4-8d4f-4bde-9a7b-5a1b8d1c2f05"), StartedAt: now.Add(-time.Hour)},
+	}
+	err := db.UpsertRaces(context.Background(), races...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = db.InsertPoints([]models.Point{
+		{Race: races[1].ID, CreatedAt: now.Add(-90 * time.Minute)},
+		{Race: races[2].ID, CreatedAt: now.Add(-90 * time.Minute)},
+		{Race: races[2].ID, CreatedAt: now.Add(-time.Minute)},
+	}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// recent points packed in lap chunks, the race is resumed after it was packed
+	db.SetPointsLayout(storage.LAYOUT_LAPS)
+	packed := models.Race{ID: testutils.ParseUUID("0a7c43f4-8d4f-4bde-9a7b-5a1b8d1c2f06"), StartedAt: now.Add(-time.Hour)}
+	err = db.WriteBatch(context.Background(), nil, []models.Race{packed}, []models.Point{{Race: packed.ID, CreatedAt: now.Add(-time.Minute)}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = db.CompactLaps(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	packed.InProgress = true
+	err = db.UpsertRaces(context.Background(), packed)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	orphaned, err := db.SelectOrphanedRaces(now.Add(-10*time.Minute), context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(orphaned) != 2 {
+		t.Fatalf("expected 2 got %v", len(orphaned))
+	}
+	if orphaned[0].ID != races[1].ID || orphaned[1].ID != races[0].ID {
+		t.Errorf("expected %v %v got %v %v", races[1].ID, races[0].ID, orphaned[0].ID, orphaned[1].ID)
+	}
+}
@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"forzatelemetry/models"
+)
+
+// Races deleted per statement by Cleanup
+const CLEANUP_CHUNK = 500
+
+// Points whose size is measured to estimate the size of the points on PostgreSQL
+const SIZE_SAMPLE = 1000
+
+var ErrNoArchive = errors.New("races aren't archived")
+
+// Races finished more than 3 months ago are deleted by default
+var DEFAULT_RETENTION_POLICY = RetentionPolicy{MaxAge: 90 * 24 * time.Hour}
+
+// RetentionPolicy decides which races Cleanup deletes with their points, or archives when the store has an archive.
+// Races in progress, pinned races and archived races are always kept.
+// A restored race is aged from its restoration, it's kept MaxAge after it and deleted after the races finished before.
+// When the points table is a TimescaleDB hypertable, the chunks holding only points of deleted races are dropped,
+// the other points are deleted.
+// Every limit is optional, 0 disables it.
+type RetentionPolicy struct {
+	MaxAge time.Duration // races finished longer ago are deleted
+	// The oldest races are deleted until the points are below MaxPoints and their size below MaxBytes.
+	// The size is the size of the rows of the points and lap_chunks tables on PostgreSQL, estimated from a sample of the points,
+	// indexes and TimescaleDB compression aside. It's the size of the pages of the tables and their indexes on SQLite,
+	// measured with the dbstat table.
+	// The space of deleted rows isn't counted, even before it's released to the system.
+	MaxPoints int64
+	MaxBytes  int64
+	DryRun    bool // report what would be deleted, without deleting it
+}
+
+// CleanupReport lists the races deleted by Cleanup, or the races that would be deleted in dry-run mode
+type CleanupReport struct {
+	DryRun   bool
+	Archived bool // only the points of the races were deleted, after archiving the races
+	Races    []uuid.UUID
+	Points   int64
+	Chunks   int // TimescaleDB chunks of points dropped
+}
+
+type cleanupCandidate struct {
+	ID         uuid.UUID `bun:"type:uuid"`
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Points     int64
+}
+
+// Cleanup deletes the races and their points according to the policy
+func (s *Store) Cleanup(ctx context.Context, policy RetentionPolicy) (CleanupReport, error) {
+	start := time.Now()
+	report, err := s.cleanup(ctx, policy)
+	cleanupDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		cleanupRuns.Inc("failure")
+		return report, err
+	}
+	cleanupRuns.Inc("success")
+	cleanupLastSuccess.Set(float64(time.Now().Unix()))
+	if !report.DryRun {
+		if report.Archived {
+			cleanupArchived.Add(float64(len(report.Races)))
+		} else {
+			cleanupDeleted.Add(float64(len(report.Races)), "races")
+		}
+		cleanupDeleted.Add(float64(report.Points), "points")
+		cleanupDroppedChunks.Add(float64(report.Chunks))
+	}
+	return report, nil
+}
+
+func (s *Store) cleanup(ctx context.Context, policy RetentionPolicy) (CleanupReport, error) {
+	report := CleanupReport{DryRun: policy.DryRun, Archived: s.archive != nil}
+
+	// Oldest first, races never finished are ordered by their start, restored races by their restoration
+	var candidates []cleanupCandidate
+	err := s.db.NewSelect().Table("races").Column("id", "started_at").ColumnExpr("COALESCE(restored_at, finished_at, started_at) AS finished_at").
+		ColumnExpr("(SELECT count(*) FROM points WHERE points.race = races.id) + (SELECT COALESCE(SUM(points), 0) FROM lap_chunks WHERE lap_chunks.race = races.id) AS points").
+		Where("NOT pinned").Where("NOT in_progress").Where("NOT archived").
+		OrderExpr("COALESCE(restored_at, finished_at, started_at) ASC").Scan(ctx, &candidates)
+	if err != nil {
+		return report, err
+	}
+
+	// The size of a point is an average, the size left is measured again after deleting races until it fits
+	before := time.Now().Add(-policy.MaxAge)
+	deleted := 0
+	for {
+		remaining, limit, err := s.pointsRemaining(ctx, policy)
+		if err != nil {
+			return report, err
+		}
+
+		first := deleted
+		for _, candidate := range candidates[first:] {
+			expired := policy.MaxAge > 0 && candidate.FinishedAt.Before(before)
+			if !expired && (limit < 0 || remaining <= limit) {
+				break
+			}
+			report.Races = append(report.Races, candidate.ID)
+			report.Points += candidate.Points
+			remaining -= candidate.Points
+			deleted++
+		}
+
+		if policy.DryRun || deleted == first {
+			return report, nil
+		}
+		chunks, err := s.removeRaces(ctx, candidates, first, deleted)
+		report.Chunks += chunks
+		if err != nil || policy.MaxBytes <= 0 {
+			return report, err
+		}
+		if deleted == len(candidates) {
+			return report, nil
+		}
+	}
+}
+
+// pointsRemaining returns the count of points saved and the count of points fitting in the limits of the policy
+func (s *Store) pointsRemaining(ctx context.Context, policy RetentionPolicy) (int64, int64, error) {
+	rows, err := s.db.NewSelect().Model((*models.Point)(nil)).Count(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	var packed int64
+	err = s.db.NewSelect().Model((*models.LapChunk)(nil)).ColumnExpr("COALESCE(SUM(points), 0)").Scan(ctx, &packed)
+	if err != nil {
+		return 0, 0, err
+	}
+	limit, err := s.pointsLimit(ctx, policy, int64(rows), packed)
+	return int64(rows) + packed, limit, err
+}
+
+// removeRaces archives or deletes the candidates from first to end, the candidates after them are kept.
+// It returns the count of TimescaleDB chunks dropped.
+func (s *Store) removeRaces(ctx context.Context, candidates []cleanupCandidate, first int, end int) (int, error) {
+	ids := make([]uuid.UUID, 0, end-first)
+	for _, candidate := range candidates[first:end] {
+		ids = append(ids, candidate.ID)
+	}
+
+	if s.archive != nil {
+		for _, id := range ids {
+			err := s.archiveRace(ctx, id)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return 0, nil
+	}
+	timescale, err := s.IsTimescale(ctx)
+	if err != nil {
+		return 0, err
+	} else if !timescale {
+		return 0, s.deleteRaces(ctx, ids)
+	}
+	before, err := s.dropBefore(ctx, candidates, end)
+	if err != nil {
+		return 0, err
+	}
+	dropped, err := s.dropChunks(ctx, before)
+	if err != nil {
+		return dropped, err
+	}
+	return dropped, s.deleteRaces(ctx, ids)
+}
+
+// pointsLimit returns the count of points fitting in the limits of the policy, -1 without limit.
+// The size of a point is the average size of the points saved, rows of the points table and points packed in lap chunks.
+func (s *Store) pointsLimit(ctx context.Context, policy RetentionPolicy, rows int64, packed int64) (int64, error) {
+	limit := int64(-1)
+	if policy.MaxPoints > 0 {
+		limit = policy.MaxPoints
+	}
+	count := rows + packed
+	if policy.MaxBytes <= 0 || count == 0 {
+		return limit, nil
+	}
+
+	var size int64
+	var err error
+	if s.IsPG() {
+		// The size of the tables includes the space of deleted rows until a VACUUM FULL, the rows are measured instead
+		err = s.db.NewRaw(
+			"SELECT (COALESCE((SELECT avg(pg_column_size(sample.*)) FROM (SELECT * FROM points LIMIT ?) AS sample), 0) * ? + COALESCE((SELECT SUM(pg_column_size(lap_chunks.*)) FROM lap_chunks), 0))::bigint",
+			SIZE_SAMPLE, rows).Scan(ctx, &size)
+	} else if s.IsSqlite() {
+		// Pages of deleted rows are moved to the free list, they aren't pages of the tables anymore
+		err = s.db.NewRaw("SELECT COALESCE(SUM(pgsize), 0) FROM dbstat WHERE name IN (SELECT name FROM sqlite_schema WHERE tbl_name IN ('points', 'lap_chunks'))").Scan(ctx, &size)
+		if err != nil {
+			err = fmt.Errorf("failed measuring the points, SQLite needs the dbstat table: %w", err)
+		}
+	} else {
+		err = errors.New("unsupported database dialect")
+	}
+	if err != nil {
+		return limit, err
+	}
+
+	fitting := policy.MaxBytes * count / max(size, 1)
+	if limit < 0 || fitting < limit {
+		limit = fitting
+	}
+	return limit, nil
+}
+
+// deleteRaces deletes the races and their points, CLEANUP_CHUNK races at a time
+func (s *Store) deleteRaces(ctx context.Context, ids []uuid.UUID) error {
+	for start := 0; start < len(ids); start += CLEANUP_CHUNK {
+		chunk := ids[start:min(start+CLEANUP_CHUNK, len(ids))]
+		_, err := s.db.NewDelete().Model((*models.Point)(nil)).Where("race IN (?)", bun.In(chunk)).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.NewDelete().Model((*models.LapChunk)(nil)).Where("race IN (?)", bun.In(chunk)).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.NewDelete().Model((*models.Race)(nil)).Where("id IN (?)", bun.In(chunk)).Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PinRace pins or unpins a race, pinned races are kept by Cleanup. sql.ErrNoRows is returned when the race doesn't exist.
+func (s *Store) PinRace(id string, pinned bool, ctx context.Context) error {
+	result, err := s.db.NewUpdate().Model((*models.Race)(nil)).Set("pinned = ?", pinned).Where("id = ?", id).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		err = sql.ErrNoRows
+	}
+	return err
+}
+
+// archiveRace writes the race and its points to the archive, then deletes its points and flags it as archived
+func (s *Store) archiveRace(ctx context.Context, id uuid.UUID) error {
+	var race models.Race
+	err := s.db.NewSelect().Model(&race).Where("id = ?", id).Scan(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Streamed to the archive, a race without points has an empty archive
+	points := func(yield func(models.Point, error) bool) {
+		for point, err := range s.IterPoints(id.String(), nil, ctx) {
+			if errors.Is(err, sql.ErrNoRows) || !yield(point, err) {
+				return
+			}
+		}
+	}
+
+	race.Archived = true
+	err = s.archive.Write(race, points)
+	if err != nil {
+		return err
+	}
+
+	return s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().Model((*models.Point)(nil)).Where("race = ?", id).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().Model((*models.LapChunk)(nil)).Where("race = ?", id).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewUpdate().Model((*models.Race)(nil)).Set("archived = ?", true).Where("id = ?", id).Exec(ctx)
+		return err
+	})
+}
+
+// RestoreRace imports the points of an archived race back, the archive is kept. The race is created if it was deleted.
+// The restored race is aged from now by Cleanup, it isn't archived again by the next cleanup.
+// ErrNoArchive is returned when the store has no archive, an error wrapping os.ErrNotExist when the race isn't archived.
+func (s *Store) RestoreRace(id uuid.UUID, ctx context.Context) error {
+	if s.archive == nil {
+		return ErrNoArchive
+	}
+	reader, err := s.archive.Open(id)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	race := reader.Race
+	race.Archived = false
+	race.RestoredAt = time.Now()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// Points restored before are replaced
+		_, err := tx.NewDelete().Model((*models.Point)(nil)).Where("race = ?", id).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().Model((*models.LapChunk)(nil)).Where("race = ?", id).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		// A block of points at a time
+		for {
+			points, err := reader.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return err
+			}
+			err = s.insertPoints(ctx, conn, tx, points)
+			if err != nil {
+				return err
+			}
+		}
+		if s.layout == LAYOUT_LAPS && !race.InProgress {
+			err = s.compactRace(ctx, tx, id, -1)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.NewInsert().Model(&race).On("CONFLICT (id) DO UPDATE").Set("archived = EXCLUDED.archived").Set("restored_at = EXCLUDED.restored_at").Exec(ctx)
+		return err
+	})
+}
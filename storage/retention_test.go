@@ -0,0 +1,306 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/archive"
+	"forzatelemetry/models"
+	"forzatelemetry/storage"
+	"forzatelemetry/testutils"
+)
+
+func TestCleanup(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	now := time.Now()
+	day := 24 * time.Hour
+	races := []models.Race{
+		{ID: uuid.New(), FinishedAt: now.Add(-100 * day)},
+		{ID: uuid.New(), FinishedAt: now.Add(-10 * day)},
+		{ID: uuid.New(), FinishedAt: now.Add(-5 * day)},
+		{ID: uuid.New(), FinishedAt: now.Add(-200 * day), Pinned: true},
+		{ID: uuid.New(), InProgress: true},
+	}
+	err := db.UpsertRaces(context.Background(), races...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var points []models.Point
+	for i, count := range []int{3, 2, 1, 1, 1} {
+		for range count {
+			points = append(points, testutils.Point(races[i].ID, now, 1))
+		}
+	}
+	err = db.InsertPoints(points, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	month := storage.RetentionPolicy{MaxAge: 30 * day}
+	runs := []struct {
+		name   string
+		policy storage.RetentionPolicy
+		races  []uuid.UUID
+		points int64
+	}{
+		{"dry run", storage.RetentionPolicy{MaxAge: month.MaxAge, DryRun: true}, []uuid.UUID{races[0].ID}, 3},
+		{"age", month, []uuid.UUID{races[0].ID}, 3},
+		{"nothing", month, nil, 0},
+		// the oldest races are deleted until 3 points are left
+		{"points", storage.RetentionPolicy{MaxPoints: 3}, []uuid.UUID{races[1].ID}, 2},
+		{"bytes", storage.RetentionPolicy{MaxBytes: 1}, []uuid.UUID{races[2].ID}, 1},
+	}
+	for _, run := range runs {
+		t.Run(run.name, func(t *testing.T) {
+			report, err := db.Cleanup(context.Background(), run.policy)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if report.DryRun != run.policy.DryRun || !reflect.DeepEqual(report.Races, run.races) || report.Points != run.points {
+				t.Errorf("expected %v %v got %+v", run.races, run.points, report)
+			}
+		})
+	}
+
+	// pinned and in progress races are kept
+	for _, race := range races[3:] {
+		_, err = db.SelectRace(race.ID.String(), context.Background(), "")
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+}
+
+// The space of the deleted points isn't counted, a second cleanup without new points deletes nothing
+func TestCleanupBytesTwice(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	now := time.Now()
+	var points []models.Point
+	for i := range 20 {
+		race := models.Race{ID: uuid.New(), FinishedAt: now.Add(time.Duration(i-20) * time.Hour)}
+		err := db.UpsertRaces(context.Background(), race)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for range 100 {
+			points = append(points, testutils.Point(race.ID, race.FinishedAt, 1))
+		}
+	}
+	err := db.InsertPoints(points, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	policy := storage.RetentionPolicy{MaxBytes: 300 * 1024}
+	report, err := db.Cleanup(context.Background(), policy)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(report.Races) == 0 || len(report.Races) == 20 {
+		t.Fatalf("expected some races to be deleted got %v", len(report.Races))
+	}
+
+	report, err = db.Cleanup(context.Background(), policy)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(report.Races) != 0 {
+		t.Errorf("expected no race deleted got %v", len(report.Races))
+	}
+}
+
+// Only the points are measured, the other tables don't make the points look bigger
+func TestCleanupBytesOtherTables(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	now := time.Now()
+	var points []models.Point
+	for i := range 10 {
+		race := models.Race{ID: uuid.New(), FinishedAt: now.Add(time.Duration(i-10) * time.Hour)}
+		err := db.UpsertRaces(context.Background(), race)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for range 100 {
+			points = append(points, testutils.Point(race.ID, race.FinishedAt, 1))
+		}
+	}
+	err := db.InsertPoints(points, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// a few MB of sessions, more than the points
+	sessions := make([]models.Session, 5000)
+	for i := range sessions {
+		sessions[i] = models.Session{ID: uuid.New(), Source: strings.Repeat("s", 1000)}
+	}
+	err = db.UpsertSessions(context.Background(), sessions...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	report, err := db.Cleanup(context.Background(), storage.RetentionPolicy{MaxBytes: 2 * 1024 * 1024})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(report.Races) != 0 {
+		t.Errorf("expected no race deleted got %v", len(report.Races))
+	}
+}
+
+func TestPinRace(t *testing.T) {
+	db := testutils.NewStore("races.yaml")
+	defer db.Close()
+
+	id := "44e22d85-3883-4552-9ff4-91a7211e0639"
+	err := db.PinRace(id, true, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	race, err := db.SelectRace(id, context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !race.Pinned {
+		t.Errorf("expected the race to be pinned")
+	}
+
+	// kept by the cleanup while pinned
+	report, err := db.Cleanup(context.Background(), storage.DEFAULT_RETENTION_POLICY)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, deleted := range report.Races {
+		if deleted.String() == id {
+			t.Errorf("expected the pinned race to be kept")
+		}
+	}
+
+	// the race is updated by the sessions without being unpinned
+	err = db.UpsertRaces(context.Background(), models.Race{ID: race.ID, RaceTime: 10})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	race, err = db.SelectRace(id, context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !race.Pinned || race.RaceTime != 10 {
+		t.Errorf("unexpected race %+v", race.Race)
+	}
+
+	err = db.PinRace("44e22d85-3883-4552-9ff4-aaaaaaaaaaaa", true, context.Background())
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected %v got %v", sql.ErrNoRows, err)
+	}
+}
+
+func TestCleanupArchive(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	race := models.Race{ID: uuid.New(), FinishedAt: time.Now().Add(-100 * 24 * time.Hour)}
+	err := db.UpsertRaces(context.Background(), race)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	start := time.Now().Truncate(time.Millisecond)
+	points := make([]models.Point, 100)
+	for i := range points {
+		points[i] = testutils.Point(race.ID, start.Add(time.Duration(i)*time.Millisecond), 1)
+		points[i].CurrentRaceTime = float32(i)
+	}
+	err = db.InsertPoints(points, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	err = db.RestoreRace(race.ID, context.Background())
+	if !errors.Is(err, storage.ErrNoArchive) {
+		t.Errorf("expected %v got %v", storage.ErrNoArchive, err)
+	}
+
+	dir, err := archive.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	db.SetArchive(dir)
+
+	report, err := db.Cleanup(context.Background(), storage.DEFAULT_RETENTION_POLICY)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !report.Archived || !reflect.DeepEqual(report.Races, []uuid.UUID{race.ID}) || report.Points != 100 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// the race is kept without its points, and skipped by the next cleanups
+	archived, err := db.SelectRace(race.ID.String(), context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !archived.Archived {
+		t.Errorf("expected the race to be archived")
+	}
+	_, err = db.SelectLastPoint(race.ID.String(), context.Background())
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected %v got %v", sql.ErrNoRows, err)
+	}
+	report, err = db.Cleanup(context.Background(), storage.DEFAULT_RETENTION_POLICY)
+	if err != nil || len(report.Races) != 0 {
+		t.Errorf("unexpected report %+v %v", report, err)
+	}
+
+	err = db.RestoreRace(race.ID, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	restored, err := db.SelectRace(race.ID.String(), context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if restored.Archived || restored.RestoredAt.IsZero() {
+		t.Errorf("expected the race to be restored")
+	}
+	i := 0
+	for point, err := range db.IterPoints(race.ID.String(), nil, context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if point.TelemetryPoint != points[i].TelemetryPoint || !point.CreatedAt.Equal(points[i].CreatedAt) {
+			t.Fatalf("expected %+v got %+v", points[i], point)
+		}
+		i++
+	}
+	if i != len(points) {
+		t.Errorf("expected %v points got %v", len(points), i)
+	}
+
+	// finished long ago, the restored race isn't archived again by the next cleanup
+	report, err = db.Cleanup(context.Background(), storage.DEFAULT_RETENTION_POLICY)
+	if err != nil || len(report.Races) != 0 {
+		t.Errorf("unexpected report %+v %v", report, err)
+	}
+	last, err := db.SelectLastPoint(race.ID.String(), context.Background())
+	if err != nil || !last.CreatedAt.Equal(points[len(points)-1].CreatedAt) {
+		t.Errorf("expected the points to be kept got %v %v", last, err)
+	}
+
+	err = db.RestoreRace(uuid.New(), context.Background())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v got %v", os.ErrNotExist, err)
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+
+	"forzatelemetry/models"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+func (s *Store) UpsertSessions(ctx context.Context, sessions ...models.Session) error {
+	return upsertSessions(ctx, s.db, sessions)
+}
+
+func upsertSessions(ctx context.Context, db bun.IDB, sessions []models.Session) error {
+	_, err := db.NewInsert().Model(&sessions).On(
+		"CONFLICT (id) DO UPDATE").Set("finished_at = EXCLUDED.finished_at").Set("received = EXCLUDED.received").Set("dropped = EXCLUDED.dropped").Exec(ctx)
+	return err
+}
+
+// SelectSession returns the session with the count of its races
+func (s *Store) SelectSession(id string, ctx context.Context) (models.APISession, error) {
+	var session models.APISession
+	err := s.db.NewSelect().Model(&session).ColumnExpr("sessions.*").
+		ColumnExpr("(SELECT count(*) FROM races WHERE races.session_id = sessions.id) AS races").
+		Where("sessions.id = ?", id).Scan(ctx)
+	return session, err
+}
+
+// SelectSessions returns the sessions with the IDs, the IDs of sessions not saved are skipped
+func (s *Store) SelectSessions(ids []uuid.UUID, ctx context.Context) ([]models.Session, error) {
+	sessions := make([]models.Session, 0, len(ids))
+	if len(ids) == 0 {
+		return sessions, nil
+	}
+	err := s.db.NewSelect().Model(&sessions).Where("id IN (?)", bun.In(ids)).Scan(ctx)
+	return sessions, err
+}
+
+// SelectSessionRaces returns the races of the session, in the order they were driven
+func (s *Store) SelectSessionRaces(id string, ctx context.Context, dashboardBaseUrl string) ([]models.APIRace, error) {
+	races := make([]models.APIRace, 0)
+	query := s.makeRaceDetailledQuery(s.db.NewSelect().Model(&races))
+	err := query.Where("races.session_id = ?", id).Order("started_at ASC").Scan(ctx)
+
+	for i := range races {
+		races[i].Dashboard = buildDashboardUrl(races[i], dashboardBaseUrl)
+	}
+	return races, err
+}
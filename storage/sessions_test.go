@@ -0,0 +1,107 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+	"forzatelemetry/testutils"
+)
+
+func TestSelectSession(t *testing.T) {
+	db := testutils.NewStore("races.yaml", "sessions.yaml")
+	defer db.Close()
+
+	session, err := db.SelectSession("665078b0-1130-48a9-8a35-0e7cbfd7704c", context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if session.Source != "192.168.1.10:52000" || session.Received != 1200 || session.Dropped != 3 {
+		t.Errorf("unexpected session %+v", session)
+	}
+	if session.Races != 1 {
+		t.Errorf("expected 1 got %v", session.Races)
+	}
+
+	_, err = db.SelectSession("665078b0-1130-48a9-8a35-aaaaaaaaaaaa", context.Background())
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+}
+
+func TestUpsertSessions(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	session := models.Session{
+		ID:        testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"),
+		Source:    "127.0.0.1:5000",
+		Game:      models.GAME_FH,
+		Format:    "fh",
+		StartedAt: time.Now().Add(-time.Hour),
+	}
+	err := db.UpsertSessions(context.Background(), session)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	session.FinishedAt = time.Now()
+	session.Received = 10
+	err = db.UpsertSessions(context.Background(), session)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	saved, err := db.SelectSession(session.ID.String(), context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if saved.FinishedAt.IsZero() || saved.Received != 10 || saved.Game != models.GAME_FH {
+		t.Errorf("unexpected session %+v", saved)
+	}
+}
+
+func TestSelectSessionRaces(t *testing.T) {
+	db := testutils.NewStore("races.yaml", "sessions.yaml")
+	defer db.Close()
+
+	races, err := db.SelectSessionRaces("665078b0-1130-48a9-8a35-0e7cbfd7704c", context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(races) != 1 || races[0].ID != testutils.ParseUUID("44e22d85-3883-4552-9ff4-91a7211e0639") {
+		t.Errorf("unexpected races %v", races)
+	}
+}
+
+func TestUpsertRacesResumed(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	race := models.Race{ID: uuid.New(), SessionID: uuid.New(), InProgress: true}
+	err := db.UpsertRaces(context.Background(), race)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the race resumed by a later session of the source moves to it
+	previous := race.SessionID
+	race.SessionID = uuid.New()
+	err = db.UpsertRaces(context.Background(), race)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for session, expected := range map[uuid.UUID]int{previous: 0, race.SessionID: 1} {
+		races, err := db.SelectSessionRaces(session.String(), context.Background(), "")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if len(races) != expected {
+			t.Errorf("session %v: expected %v races got %v", session, expected, len(races))
+		}
+	}
+}
@@ -3,12 +3,12 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
 
+	"forzatelemetry/archive"
 	"forzatelemetry/models"
 
 	"github.com/uptrace/bun"
@@ -25,7 +25,9 @@ import (
 )
 
 type Store struct {
-	db *bun.DB
+	db      *bun.DB
+	archive *archive.Dir // optional, races are archived before being cleaned up
+	layout  PointsLayout
 }
 
 func NewStore(db *bun.DB) *Store {
@@ -33,8 +35,9 @@ func NewStore(db *bun.DB) *Store {
 		bundebug.WithEnabled(false),
 		bundebug.FromEnv("BUNDEBUG"),
 	))
+	db.AddQueryHook(metricsHook{})
 
-	return &Store{db}
+	return &Store{db: db}
 }
 
 func NewPGStore(dsn string) (*Store, error) {
@@ -58,6 +61,16 @@ func NewSqliteStore(dsn string) (*Store, error) {
 	return NewStore(bun.NewDB(sqldb, sqlitedialect.New())), nil
 }
 
+// SetArchive archives the races before Cleanup deletes their points, the races are kept and flagged as archived
+func (s *Store) SetArchive(dir *archive.Dir) {
+	s.archive = dir
+}
+
+// SetPointsLayout decides how the points of the finished laps are written, LAYOUT_ROWS by default
+func (s *Store) SetPointsLayout(layout PointsLayout) {
+	s.layout = layout
+}
+
 func (s *Store) Close() {
 	s.db.Close()
 }
@@ -106,6 +119,22 @@ func (s *Store) CreateTables(ctx context.Context) error {
 		return err
 	}
 	_, err = s.db.NewCreateTable().IfNotExists().Model((*models.CarClass)(nil)).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.NewCreateTable().IfNotExists().Model((*models.Session)(nil)).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.NewCreateTable().IfNotExists().Model((*models.Driver)(nil)).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.NewCreateTable().IfNotExists().Model((*models.DriverSource)(nil)).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.NewCreateTable().IfNotExists().Model((*models.LapChunk)(nil)).Exec(ctx)
 	return err
 }
 
@@ -116,40 +145,27 @@ func (s *Store) CreateIndexes(ctx context.Context) error {
 		createIndex = createIndex.Include("lap_number")
 	}
 	_, err := createIndex.Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.NewCreateIndex().IfNotExists().Model((*models.Race)(nil)).Index("race_sessionId").Column("session_id").Exec(ctx)
 	return err
 }
 
 func (s *Store) LoadFixtures(ctx context.Context, fixtures ...string) error {
 	s.db.RegisterModel((*models.Race)(nil))
 	s.db.RegisterModel((*models.Point)(nil))
+	s.db.RegisterModel((*models.LapChunk)(nil))
+	s.db.RegisterModel((*models.Session)(nil))
+	s.db.RegisterModel((*models.Driver)(nil))
+	s.db.RegisterModel((*models.DriverSource)(nil))
 
 	_, filename, _, _ := runtime.Caller(0)
 	fixtureDir := os.DirFS(filepath.Join(filepath.Dir(filename), "../testutils/fixtures"))
 	return dbfixture.New(s.db).Load(ctx, fixtureDir, fixtures...)
 }
 
-func (s *Store) Cleanup(ctx context.Context) error {
-	if s.IsPG() {
-		delRace := s.db.NewDelete().Model(&models.Race{}).Where("finished_at < CURRENT_DATE - interval '3 month'").Returning("id")
-		_, err := s.db.NewDelete().With("delRace", delRace).Model(&models.Point{}).Where("race in (SELECT id FROM ?)", bun.Ident("delRace")).Exec(ctx)
-		return err
-	} else if s.IsSqlite() {
-		// SQLite: delete points referencing old races, then delete old races
-		_, err := s.db.NewDelete().Model(&models.Point{}).Where(
-			"race IN (SELECT id FROM races WHERE finished_at < date('now', '-3 months'))",
-		).Exec(ctx)
-		if err != nil {
-			return err
-		}
-		_, err = s.db.NewDelete().Model(&models.Race{}).Where(
-			"finished_at < date('now', '-3 months')",
-		).Exec(ctx)
-		return err
-	} else {
-		return errors.New("unsupported database dialect")
-	}
-}
-
 func (s *Store) UpsertTracks(tracks []models.Track, ctx context.Context) error {
 	_, err := s.db.NewInsert().Model(&tracks).On("CONFLICT (ordinal) DO UPDATE").Set("name = EXCLUDED.name").Set("layout = EXCLUDED.layout").Set("location = EXCLUDED.location").Set("length = EXCLUDED.length").Exec(ctx)
 	return err
@@ -187,7 +187,7 @@ func TestLoadFixturesAndCleanup(t *testing.T) {
 	}
 
 	// Cleanup should not error even if nothing is deleted
-	err = store.Cleanup(context.Background())
+	_, err = store.Cleanup(context.Background(), storage.DEFAULT_RETENTION_POLICY)
 	if err != nil {
 		t.Fatalf("unexpected error cleaning up: %s", err)
 	}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Time range of the chunks of the points hypertable
+const POINTS_CHUNK_INTERVAL = "1 day"
+
+var ErrNoTimescale = errors.New("TimescaleDB requires PostgreSQL")
+
+// IsTimescale returns whether the points table is a TimescaleDB hypertable, always false on SQLite
+func (s *Store) IsTimescale(ctx context.Context) (bool, error) {
+	if !s.IsPG() {
+		return false, nil
+	}
+	var installed bool
+	err := s.db.NewRaw("SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(ctx, &installed)
+	if err != nil || !installed {
+		return false, err
+	}
+	var hypertable bool
+	err = s.db.NewRaw("SELECT EXISTS (SELECT 1 FROM timescaledb_information.hypertables WHERE hypertable_name = 'points')").Scan(ctx, &hypertable)
+	return hypertable, err
+}
+
+// CreateHypertable converts the points table to a TimescaleDB hypertable partitioned on created_at and enables the compression
+// of its chunks, segmented by race. The points saved are moved to the chunks, the table is locked until they are.
+// Compressed chunks can be deleted from and inserted into with TimescaleDB 2.11 or later.
+func (s *Store) CreateHypertable(ctx context.Context) error {
+	if !s.IsPG() {
+		return ErrNoTimescale
+	}
+	_, err := s.db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS timescaledb")
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "SELECT create_hypertable('points', 'created_at', chunk_time_interval => INTERVAL ?, migrate_data => true, if_not_exists => true)", POINTS_CHUNK_INTERVAL)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "ALTER TABLE points SET (timescaledb.compress, timescaledb.compress_segmentby = 'race', timescaledb.compress_orderby = 'created_at')")
+	return err
+}
+
+// SetCompressionPolicy compresses the chunks of points older than after in the background, 0 removes the policy
+func (s *Store) SetCompressionPolicy(ctx context.Context, after time.Duration) error {
+	if !s.IsPG() {
+		return ErrNoTimescale
+	}
+	_, err := s.db.ExecContext(ctx, "SELECT remove_compression_policy('points', if_exists => true)")
+	if err != nil || after <= 0 {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "SELECT add_compression_policy('points', compress_after => make_interval(secs => ?))", after.Seconds())
+	return err
+}
+
+// dropChunks drops the chunks of points older than before, compressed or not, and returns the count of chunks dropped
+func (s *Store) dropChunks(ctx context.Context, before time.Time) (int, error) {
+	var dropped int
+	err := s.db.NewRaw("SELECT count(*) FROM drop_chunks('points', older_than => ?::timestamptz)", before).Scan(ctx, &dropped)
+	return dropped, err
+}
+
+// dropBefore returns the time before which every point belongs to a deleted race: the start of the first race kept,
+// pinned races, races in progress and candidates after the deleted ones, now without race kept.
+// Archived races have no points left, they don't hold back the chunks.
+func (s *Store) dropBefore(ctx context.Context, candidates []cleanupCandidate, deleted int) (time.Time, error) {
+	var kept bun.NullTime
+	err := s.db.NewRaw("SELECT MIN(started_at) FROM races WHERE pinned OR in_progress").Scan(ctx, &kept)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	before := time.Now()
+	if !kept.IsZero() && kept.Before(before) {
+		before = kept.Time
+	}
+	for _, candidate := range candidates[deleted:] {
+		if candidate.StartedAt.Before(before) {
+			before = candidate.StartedAt
+		}
+	}
+	return before, nil
+}
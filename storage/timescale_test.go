@@ -0,0 +1,97 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+	"forzatelemetry/storage"
+	"forzatelemetry/testutils"
+)
+
+func TestTimescaleSqlite(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	timescale, err := db.IsTimescale(context.Background())
+	if err != nil || timescale {
+		t.Errorf("expected false got %v %v", timescale, err)
+	}
+	err = db.CreateHypertable(context.Background())
+	if !errors.Is(err, storage.ErrNoTimescale) {
+		t.Errorf("expected %v got %v", storage.ErrNoTimescale, err)
+	}
+}
+
+// The points table of the database is converted to a hypertable, only tested with a database with TimescaleDB
+func TestCleanupTimescalePG(t *testing.T) {
+	dsn := os.Getenv("TEST_TIMESCALE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_TIMESCALE_DSN not set")
+	}
+	db, err := storage.NewPGStore(dsn)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer db.Close()
+	err = db.CreateTables(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = db.CreateHypertable(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = db.SetCompressionPolicy(context.Background(), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	timescale, err := db.IsTimescale(context.Background())
+	if err != nil || !timescale {
+		t.Fatalf("expected true got %v %v", timescale, err)
+	}
+
+	day := 24 * time.Hour
+	now := time.Now().Truncate(time.Millisecond)
+	// the pinned race is more recent than the first race, the chunks of the first race only are dropped.
+	// The archived race has no points, it doesn't keep the chunks of the first race.
+	races := []models.Race{
+		{ID: uuid.New(), StartedAt: now.Add(-400 * day), FinishedAt: now.Add(-400 * day), Archived: true},
+		{ID: uuid.New(), StartedAt: now.Add(-300 * day), FinishedAt: now.Add(-300 * day)},
+		{ID: uuid.New(), StartedAt: now.Add(-200 * day), FinishedAt: now.Add(-200 * day), Pinned: true},
+		{ID: uuid.New(), StartedAt: now.Add(-100 * day), FinishedAt: now.Add(-100 * day)},
+	}
+	err = db.UpsertRaces(context.Background(), races...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var points []models.Point
+	for _, race := range races[1:] {
+		points = append(points, testutils.Point(race.ID, race.StartedAt, 1))
+	}
+	err = db.InsertPoints(points, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	report, err := db.Cleanup(context.Background(), storage.DEFAULT_RETENTION_POLICY)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(report.Races) < 2 || report.Chunks < 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	for i, race := range races {
+		_, err = db.SelectLastPoint(race.ID.String(), context.Background())
+		if race.Pinned && err != nil {
+			t.Errorf("race %v: unexpected error %v", i, err)
+		} else if !race.Pinned && err == nil {
+			t.Errorf("race %v: expected the points to be deleted", i)
+		}
+	}
+}
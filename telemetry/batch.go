@@ -0,0 +1,207 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+)
+
+// Reasons points are dropped by the BatchWriter
+const (
+	REASON_BATCH_FULL   = "full"
+	REASON_BATCH_FAILED = "failed"
+)
+
+// DEFAULT_BATCH_CONFIG writes every second or every 2000 points, about 10 sources at 60 points per second.
+// 50000 pending points are about 25MB.
+var DEFAULT_BATCH_CONFIG = BatchConfig{
+	Size:         2000,
+	Interval:     time.Second,
+	MaxPending:   50000,
+	MaxRetries:   5,
+	RetryBackoff: 100 * time.Millisecond,
+}
+
+type BatchConfig struct {
+	Size     int           // points triggering a write before the interval
+	Interval time.Duration // longest time a point waits to be written, must be positive
+	// Points kept in memory, waiting or being written. Points published above it are dropped.
+	MaxPending int
+	// Attempts after a failed write, waiting RetryBackoff then twice as long at each attempt. The batch is dropped after the last one.
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+// A Batch holds the latest state of the sessions and races published since the previous batch
+// and their points, one slice per checkpoint
+type Batch struct {
+	Sessions    []models.Session
+	Races       []models.Race
+	Checkpoints [][]models.Point
+}
+
+func (b *Batch) empty() bool {
+	return len(b.Sessions) == 0 && len(b.Races) == 0 && len(b.Checkpoints) == 0
+}
+
+// BatchStore saves a whole batch at once, a failed batch is written again
+type BatchStore interface {
+	WriteBatch(ctx context.Context, batch Batch) error
+}
+
+// BatchWriter is a Sink coalescing what the sessions publish into batches written from a single goroutine.
+// Publishing never waits for the database: points above BatchConfig.MaxPending are dropped.
+type BatchWriter struct {
+	store  BatchStore
+	config BatchConfig
+
+	m        sync.Mutex
+	pending  Batch
+	sessions map[uuid.UUID]int // index in pending.Sessions
+	races    map[uuid.UUID]int // index in pending.Races
+	points   int               // points waiting and being written
+
+	flush chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func NewBatchWriter(store BatchStore, config BatchConfig) *BatchWriter {
+	w := &BatchWriter{
+		store:    store,
+		config:   config,
+		sessions: map[uuid.UUID]int{},
+		races:    map[uuid.UUID]int{},
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+func (w *BatchWriter) PublishSession(ctx context.Context, session models.Session) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if i, ok := w.sessions[session.ID]; ok {
+		w.pending.Sessions[i] = session
+	} else {
+		w.sessions[session.ID] = len(w.pending.Sessions)
+		w.pending.Sessions = append(w.pending.Sessions, session)
+	}
+	return nil
+}
+
+func (w *BatchWriter) PublishRace(ctx context.Context, event RaceEvent) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if i, ok := w.races[event.Race.ID]; ok {
+		w.pending.Races[i] = event.Race
+	} else {
+		w.races[event.Race.ID] = len(w.pending.Races)
+		w.pending.Races = append(w.pending.Races, event.Race)
+	}
+	return nil
+}
+
+func (w *BatchWriter) PublishPoints(ctx context.Context, points []models.Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	w.m.Lock()
+	if w.config.MaxPending > 0 && w.points+len(points) > w.config.MaxPending {
+		w.m.Unlock()
+		batchDropped.Add(float64(len(points)), REASON_BATCH_FULL)
+		return fmt.Errorf("batch writer full, dropped %d points", len(points))
+	}
+	w.pending.Checkpoints = append(w.pending.Checkpoints, append([]models.Point(nil), points...))
+	w.points += len(points)
+	batchPending.Set(float64(w.points))
+	full := w.points >= w.config.Size
+	w.m.Unlock()
+
+	if full {
+		select {
+		case w.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (w *BatchWriter) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			w.write()
+			return
+		case <-ticker.C:
+		case <-w.flush:
+		}
+		w.write()
+	}
+}
+
+// write takes everything pending and writes it, retrying failed writes
+func (w *BatchWriter) write() {
+	w.m.Lock()
+	batch := w.pending
+	w.pending = Batch{}
+	clear(w.sessions)
+	clear(w.races)
+	w.m.Unlock()
+
+	if batch.empty() {
+		return
+	}
+
+	points := 0
+	for _, checkpoint := range batch.Checkpoints {
+		points += len(checkpoint)
+	}
+
+	start := time.Now()
+	backoff := w.config.RetryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = w.store.WriteBatch(context.Background(), batch)
+		if err == nil || attempt >= w.config.MaxRetries {
+			break
+		}
+		slog.Warn("failed writing batch, retrying", "error", err, "attempt", attempt+1, "backoff", backoff)
+		batchRetries.Inc()
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	if err != nil {
+		slog.Error("failed writing batch, dropped", "error", err, "sessions", len(batch.Sessions), "races", len(batch.Races), "points", points)
+		batchDropped.Add(float64(points), REASON_BATCH_FAILED)
+	} else {
+		batchPoints.Observe(float64(points))
+		batchDuration.Observe(time.Since(start).Seconds())
+	}
+
+	w.m.Lock()
+	w.points -= points
+	batchPending.Set(float64(w.points))
+	w.m.Unlock()
+}
+
+// Close writes what is pending and stops the writer. Nothing must be published after Close.
+func (w *BatchWriter) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	return nil
+}
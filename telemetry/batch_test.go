@@ -0,0 +1,189 @@
+package telemetry_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+)
+
+// Writes quickly enough for the tests to read what the sessions publish
+var testBatchConfig = telemetry.BatchConfig{
+	Size:         telemetry.DEFAULT_BATCH_CONFIG.Size,
+	Interval:     10 * time.Millisecond,
+	MaxPending:   telemetry.DEFAULT_BATCH_CONFIG.MaxPending,
+	MaxRetries:   3,
+	RetryBackoff: time.Millisecond,
+}
+
+// batchStore fails its first writes
+type batchStore struct {
+	m        sync.Mutex
+	fail     int
+	attempts int
+	batches  []telemetry.Batch
+}
+
+func (s *batchStore) WriteBatch(ctx context.Context, batch telemetry.Batch) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.attempts++
+	if s.attempts <= s.fail {
+		return errors.New("failed")
+	}
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *batchStore) Batches() []telemetry.Batch {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]telemetry.Batch(nil), s.batches...)
+}
+
+func batchPoints(race models.Race, n int) []models.Point {
+	points := make([]models.Point, n)
+	for i := range points {
+		points[i] = testutils.Point(race.ID, time.Now(), 1)
+	}
+	return points
+}
+
+func TestBatchWriterCoalesce(t *testing.T) {
+	store := &batchStore{}
+	config := testBatchConfig
+	config.Interval = time.Hour
+	writer := telemetry.NewBatchWriter(store, config)
+
+	sessions := []models.Session{
+		{ID: testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), Source: "127.0.0.1:5000"},
+		{ID: testutils.ParseUUID("665078b0-1130-48a9-8a35-0e7cbfd7704c"), Source: "127.0.0.1:5001"},
+	}
+	races := []models.Race{
+		{ID: testutils.ParseUUID("a6996827-6699-4206-8168-4584cb2176e2"), SessionID: sessions[0].ID},
+		{ID: testutils.ParseUUID("06874f47-0f56-44bd-9dc2-b12e5cbfed5e"), SessionID: sessions[1].ID},
+	}
+	for i := range sessions {
+		writer.PublishSession(context.Background(), sessions[i])
+		writer.PublishRace(context.Background(), telemetry.RaceEvent{Type: telemetry.RACE_STARTED, Race: races[i]})
+	}
+	points := batchPoints(races[0], 3)
+	writer.PublishPoints(context.Background(), points)
+	// the session reuses its points
+	points[0].CurrentRaceTime = 42
+	writer.PublishPoints(context.Background(), batchPoints(races[1], 2))
+
+	sessions[0].Received = 10
+	writer.PublishSession(context.Background(), sessions[0])
+	races[0].InProgress = false
+	races[0].RaceTime = 100
+	writer.PublishRace(context.Background(), telemetry.RaceEvent{Type: telemetry.RACE_ENDED, Race: races[0]})
+
+	err := writer.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	batches := store.Batches()
+	if len(batches) != 1 {
+		t.Fatalf("expected 1 got %v", len(batches))
+	}
+	batch := batches[0]
+	if len(batch.Sessions) != 2 || batch.Sessions[0].Received != 10 {
+		t.Errorf("unexpected sessions %+v", batch.Sessions)
+	}
+	if len(batch.Races) != 2 || batch.Races[0].RaceTime != 100 || batch.Races[1].ID != races[1].ID {
+		t.Errorf("unexpected races %+v", batch.Races)
+	}
+	if len(batch.Checkpoints) != 2 || len(batch.Checkpoints[0]) != 3 || len(batch.Checkpoints[1]) != 2 {
+		t.Fatalf("unexpected checkpoints %v", batch.Checkpoints)
+	}
+	if batch.Checkpoints[0][0].CurrentRaceTime == 42 {
+		t.Errorf("expected a copy of the points")
+	}
+}
+
+func TestBatchWriterSize(t *testing.T) {
+	store := &batchStore{}
+	config := testBatchConfig
+	config.Size = 10
+	config.Interval = time.Hour
+	writer := telemetry.NewBatchWriter(store, config)
+	defer writer.Close()
+
+	race := models.Race{ID: testutils.ParseUUID("a6996827-6699-4206-8168-4584cb2176e2")}
+	writer.PublishPoints(context.Background(), batchPoints(race, 5))
+	time.Sleep(20 * time.Millisecond)
+	if len(store.Batches()) != 0 {
+		t.Fatalf("expected 0 got %v", len(store.Batches()))
+	}
+
+	// a full batch is written before the interval
+	writer.PublishPoints(context.Background(), batchPoints(race, 5))
+	time.Sleep(20 * time.Millisecond)
+	batches := store.Batches()
+	if len(batches) != 1 || len(batches[0].Checkpoints) != 2 {
+		t.Fatalf("expected 1 batch of 2 checkpoints got %v", batches)
+	}
+}
+
+func TestBatchWriterRetry(t *testing.T) {
+	store := &batchStore{fail: 2}
+	writer := telemetry.NewBatchWriter(store, testBatchConfig)
+
+	race := models.Race{ID: testutils.ParseUUID("a6996827-6699-4206-8168-4584cb2176e2")}
+	writer.PublishPoints(context.Background(), batchPoints(race, 5))
+	writer.Close()
+
+	if store.attempts != 3 {
+		t.Errorf("expected 3 got %v", store.attempts)
+	}
+	if len(store.Batches()) != 1 {
+		t.Errorf("expected 1 got %v", len(store.Batches()))
+	}
+}
+
+func TestBatchWriterMaxPending(t *testing.T) {
+	// fails long enough to fill the writer
+	store := &batchStore{fail: 4}
+	config := testBatchConfig
+	config.MaxPending = 10
+	config.MaxRetries = 1
+	config.RetryBackoff = 50 * time.Millisecond
+	writer := telemetry.NewBatchWriter(store, config)
+
+	race := models.Race{ID: testutils.ParseUUID("a6996827-6699-4206-8168-4584cb2176e2")}
+	err := writer.PublishPoints(context.Background(), batchPoints(race, 8))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// the batch being retried still counts
+	err = writer.PublishPoints(context.Background(), batchPoints(race, 3))
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+	err = writer.PublishPoints(context.Background(), batchPoints(race, 2))
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// the first batch is dropped after its retry, the second one too, the memory is released
+	time.Sleep(200 * time.Millisecond)
+	err = writer.PublishPoints(context.Background(), batchPoints(race, 10))
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	writer.Close()
+
+	batches := store.Batches()
+	if len(batches) != 1 || len(batches[0].Checkpoints) != 1 || len(batches[0].Checkpoints[0]) != 10 {
+		t.Errorf("expected the last points got %v", batches)
+	}
+}
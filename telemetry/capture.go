@@ -0,0 +1,376 @@
+package telemetry
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Capture files start with the magic line followed by a JSON header line. Each datagram is then written as a record:
+// receive time in unix nanoseconds (int64), datagram length (uint16) and the raw datagram, little endian.
+const CAPTURE_MAGIC = "FORZATELEMETRY-CAPTURE\n"
+const CAPTURE_VERSION = 1
+const CAPTURE_EXTENSION = ".fcap"
+const CAPTURE_RECORD_HEADER_SIZE = 10
+
+// Datagrams are buffered at most this long before they are written to the file, a crash loses at most this much of a capture
+const CAPTURE_FLUSH_INTERVAL = time.Second
+
+const DEFAULT_CAPTURE_MAX_FILE_SIZE = 64 * 1024 * 1024
+const DEFAULT_CAPTURE_MAX_SIZE = 1024 * 1024 * 1024
+
+var ErrNotACapture = errors.New("not a capture file")
+
+type CaptureHeader struct {
+	Version int `json:"version"`
+	// ID of the capture of the source, shared by the parts of the capture. It isn't the ID of a telemetry session,
+	// the sessions of the source record it in their Capture field.
+	CaptureID uuid.UUID `json:"captureID"`
+	Source    string    `json:"source"`
+	Part      int       `json:"part"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type CaptureConfig struct {
+	Dir         string
+	All         bool     // capture every source
+	Sources     []string // IP or IP:port to capture when All is false
+	MaxFileSize int64    // rotate files bigger than this
+	MaxSize     int64    // delete the oldest files when the directory is bigger than this
+}
+
+// Capture writes raw datagrams to disk, one file per capture ID. Files are flushed every CAPTURE_FLUSH_INTERVAL.
+type Capture struct {
+	m    sync.Mutex
+	done chan struct{}
+	once sync.Once
+
+	dir         string
+	all         bool
+	sources     map[string]bool
+	maxFileSize int64
+	maxSize     int64
+
+	files map[string]*captureFile
+}
+
+type captureFile struct {
+	header CaptureHeader
+	file   *os.File
+	w      *bufio.Writer
+	size   int64
+}
+
+func NewCapture(config CaptureConfig) (*Capture, error) {
+	err := os.MkdirAll(config.Dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating capture directory: %w", err)
+	}
+
+	if config.MaxFileSize <= 0 {
+		config.MaxFileSize = DEFAULT_CAPTURE_MAX_FILE_SIZE
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = DEFAULT_CAPTURE_MAX_SIZE
+	}
+
+	capture := &Capture{
+		dir:         config.Dir,
+		all:         config.All,
+		sources:     make(map[string]bool),
+		maxFileSize: config.MaxFileSize,
+		maxSize:     config.MaxSize,
+		files:       make(map[string]*captureFile),
+		done:        make(chan struct{}),
+	}
+	for _, source := range config.Sources {
+		capture.sources[source] = true
+	}
+	go capture.flushEvery(CAPTURE_FLUSH_INTERVAL)
+	return capture, nil
+}
+
+// flushEvery writes the buffered datagrams to the files until the capture is closed
+func (c *Capture) flushEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.m.Lock()
+			for _, f := range c.files {
+				err := f.w.Flush()
+				if err != nil {
+					slog.Error("failed flushing capture", "error", err, "capture", f.header.CaptureID)
+				}
+			}
+			c.m.Unlock()
+		}
+	}
+}
+
+// SetAll toggles the capture of every source
+func (c *Capture) SetAll(enabled bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.all = enabled
+}
+
+// SetSource toggles the capture of a source, either an IP or an IP:port
+func (c *Capture) SetSource(source string, enabled bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if enabled {
+		c.sources[source] = true
+	} else {
+		delete(c.sources, source)
+	}
+}
+
+func (c *Capture) enabled(source string) bool {
+	if c.all || c.sources[source] {
+		return true
+	}
+	host, _, err := net.SplitHostPort(source)
+	return err == nil && c.sources[host]
+}
+
+// CaptureID returns the ID of the capture of a source, if it's being captured
+func (c *Capture) CaptureID(source string) (uuid.UUID, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	f, ok := c.files[source]
+	if !ok {
+		return uuid.UUID{}, false
+	}
+	return f.header.CaptureID, true
+}
+
+func (c *Capture) Write(source string, at time.Time, data []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if !c.enabled(source) {
+		return nil
+	}
+
+	f, ok := c.files[source]
+	if !ok {
+		var err error
+		f, err = c.create(CaptureHeader{CaptureID: uuid.New(), Source: source})
+		if err != nil {
+			return err
+		}
+		c.files[source] = f
+		c.enforceRetention()
+	} else if f.size >= c.maxFileSize {
+		err := f.close()
+		if err != nil {
+			slog.Error("failed closing capture", "error", err, "capture", f.header.CaptureID)
+		}
+		f, err = c.create(CaptureHeader{CaptureID: f.header.CaptureID, Source: source, Part: f.header.Part + 1})
+		if err != nil {
+			delete(c.files, source)
+			return err
+		}
+		c.files[source] = f
+		c.enforceRetention()
+	}
+
+	var header [CAPTURE_RECORD_HEADER_SIZE]byte
+	binary.LittleEndian.PutUint64(header[0:8], uint64(at.UnixNano()))
+	binary.LittleEndian.PutUint16(header[8:10], uint16(len(data)))
+	_, err := f.w.Write(header[:])
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(data)
+	f.size += int64(len(header) + len(data))
+	return err
+}
+
+func (c *Capture) create(header CaptureHeader) (*captureFile, error) {
+	header.Version = CAPTURE_VERSION
+	header.StartedAt = time.Now()
+
+	name := header.CaptureID.String()
+	if header.Part > 0 {
+		name = fmt.Sprintf("%s-%d", name, header.Part)
+	}
+	file, err := os.Create(filepath.Join(c.dir, name+CAPTURE_EXTENSION))
+	if err != nil {
+		return nil, fmt.Errorf("failed creating capture: %w", err)
+	}
+
+	f := &captureFile{header: header, file: file, w: bufio.NewWriter(file)}
+	data, err := json.Marshal(header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	n, err := fmt.Fprintf(f.w, "%s%s\n", CAPTURE_MAGIC, data)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.size = int64(n)
+	return f, nil
+}
+
+// enforceRetention deletes the oldest capture files until the directory fits in the size limit
+func (c *Capture) enforceRetention() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		slog.Error("failed listing captures", "error", err)
+		return
+	}
+
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []entry
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), CAPTURE_EXTENSION) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, entry{path: filepath.Join(c.dir, e.Name()), size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= c.maxSize {
+			return
+		}
+		if c.isOpen(f.path) {
+			continue
+		}
+		err := os.Remove(f.path)
+		if err != nil {
+			slog.Error("failed deleting capture", "error", err, "path", f.path)
+			continue
+		}
+		slog.Info("deleted capture", "path", f.path, "size", f.size)
+		total -= f.size
+	}
+}
+
+func (c *Capture) isOpen(path string) bool {
+	for _, f := range c.files {
+		if f.file.Name() == path {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseSource ends the capture of a source, its next datagram starts a new capture
+func (c *Capture) CloseSource(source string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	f, ok := c.files[source]
+	if !ok {
+		return nil
+	}
+	delete(c.files, source)
+	return f.close()
+}
+
+func (c *Capture) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var errs []error
+	for source, f := range c.files {
+		errs = append(errs, f.close())
+		delete(c.files, source)
+	}
+	return errors.Join(errs...)
+}
+
+func (f *captureFile) close() error {
+	err := f.w.Flush()
+	return errors.Join(err, f.file.Close())
+}
+
+type CaptureRecord struct {
+	At   time.Time
+	Data []byte
+}
+
+type CaptureReader struct {
+	Header CaptureHeader
+	r      *bufio.Reader
+}
+
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	reader := bufio.NewReader(r)
+
+	magic := make([]byte, len(CAPTURE_MAGIC))
+	_, err := io.ReadFull(reader, magic)
+	if err != nil || string(magic) != CAPTURE_MAGIC {
+		return nil, ErrNotACapture
+	}
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed reading capture header: %w", err)
+	}
+	var header CaptureHeader
+	err = json.Unmarshal(line, &header)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading capture header: %w", err)
+	}
+	if header.Version != CAPTURE_VERSION {
+		return nil, fmt.Errorf("unsupported capture version %d", header.Version)
+	}
+
+	return &CaptureReader{Header: header, r: reader}, nil
+}
+
+// Next returns the next datagram of the capture, io.EOF at the end of the capture
+func (r *CaptureReader) Next() (CaptureRecord, error) {
+	var header [CAPTURE_RECORD_HEADER_SIZE]byte
+	_, err := io.ReadFull(r.r, header[:])
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return CaptureRecord{}, fmt.Errorf("truncated capture: %w", err)
+		}
+		return CaptureRecord{}, err
+	}
+
+	record := CaptureRecord{
+		At:   time.Unix(0, int64(binary.LittleEndian.Uint64(header[0:8]))),
+		Data: make([]byte, binary.LittleEndian.Uint16(header[8:10])),
+	}
+	_, err = io.ReadFull(r.r, record.Data)
+	if err != nil {
+		return CaptureRecord{}, fmt.Errorf("truncated capture: %w", err)
+	}
+	return record, nil
+}
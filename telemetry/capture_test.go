@@ -0,0 +1,186 @@
+package telemetry_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"forzatelemetry/telemetry"
+)
+
+func readCapture(t *testing.T, path string) (telemetry.CaptureHeader, []telemetry.CaptureRecord) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer f.Close()
+
+	reader, err := telemetry.NewCaptureReader(f)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var records []telemetry.CaptureRecord
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		records = append(records, record)
+	}
+	return reader.Header, records
+}
+
+func captureFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+telemetry.CAPTURE_EXTENSION))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return files
+}
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+	capture, err := telemetry.NewCapture(telemetry.CaptureConfig{Dir: dir, Sources: []string{"10.0.0.1", "10.0.0.2:1000"}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	at := time.UnixMilli(1725479276147)
+	for _, source := range []string{"10.0.0.1:1000", "10.0.0.2:1000", "10.0.0.2:2000", "10.0.0.3:1000"} {
+		err = capture.Write(source, at, []byte(source))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	err = capture.Write("10.0.0.1:1000", at.Add(time.Second), []byte("second"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	id, ok := capture.CaptureID("10.0.0.1:1000")
+	if !ok {
+		t.Fatalf("expected capture of 10.0.0.1:1000")
+	}
+	_, ok = capture.CaptureID("10.0.0.3:1000")
+	if ok {
+		t.Errorf("unexpected capture of 10.0.0.3:1000")
+	}
+
+	err = capture.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	files := captureFiles(t, dir)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 got %v", len(files))
+	}
+
+	header, records := readCapture(t, filepath.Join(dir, id.String()+telemetry.CAPTURE_EXTENSION))
+	if header.Source != "10.0.0.1:1000" || header.CaptureID != id {
+		t.Errorf("unexpected header %+v", header)
+	}
+	expected := []telemetry.CaptureRecord{
+		{At: at, Data: []byte("10.0.0.1:1000")},
+		{At: at.Add(time.Second), Data: []byte("second")},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %v got %v", expected, records)
+	}
+}
+
+// Datagrams reach the file without closing the capture, a crash doesn't lose them
+func TestCaptureFlush(t *testing.T) {
+	dir := t.TempDir()
+	capture, err := telemetry.NewCapture(telemetry.CaptureConfig{Dir: dir, All: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer capture.Close()
+
+	err = capture.Write("10.0.0.1:1000", time.Now(), []byte("data"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	time.Sleep(telemetry.CAPTURE_FLUSH_INTERVAL + 200*time.Millisecond)
+
+	id, _ := capture.CaptureID("10.0.0.1:1000")
+	_, records := readCapture(t, filepath.Join(dir, id.String()+telemetry.CAPTURE_EXTENSION))
+	if len(records) != 1 || string(records[0].Data) != "data" {
+		t.Errorf("expected 1 record got %v", records)
+	}
+}
+
+func TestCaptureToggle(t *testing.T) {
+	dir := t.TempDir()
+	capture, err := telemetry.NewCapture(telemetry.CaptureConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer capture.Close()
+
+	capture.Write("10.0.0.1:1000", time.Now(), []byte("data"))
+	if len(captureFiles(t, dir)) != 0 {
+		t.Errorf("expected no capture")
+	}
+
+	capture.SetAll(true)
+	capture.Write("10.0.0.1:1000", time.Now(), []byte("data"))
+	capture.SetAll(false)
+	capture.SetSource("10.0.0.2:1000", true)
+	capture.Write("10.0.0.2:1000", time.Now(), []byte("data"))
+	capture.Write("10.0.0.3:1000", time.Now(), []byte("data"))
+	if len(captureFiles(t, dir)) != 2 {
+		t.Errorf("expected 2 got %v", len(captureFiles(t, dir)))
+	}
+}
+
+func TestCaptureRotation(t *testing.T) {
+	dir := t.TempDir()
+	capture, err := telemetry.NewCapture(telemetry.CaptureConfig{Dir: dir, All: true, MaxFileSize: 200, MaxSize: 600})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	data := []byte(strings.Repeat("a", 100))
+	for range 10 {
+		err = capture.Write("10.0.0.1:1000", time.Now(), data)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	err = capture.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// each file holds the header and one datagram, the oldest files were deleted to stay under 600 bytes
+	files := captureFiles(t, dir)
+	if len(files) < 2 || len(files) > 4 {
+		t.Errorf("expected 2 to 4 files got %v", len(files))
+	}
+	for _, file := range files {
+		header, records := readCapture(t, file)
+		if header.Part == 0 {
+			t.Errorf("expected first part to be deleted")
+		}
+		if len(records) != 1 {
+			t.Errorf("expected 1 got %v", len(records))
+		}
+	}
+}
+
+func TestCaptureReaderInvalid(t *testing.T) {
+	_, err := telemetry.NewCaptureReader(strings.NewReader("hello"))
+	if !errors.Is(err, telemetry.ErrNotACapture) {
+		t.Errorf("expected %v got %v", telemetry.ErrNotACapture, err)
+	}
+}
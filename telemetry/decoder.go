@@ -0,0 +1,190 @@
+package telemetry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"forzatelemetry/models"
+)
+
+// Size of the sled section, common to all the packet layouts
+const SLED_SIZE = 232
+
+// Size of the dash section, following the sled section
+const DASH_SIZE = 79
+
+// Size of the Forza Motorsport 8 dash packet, the layout of models.TelemetryPoint
+const FM8_PACKET_SIZE = 331
+
+// Forza Motorsport 7 "Sled" packet, only the sled section
+const FM7_SLED_PACKET_SIZE = SLED_SIZE
+
+// Forza Motorsport 7 "Dash" packet, the sled and dash sections
+const FM7_DASH_PACKET_SIZE = SLED_SIZE + DASH_SIZE
+
+// Forza Horizon adds a 12 bytes block between the sled and the dash sections and a trailing byte
+const FH_PACKET_SIZE = 324
+const FH_EXTRA_SIZE = 12
+
+// A Decoder fills a TelemetryPoint from a packet of its format. Fields the format lacks are left untouched.
+type Decoder func(buf []byte, point *models.TelemetryPoint) error
+
+// A Format describes a known packet layout. Formats are identified by their packet length.
+type Format struct {
+	Version string // unique name of the layout
+	Game    models.Game
+	Size    int
+	Fields  models.Fields
+
+	Decode Decoder
+
+	// Segments of the built-in formats, decoded without calling Decode, see layoutFormat
+	segments []segment
+}
+
+type segment struct {
+	offset int
+	length int
+}
+
+// decodeSegments concatenates parts of the packet to build the Forza Motorsport 8 layout, then decodes it.
+// Parts of the Forza Motorsport 8 layout not covered by the segments are left to zero.
+func decodeSegments(buf []byte, segments []segment, point *models.TelemetryPoint) {
+	// Packets already in the layout are decoded in place
+	if len(segments) == 1 && segments[0].offset == 0 && segments[0].length == FM8_PACKET_SIZE && len(buf) >= FM8_PACKET_SIZE {
+		decodeLayout((*[FM8_PACKET_SIZE]byte)(buf), point)
+		return
+	}
+
+	var layout [FM8_PACKET_SIZE]byte
+	n := 0
+	for _, s := range segments {
+		end := min(s.offset+s.length, len(buf))
+		if s.offset < end {
+			copy(layout[n:], buf[s.offset:end])
+		}
+		n += s.length
+	}
+	decodeLayout(&layout, point)
+}
+
+// layoutFormat sets the decoder of a format made of segments of the Forza Motorsport 8 layout.
+// Decode calls decodeSegments directly, the point doesn't escape through the Decoder and decoding doesn't allocate.
+func layoutFormat(format Format, segments ...segment) Format {
+	format.segments = segments
+	format.Decode = func(buf []byte, point *models.TelemetryPoint) error {
+		decodeSegments(buf, segments, point)
+		return nil
+	}
+	return format
+}
+
+var FORMAT_FM8 = layoutFormat(Format{
+	Version: "fm8-dash",
+	Game:    models.GAME_FM8,
+	Size:    FM8_PACKET_SIZE,
+	Fields:  models.FIELDS_ALL,
+}, segment{0, FM8_PACKET_SIZE})
+
+var FORMAT_FM7_SLED = layoutFormat(Format{
+	Version: "fm7-sled",
+	Game:    models.GAME_FM7,
+	Size:    FM7_SLED_PACKET_SIZE,
+	Fields:  models.FIELDS_SLED,
+}, segment{0, SLED_SIZE})
+
+var FORMAT_FM7_DASH = layoutFormat(Format{
+	Version: "fm7-dash",
+	Game:    models.GAME_FM7,
+	Size:    FM7_DASH_PACKET_SIZE,
+	Fields:  models.FIELDS_SLED | models.FIELDS_DASH,
+}, segment{0, SLED_SIZE + DASH_SIZE})
+
+var FORMAT_FH = layoutFormat(Format{
+	Version: "fh-dash",
+	Game:    models.GAME_FH,
+	Size:    FH_PACKET_SIZE,
+	Fields:  models.FIELDS_SLED | models.FIELDS_DASH,
+}, segment{0, SLED_SIZE}, segment{SLED_SIZE + FH_EXTRA_SIZE, DASH_SIZE})
+
+var formats = struct {
+	sync.RWMutex
+	bySize map[int]Format
+}{bySize: map[int]Format{}}
+
+func init() {
+	for _, format := range []Format{FORMAT_FM8, FORMAT_FM7_SLED, FORMAT_FM7_DASH, FORMAT_FH} {
+		err := RegisterFormat(format)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterFormat adds a packet format to the registry. Two formats can't share the same packet length.
+func RegisterFormat(format Format) error {
+	formats.Lock()
+	defer formats.Unlock()
+
+	if format.Decode == nil {
+		return fmt.Errorf("format %s: missing decoder", format.Version)
+	}
+	if existing, ok := formats.bySize[format.Size]; ok {
+		return fmt.Errorf("format %s: packet length %d already used by %s", format.Version, format.Size, existing.Version)
+	}
+	formats.bySize[format.Size] = format
+	return nil
+}
+
+func LookupFormat(size int) (Format, bool) {
+	formats.RLock()
+	defer formats.RUnlock()
+
+	format, ok := formats.bySize[size]
+	return format, ok
+}
+
+// Formats returns the registered formats, ordered by packet length
+func Formats() []Format {
+	formats.RLock()
+	defer formats.RUnlock()
+
+	result := make([]Format, 0, len(formats.bySize))
+	for _, format := range formats.bySize {
+		result = append(result, format)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Size < result[j].Size })
+	return result
+}
+
+type Packet struct {
+	models.TelemetryPoint
+	Format Format
+}
+
+// Decode a packet based on its length. Packets with an unknown length are rejected.
+func Decode(buf []byte) (Packet, error) {
+	format, ok := LookupFormat(len(buf))
+	if !ok {
+		return Packet{}, &InvalidPacketError{Reason: REASON_LENGTH, Msg: fmt.Sprintf("unknown packet length %d", len(buf))}
+	}
+
+	packet := Packet{Format: format}
+	if format.segments != nil {
+		decodeSegments(buf, format.segments, &packet.TelemetryPoint)
+	} else {
+		// Escapes through the Decoder, only allocated for the registered formats
+		var point models.TelemetryPoint
+		err := format.Decode(buf, &point)
+		if err != nil {
+			return Packet{}, &InvalidPacketError{Reason: REASON_DECODE, Msg: fmt.Sprintf("failed decoding %s packet", format.Version), err: err}
+		}
+		packet.TelemetryPoint = point
+	}
+
+	if !format.Fields.Has(models.FIELDS_TRACK) {
+		packet.TrackOrdinal = models.UNKNOWN_TRACK
+	}
+	return packet, nil
+}
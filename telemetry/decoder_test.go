@@ -0,0 +1,212 @@
+package telemetry_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+)
+
+func encodeFM8(t *testing.T, point models.TelemetryPoint) []byte {
+	var buf bytes.Buffer
+	err := binary.Write(&buf, binary.LittleEndian, point)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeFH(t *testing.T, point models.TelemetryPoint) []byte {
+	fm8 := encodeFM8(t, point)
+
+	buf := make([]byte, 0, telemetry.FH_PACKET_SIZE)
+	buf = append(buf, fm8[:telemetry.SLED_SIZE]...)
+	buf = append(buf, bytes.Repeat([]byte{0xff}, telemetry.FH_EXTRA_SIZE)...)
+	buf = append(buf, fm8[telemetry.SLED_SIZE:telemetry.SLED_SIZE+telemetry.DASH_SIZE]...)
+	buf = append(buf, 0xff)
+	return buf
+}
+
+func decode(t *testing.T, buf []byte) telemetry.Packet {
+	packet, err := telemetry.Decode(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return packet
+}
+
+func TestDecodeFM8(t *testing.T) {
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1).TelemetryPoint
+
+	packet := decode(t, encodeFM8(t, point))
+	if packet.Format.Game != models.GAME_FM8 {
+		t.Errorf("expected %v got %v", models.GAME_FM8, packet.Format.Game)
+	}
+	if !reflect.DeepEqual(packet.TelemetryPoint, point) {
+		t.Errorf("expected %+v got %+v", point, packet.TelemetryPoint)
+	}
+}
+
+func TestDecodeFH(t *testing.T) {
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1).TelemetryPoint
+
+	packet := decode(t, encodeFH(t, point))
+	if packet.Format.Game != models.GAME_FH {
+		t.Errorf("expected %v got %v", models.GAME_FH, packet.Format.Game)
+	}
+
+	// Horizon doesn't send tire wear and track
+	point.TireWearFrontLeft = 0
+	point.TireWearFrontRight = 0
+	point.TireWearRearLeft = 0
+	point.TireWearRearRight = 0
+	point.TrackOrdinal = models.UNKNOWN_TRACK
+	if !reflect.DeepEqual(packet.TelemetryPoint, point) {
+		t.Errorf("expected %+v got %+v", point, packet.TelemetryPoint)
+	}
+}
+
+func TestDecodeUnknownLength(t *testing.T) {
+	_, err := telemetry.Decode([]byte("hi"))
+
+	var invalid *telemetry.InvalidPacketError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected %T got %v", invalid, err)
+	}
+	if invalid.Reason != telemetry.REASON_LENGTH {
+		t.Errorf("expected %v got %v", telemetry.REASON_LENGTH, invalid.Reason)
+	}
+}
+
+func TestDecodeFM7(t *testing.T) {
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1).TelemetryPoint
+	fm8 := encodeFM8(t, point)
+
+	packet := decode(t, fm8[:telemetry.FM7_DASH_PACKET_SIZE])
+	if packet.Format.Version != telemetry.FORMAT_FM7_DASH.Version {
+		t.Errorf("expected %v got %v", telemetry.FORMAT_FM7_DASH.Version, packet.Format.Version)
+	}
+	if packet.Format.Fields.Has(models.FIELDS_TIRE_WEAR) {
+		t.Errorf("expected no tire wear got %v", packet.Format.Fields.Names())
+	}
+	if packet.CurrentRaceTime != point.CurrentRaceTime {
+		t.Errorf("expected %v got %v", point.CurrentRaceTime, packet.CurrentRaceTime)
+	}
+	if packet.TireWearFrontLeft != 0 {
+		t.Errorf("expected 0 got %v", packet.TireWearFrontLeft)
+	}
+	if packet.TrackOrdinal != models.UNKNOWN_TRACK {
+		t.Errorf("expected %v got %v", models.UNKNOWN_TRACK, packet.TrackOrdinal)
+	}
+
+	packet = decode(t, fm8[:telemetry.FM7_SLED_PACKET_SIZE])
+	if packet.Format.Version != telemetry.FORMAT_FM7_SLED.Version {
+		t.Errorf("expected %v got %v", telemetry.FORMAT_FM7_SLED.Version, packet.Format.Version)
+	}
+	if packet.Format.Fields.Has(models.FIELDS_DASH) {
+		t.Errorf("expected no dash got %v", packet.Format.Fields.Names())
+	}
+	if packet.NumCylinders != point.NumCylinders {
+		t.Errorf("expected %v got %v", point.NumCylinders, packet.NumCylinders)
+	}
+	if packet.CurrentLap != 0 {
+		t.Errorf("expected 0 got %v", packet.CurrentLap)
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	err := telemetry.RegisterFormat(telemetry.Format{Version: "duplicate", Size: telemetry.FM8_PACKET_SIZE, Decode: telemetry.FORMAT_FM8.Decode})
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+
+	err = telemetry.RegisterFormat(telemetry.Format{Version: "noDecoder", Size: 1})
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+
+	sizes := []int{}
+	for _, format := range telemetry.Formats() {
+		sizes = append(sizes, format.Size)
+	}
+	expected := []int{telemetry.FM7_SLED_PACKET_SIZE, telemetry.FM7_DASH_PACKET_SIZE, telemetry.FH_PACKET_SIZE, telemetry.FM8_PACKET_SIZE}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected %v got %v", expected, sizes)
+	}
+}
+
+func TestDecodeAllocations(t *testing.T) {
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1).TelemetryPoint
+
+	for _, buf := range [][]byte{encodeFM8(t, point), encodeFH(t, point)} {
+		// the path of every packet read by the server
+		allocs := testing.AllocsPerRun(100, func() {
+			packet, err := telemetry.Decode(buf)
+			if err == nil {
+				telemetry.Validate(packet)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("%d bytes packet: expected 0 allocations got %v", len(buf), allocs)
+		}
+	}
+}
+
+// FuzzDecode checks the decoder matches binary.Read, compared once encoded again to tell NaN payloads apart
+func FuzzDecode(f *testing.F) {
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1).TelemetryPoint
+	var seed bytes.Buffer
+	binary.Write(&seed, binary.LittleEndian, point)
+	f.Add(seed.Bytes())
+	f.Add(bytes.Repeat([]byte{0xff}, telemetry.FM8_PACKET_SIZE))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buf := make([]byte, telemetry.FM8_PACKET_SIZE)
+		copy(buf, data)
+
+		packet, err := telemetry.Decode(buf)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		var expected models.TelemetryPoint
+		err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &expected)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		if !bytes.Equal(encodeFM8(t, packet.TelemetryPoint), encodeFM8(t, expected)) {
+			t.Errorf("expected %+v got %+v", expected, packet.TelemetryPoint)
+		}
+	})
+}
+
+func BenchmarkDecode(b *testing.B) {
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1).TelemetryPoint
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, point)
+
+	b.ReportAllocs()
+	for range b.N {
+		telemetry.Decode(buf.Bytes())
+	}
+}
+
+// BenchmarkDecodeBinaryRead is the reflection based decoding the server used before, for comparison
+func BenchmarkDecodeBinaryRead(b *testing.B) {
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1).TelemetryPoint
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, point)
+
+	b.ReportAllocs()
+	for range b.N {
+		var decoded models.TelemetryPoint
+		binary.Read(bytes.NewReader(buf.Bytes()), binary.LittleEndian, &decoded)
+	}
+}
@@ -0,0 +1,53 @@
+package telemetry
+
+import (
+	"context"
+	"sync"
+
+	"forzatelemetry/models"
+)
+
+// driverBindings caches the sources bound to drivers, new sessions resolve their driver without querying the database
+type driverBindings struct {
+	m       sync.RWMutex
+	sources map[string]int64 // driver ID per source
+}
+
+func (b *driverBindings) set(drivers []models.Driver) {
+	sources := make(map[string]int64)
+	for _, driver := range drivers {
+		for _, source := range driver.Sources {
+			sources[source.Source] = driver.ID
+		}
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.sources = sources
+}
+
+// resolve returns the ID of the driver bound to the source address, an IP:port binding is preferred to an IP binding
+func (b *driverBindings) resolve(addr string) int64 {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	for _, candidate := range models.SourceCandidates(addr) {
+		if driver, ok := b.sources[candidate]; ok {
+			return driver
+		}
+	}
+	return 0
+}
+
+// RefreshDrivers reloads the sources bound to drivers from the database, it must be called after they change.
+// Sessions started afterwards record the new driver of their source.
+func (s *Server) RefreshDrivers(ctx context.Context) error {
+	if s.store == nil {
+		return nil
+	}
+	drivers, err := s.store.db.SelectDrivers(ctx)
+	if err != nil {
+		return err
+	}
+	s.drivers.set(drivers)
+	return nil
+}
@@ -0,0 +1,466 @@
+package telemetry
+
+import (
+	"encoding/binary"
+	"math"
+
+	"forzatelemetry/models"
+)
+
+// layoutReader reads the little endian values of a packet in order, the caller checks the length of the buffer
+type layoutReader struct {
+	buf []byte
+	off int
+}
+
+func (r *layoutReader) u8() uint8 {
+	v := r.buf[r.off]
+	r.off++
+	return v
+}
+
+func (r *layoutReader) i8() int8 {
+	return int8(r.u8())
+}
+
+func (r *layoutReader) u16() uint16 {
+	v := binary.LittleEndian.Uint16(r.buf[r.off:])
+	r.off += 2
+	return v
+}
+
+func (r *layoutReader) u32() uint32 {
+	v := binary.LittleEndian.Uint32(r.buf[r.off:])
+	r.off += 4
+	return v
+}
+
+func (r *layoutReader) i32() int32 {
+	return int32(r.u32())
+}
+
+func (r *layoutReader) f32() float32 {
+	return math.Float32frombits(r.u32())
+}
+
+// decodeLayout decodes a packet with the Forza Motorsport 8 layout, field by field in the order of models.TelemetryPoint.
+// It's the hot path of the server: no reflection and no allocation, unlike binary.Read.
+func decodeLayout(buf *[FM8_PACKET_SIZE]byte, point *models.TelemetryPoint) {
+	r := layoutReader{buf: buf[:]}
+
+	point.OnTrack = r.i32()
+	point.TimestampMS = r.u32()
+
+	point.EngineMaxRPM = r.f32()
+	point.EngineIdleRPM = r.f32()
+	point.EngineCurrentRPM = r.f32()
+
+	point.AccelarationX = r.f32()
+	point.AccelerationY = r.f32()
+	point.AccelerationZ = r.f32()
+
+	point.VelocityX = r.f32()
+	point.VelocityY = r.f32()
+	point.VelocityZ = r.f32()
+
+	point.AngularVelocityX = r.f32()
+	point.AngularVelocityY = r.f32()
+	point.AngularVelocityZ = r.f32()
+
+	point.Yaw = r.f32()
+	point.Pitch = r.f32()
+	point.Roll = r.f32()
+
+	point.NormalizedSuspensionTravelFrontLeft = r.f32()
+	point.NormalizedSuspensionTravelFrontRight = r.f32()
+	point.NormalizedSuspensionTravelRearLeft = r.f32()
+	point.NormalizedSuspensionTravelRearRight = r.f32()
+
+	point.TireSlipRatioFrontLeft = r.f32()
+	point.TireSlipRatioFrontRight = r.f32()
+	point.TireSlipRatioRearLeft = r.f32()
+	point.TireSlipRatioRearRight = r.f32()
+
+	point.WheelRotationSpeedFrontLeft = r.f32()
+	point.WheelRotationSpeedFrontRight = r.f32()
+	point.WheelRotationSpeedRearLeft = r.f32()
+	point.WheelRotationSpeedRearRight = r.f32()
+
+	point.WheelOnRumbleStripFrontLeft = r.i32()
+	point.WheelOnRumbleStripFrontRight = r.i32()
+	point.WheelOnRumbleStripRearLeft = r.i32()
+	point.WheelOnRumbleStripRearRight = r.i32()
+
+	point.WheelInPuddleDepthFrontLeft = r.f32()
+	point.WheelInPuddleDepthFrontRight = r.f32()
+	point.WheelInPuddleDepthRearLeft = r.f32()
+	point.WheelInPuddleDepthRearRight = r.f32()
+
+	point.SurfaceRumbleFrontLeft = r.f32()
+	point.SurfaceRumbleFrontRight = r.f32()
+	point.SurfaceRumbleRearLeft = r.f32()
+	point.SurfaceRumbleRearRight = r.f32()
+
+	point.TireSlipAngleFrontLeft = r.f32()
+	point.TireSlipAngleFrontRight = r.f32()
+	point.TireSlipAngleRearLeft = r.f32()
+	point.TireSlipAngleRearRight = r.f32()
+
+	point.TireCombinedSlipFrontLeft = r.f32()
+	point.TireCombinedSlipFrontRight = r.f32()
+	point.TireCombinedSlipRearLeft = r.f32()
+	point.TireCombinedSlipRearRight = r.f32()
+
+	point.SuspensionTravelMetersFrontLeft = r.f32()
+	point.SuspensionTravelMetersFrontRight = r.f32()
+	point.SuspensionTravelMetersRearLeft = r.f32()
+	point.SuspensionTravelMetersRearRight = r.f32()
+
+	point.CarOrdinal = r.i32()
+	point.CarClass = r.i32()
+	point.CarPerformanceIndex = r.i32()
+	point.DrivetrainType = r.i32()
+	point.NumCylinders = r.i32()
+
+	point.PositionX = r.f32()
+	point.PositionY = r.f32()
+	point.PositionZ = r.f32()
+
+	point.Speed = r.f32()
+	point.Power = r.f32()
+	point.Torque = r.f32()
+
+	point.TireTempFrontLeft = r.f32()
+	point.TireTempFrontRight = r.f32()
+	point.TireTempRearLeft = r.f32()
+	point.TireTempRearRight = r.f32()
+
+	point.Boost = r.f32()
+	point.Fuel = r.f32()
+	point.DistanceTraveled = r.f32()
+	point.BestLap = r.f32()
+	point.LastLap = r.f32()
+	point.CurrentLap = r.f32()
+	point.CurrentRaceTime = r.f32()
+
+	point.LapNumber = r.u16()
+
+	point.RacePosition = r.u8()
+	point.Accel = r.u8()
+	point.Brake = r.u8()
+	point.Clutch = r.u8()
+	point.HandBrake = r.u8()
+	point.Gear = r.u8()
+
+	point.Steer = r.i8()
+	point.NormalizedDrivingLine = r.i8()
+	point.NormalizedAIBrakeDifference = r.i8()
+
+	point.TireWearFrontLeft = r.f32()
+	point.TireWearFrontRight = r.f32()
+	point.TireWearRearLeft = r.f32()
+	point.TireWearRearRight = r.f32()
+
+	point.TrackOrdinal = r.i32()
+}
+
+// layoutWriter writes little endian values in order, the caller checks the length of the buffer
+type layoutWriter struct {
+	buf []byte
+	off int
+}
+
+func (w *layoutWriter) u8(v uint8) {
+	w.buf[w.off] = v
+	w.off++
+}
+
+func (w *layoutWriter) i8(v int8) {
+	w.u8(uint8(v))
+}
+
+func (w *layoutWriter) u16(v uint16) {
+	binary.LittleEndian.PutUint16(w.buf[w.off:], v)
+	w.off += 2
+}
+
+func (w *layoutWriter) u32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[w.off:], v)
+	w.off += 4
+}
+
+func (w *layoutWriter) i32(v int32) {
+	w.u32(uint32(v))
+}
+
+func (w *layoutWriter) f32(v float32) {
+	w.u32(math.Float32bits(v))
+}
+
+// encodeLayout encodes a point with the Forza Motorsport 8 layout, the reverse of decodeLayout, without allocation
+func encodeLayout(point *models.TelemetryPoint, buf []byte) {
+	w := layoutWriter{buf: buf[:FM8_PACKET_SIZE]}
+
+	w.i32(point.OnTrack)
+	w.u32(point.TimestampMS)
+
+	w.f32(point.EngineMaxRPM)
+	w.f32(point.EngineIdleRPM)
+	w.f32(point.EngineCurrentRPM)
+
+	w.f32(point.AccelarationX)
+	w.f32(point.AccelerationY)
+	w.f32(point.AccelerationZ)
+
+	w.f32(point.VelocityX)
+	w.f32(point.VelocityY)
+	w.f32(point.VelocityZ)
+
+	w.f32(point.AngularVelocityX)
+	w.f32(point.AngularVelocityY)
+	w.f32(point.AngularVelocityZ)
+
+	w.f32(point.Yaw)
+	w.f32(point.Pitch)
+	w.f32(point.Roll)
+
+	w.f32(point.NormalizedSuspensionTravelFrontLeft)
+	w.f32(point.NormalizedSuspensionTravelFrontRight)
+	w.f32(point.NormalizedSuspensionTravelRearLeft)
+	w.f32(point.NormalizedSuspensionTravelRearRight)
+
+	w.f32(point.TireSlipRatioFrontLeft)
+	w.f32(point.TireSlipRatioFrontRight)
+	w.f32(point.TireSlipRatioRearLeft)
+	w.f32(point.TireSlipRatioRearRight)
+
+	w.f32(point.WheelRotationSpeedFrontLeft)
+	w.f32(point.WheelRotationSpeedFrontRight)
+	w.f32(point.WheelRotationSpeedRearLeft)
+	w.f32(point.WheelRotationSpeedRearRight)
+
+	w.i32(point.WheelOnRumbleStripFrontLeft)
+	w.i32(point.WheelOnRumbleStripFrontRight)
+	w.i32(point.WheelOnRumbleStripRearLeft)
+	w.i32(point.WheelOnRumbleStripRearRight)
+
+	w.f32(point.WheelInPuddleDepthFrontLeft)
+	w.f32(point.WheelInPuddleDepthFrontRight)
+	w.f32(point.WheelInPuddleDepthRearLeft)
+	w.f32(point.WheelInPuddleDepthRearRight)
+
+	w.f32(point.SurfaceRumbleFrontLeft)
+	w.f32(point.SurfaceRumbleFrontRight)
+	w.f32(point.SurfaceRumbleRearLeft)
+	w.f32(point.SurfaceRumbleRearRight)
+
+	w.f32(point.TireSlipAngleFrontLeft)
+	w.f32(point.TireSlipAngleFrontRight)
+	w.f32(point.TireSlipAngleRearLeft)
+	w.f32(point.TireSlipAngleRearRight)
+
+	w.f32(point.TireCombinedSlipFrontLeft)
+	w.f32(point.TireCombinedSlipFrontRight)
+	w.f32(point.TireCombinedSlipRearLeft)
+	w.f32(point.TireCombinedSlipRearRight)
+
+	w.f32(point.SuspensionTravelMetersFrontLeft)
+	w.f32(point.SuspensionTravelMetersFrontRight)
+	w.f32(point.SuspensionTravelMetersRearLeft)
+	w.f32(point.SuspensionTravelMetersRearRight)
+
+	w.i32(point.CarOrdinal)
+	w.i32(point.CarClass)
+	w.i32(point.CarPerformanceIndex)
+	w.i32(point.DrivetrainType)
+	w.i32(point.NumCylinders)
+
+	w.f32(point.PositionX)
+	w.f32(point.PositionY)
+	w.f32(point.PositionZ)
+
+	w.f32(point.Speed)
+	w.f32(point.Power)
+	w.f32(point.Torque)
+
+	w.f32(point.TireTempFrontLeft)
+	w.f32(point.TireTempFrontRight)
+	w.f32(point.TireTempRearLeft)
+	w.f32(point.TireTempRearRight)
+
+	w.f32(point.Boost)
+	w.f32(point.Fuel)
+	w.f32(point.DistanceTraveled)
+	w.f32(point.BestLap)
+	w.f32(point.LastLap)
+	w.f32(point.CurrentLap)
+	w.f32(point.CurrentRaceTime)
+
+	w.u16(point.LapNumber)
+
+	w.u8(point.RacePosition)
+	w.u8(point.Accel)
+	w.u8(point.Brake)
+	w.u8(point.Clutch)
+	w.u8(point.HandBrake)
+	w.u8(point.Gear)
+
+	w.i8(point.Steer)
+	w.i8(point.NormalizedDrivingLine)
+	w.i8(point.NormalizedAIBrakeDifference)
+
+	w.f32(point.TireWearFrontLeft)
+	w.f32(point.TireWearFrontRight)
+	w.f32(point.TireWearRearLeft)
+	w.f32(point.TireWearRearRight)
+
+	w.i32(point.TrackOrdinal)
+}
+
+// notFiniteField returns the name of the first float field of the point that is NaN or infinite, empty when they are all finite.
+// Written field by field like decodeLayout, it's called for every packet.
+func notFiniteField(point *models.TelemetryPoint) string {
+	switch {
+	case !finite(point.EngineMaxRPM):
+		return "EngineMaxRPM"
+	case !finite(point.EngineIdleRPM):
+		return "EngineIdleRPM"
+	case !finite(point.EngineCurrentRPM):
+		return "EngineCurrentRPM"
+	case !finite(point.AccelarationX):
+		return "AccelarationX"
+	case !finite(point.AccelerationY):
+		return "AccelerationY"
+	case !finite(point.AccelerationZ):
+		return "AccelerationZ"
+	case !finite(point.VelocityX):
+		return "VelocityX"
+	case !finite(point.VelocityY):
+		return "VelocityY"
+	case !finite(point.VelocityZ):
+		return "VelocityZ"
+	case !finite(point.AngularVelocityX):
+		return "AngularVelocityX"
+	case !finite(point.AngularVelocityY):
+		return "AngularVelocityY"
+	case !finite(point.AngularVelocityZ):
+		return "AngularVelocityZ"
+	case !finite(point.Yaw):
+		return "Yaw"
+	case !finite(point.Pitch):
+		return "Pitch"
+	case !finite(point.Roll):
+		return "Roll"
+	case !finite(point.NormalizedSuspensionTravelFrontLeft):
+		return "NormalizedSuspensionTravelFrontLeft"
+	case !finite(point.NormalizedSuspensionTravelFrontRight):
+		return "NormalizedSuspensionTravelFrontRight"
+	case !finite(point.NormalizedSuspensionTravelRearLeft):
+		return "NormalizedSuspensionTravelRearLeft"
+	case !finite(point.NormalizedSuspensionTravelRearRight):
+		return "NormalizedSuspensionTravelRearRight"
+	case !finite(point.TireSlipRatioFrontLeft):
+		return "TireSlipRatioFrontLeft"
+	case !finite(point.TireSlipRatioFrontRight):
+		return "TireSlipRatioFrontRight"
+	case !finite(point.TireSlipRatioRearLeft):
+		return "TireSlipRatioRearLeft"
+	case !finite(point.TireSlipRatioRearRight):
+		return "TireSlipRatioRearRight"
+	case !finite(point.WheelRotationSpeedFrontLeft):
+		return "WheelRotationSpeedFrontLeft"
+	case !finite(point.WheelRotationSpeedFrontRight):
+		return "WheelRotationSpeedFrontRight"
+	case !finite(point.WheelRotationSpeedRearLeft):
+		return "WheelRotationSpeedRearLeft"
+	case !finite(point.WheelRotationSpeedRearRight):
+		return "WheelRotationSpeedRearRight"
+	case !finite(point.WheelInPuddleDepthFrontLeft):
+		return "WheelInPuddleDepthFrontLeft"
+	case !finite(point.WheelInPuddleDepthFrontRight):
+		return "WheelInPuddleDepthFrontRight"
+	case !finite(point.WheelInPuddleDepthRearLeft):
+		return "WheelInPuddleDepthRearLeft"
+	case !finite(point.WheelInPuddleDepthRearRight):
+		return "WheelInPuddleDepthRearRight"
+	case !finite(point.SurfaceRumbleFrontLeft):
+		return "SurfaceRumbleFrontLeft"
+	case !finite(point.SurfaceRumbleFrontRight):
+		return "SurfaceRumbleFrontRight"
+	case !finite(point.SurfaceRumbleRearLeft):
+		return "SurfaceRumbleRearLeft"
+	case !finite(point.SurfaceRumbleRearRight):
+		return "SurfaceRumbleRearRight"
+	case !finite(point.TireSlipAngleFrontLeft):
+		return "TireSlipAngleFrontLeft"
+	case !finite(point.TireSlipAngleFrontRight):
+		return "TireSlipAngleFrontRight"
+	case !finite(point.TireSlipAngleRearLeft):
+		return "TireSlipAngleRearLeft"
+	case !finite(point.TireSlipAngleRearRight):
+		return "TireSlipAngleRearRight"
+	case !finite(point.TireCombinedSlipFrontLeft):
+		return "TireCombinedSlipFrontLeft"
+	case !finite(point.TireCombinedSlipFrontRight):
+		return "TireCombinedSlipFrontRight"
+	case !finite(point.TireCombinedSlipRearLeft):
+		return "TireCombinedSlipRearLeft"
+	case !finite(point.TireCombinedSlipRearRight):
+		return "TireCombinedSlipRearRight"
+	case !finite(point.SuspensionTravelMetersFrontLeft):
+		return "SuspensionTravelMetersFrontLeft"
+	case !finite(point.SuspensionTravelMetersFrontRight):
+		return "SuspensionTravelMetersFrontRight"
+	case !finite(point.SuspensionTravelMetersRearLeft):
+		return "SuspensionTravelMetersRearLeft"
+	case !finite(point.SuspensionTravelMetersRearRight):
+		return "SuspensionTravelMetersRearRight"
+	case !finite(point.PositionX):
+		return "PositionX"
+	case !finite(point.PositionY):
+		return "PositionY"
+	case !finite(point.PositionZ):
+		return "PositionZ"
+	case !finite(point.Speed):
+		return "Speed"
+	case !finite(point.Power):
+		return "Power"
+	case !finite(point.Torque):
+		return "Torque"
+	case !finite(point.TireTempFrontLeft):
+		return "TireTempFrontLeft"
+	case !finite(point.TireTempFrontRight):
+		return "TireTempFrontRight"
+	case !finite(point.TireTempRearLeft):
+		return "TireTempRearLeft"
+	case !finite(point.TireTempRearRight):
+		return "TireTempRearRight"
+	case !finite(point.Boost):
+		return "Boost"
+	case !finite(point.Fuel):
+		return "Fuel"
+	case !finite(point.DistanceTraveled):
+		return "DistanceTraveled"
+	case !finite(point.BestLap):
+		return "BestLap"
+	case !finite(point.LastLap):
+		return "LastLap"
+	case !finite(point.CurrentLap):
+		return "CurrentLap"
+	case !finite(point.CurrentRaceTime):
+		return "CurrentRaceTime"
+	case !finite(point.TireWearFrontLeft):
+		return "TireWearFrontLeft"
+	case !finite(point.TireWearFrontRight):
+		return "TireWearFrontRight"
+	case !finite(point.TireWearRearLeft):
+		return "TireWearRearLeft"
+	case !finite(point.TireWearRearRight):
+		return "TireWearRearRight"
+	}
+	return ""
+}
+
+func finite(f float32) bool {
+	return !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0)
+}
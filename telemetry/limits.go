@@ -0,0 +1,115 @@
+package telemetry
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Reasons a packet is rejected by the limits of the server
+const (
+	REASON_DENIED        = "denied"
+	REASON_SESSION_LIMIT = "sessionLimit"
+	REASON_RATE_LIMIT    = "rateLimit"
+)
+
+// Limits protects the server from unwanted or excessive traffic. Zero values disable a limit.
+type Limits struct {
+	Allow []netip.Prefix // only these sources are accepted, every source when empty
+	Deny  []netip.Prefix // sources rejected, even when allowed
+
+	MaxSessions int     // concurrent sessions, packets of new sources are rejected above it
+	PacketRate  float64 // packets per second of a source
+	PacketBurst int     // packets a source can send above the rate at once, defaults to the rate
+}
+
+// ParsePrefixes parses a comma separated list of CIDR prefixes or IPs, like "10.0.0.0/8,192.168.1.10"
+func ParsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed checks the source against the allow and deny lists
+func (l Limits) allowed(addr net.Addr) bool {
+	if len(l.Allow) == 0 && len(l.Deny) == 0 {
+		return true
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	ip := udpAddr.AddrPort().Addr().Unmap()
+	if containsAddr(l.Deny, ip) {
+		return false
+	}
+	return len(l.Allow) == 0 || containsAddr(l.Allow, ip)
+}
+
+// Rate limiters of sources without a session kept before the full ones are removed
+const PENDING_LIMITERS = 1024
+
+func (l Limits) newRateLimiter() *rateLimiter {
+	if l.PacketRate <= 0 {
+		return nil
+	}
+	burst := float64(l.PacketBurst)
+	if burst <= 0 {
+		burst = l.PacketRate
+	}
+	return &rateLimiter{rate: l.PacketRate, burst: burst, tokens: burst}
+}
+
+// rateLimiter is a token bucket. It's only used by the goroutine reading the datagrams, it isn't safe for concurrent use.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// full tells if the bucket is full, the limiter is then the same as a new one
+func (r *rateLimiter) full(now time.Time) bool {
+	return r.last.IsZero() || r.tokens+now.Sub(r.last).Seconds()*r.rate >= r.burst
+}
+
+func (r *rateLimiter) allow(now time.Time) bool {
+	if !r.last.IsZero() {
+		r.tokens = min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	}
+	r.last = now
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
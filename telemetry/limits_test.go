@@ -0,0 +1,117 @@
+package telemetry_test
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := telemetry.ParsePrefixes("10.1.2.3/8, 192.168.1.10,,::1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.10/32"),
+		netip.MustParsePrefix("::1/128"),
+	}
+	if len(prefixes) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, prefixes)
+	}
+	for i := range expected {
+		if prefixes[i] != expected[i] {
+			t.Errorf("expected %v got %v", expected[i], prefixes[i])
+		}
+	}
+
+	for _, value := range []string{"a", "10.0.0.0/33", "10.0.0"} {
+		_, err = telemetry.ParsePrefixes(value)
+		if err == nil {
+			t.Errorf("%s: expected error got nil", value)
+		}
+	}
+}
+
+// rejectionCount returns the count of packets rejected for reason
+func rejectionCount(server *telemetry.Server, reason string) uint64 {
+	var count uint64
+	for _, rejection := range server.Rejections() {
+		if rejection.Reason == reason {
+			count += rejection.Count
+		}
+	}
+	return count
+}
+
+func sendPoints(t *testing.T, con net.Conn, count int) {
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1)
+	for range count {
+		err := binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestServerDenied(t *testing.T) {
+	for name, limits := range map[string]telemetry.Limits{
+		"denied":      {Deny: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}},
+		"not allowed": {Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := telemetry.NewServer("127.0.0.1:0", nil, time.Second)
+			server.SetLimits(limits)
+			con := startServer(t, server)
+			defer shutdownServer(t, server)
+
+			sendPoints(t, con, 2)
+			if len(server.Listeners()) != 0 {
+				t.Errorf("expected 0 got %v", len(server.Listeners()))
+			}
+			if count := rejectionCount(server, telemetry.REASON_DENIED); count != 2 {
+				t.Errorf("expected 2 got %v", count)
+			}
+		})
+	}
+}
+
+func TestServerSessionLimit(t *testing.T) {
+	server := telemetry.NewServer("127.0.0.1:0", nil, time.Second)
+	server.SetLimits(telemetry.Limits{Allow: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, MaxSessions: 1})
+	con := startServer(t, server)
+	defer shutdownServer(t, server)
+
+	other, err := net.Dial("udp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	sendPoints(t, con, 1)
+	sendPoints(t, other, 1)
+	if len(server.Listeners()) != 1 || server.Listeners()[0] != con.LocalAddr().String() {
+		t.Errorf("expected %v got %v", con.LocalAddr(), server.Listeners())
+	}
+	if count := rejectionCount(server, telemetry.REASON_SESSION_LIMIT); count != 1 {
+		t.Errorf("expected 1 got %v", count)
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	server := telemetry.NewServer("127.0.0.1:0", nil, time.Second)
+	server.SetLimits(telemetry.Limits{PacketRate: 1, PacketBurst: 3})
+	con := startServer(t, server)
+	defer shutdownServer(t, server)
+
+	// The first packet, creating the session, is counted in the burst
+	sendPoints(t, con, 10)
+	if count := rejectionCount(server, telemetry.REASON_RATE_LIMIT); count != 7 {
+		t.Errorf("expected 7 got %v", count)
+	}
+}
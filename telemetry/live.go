@@ -0,0 +1,130 @@
+package telemetry
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+)
+
+// Points waiting to be read by a live subscriber, newer points are dropped when full
+const LIVE_BUFFER = 120
+
+// Races ended are remembered this long, longer than the race takes to be saved as ended
+const LIVE_ENDED_TTL = 10 * time.Minute
+
+// LiveHub broadcasts the points of races as they are received, before they are checkpointed
+type LiveHub struct {
+	m           sync.RWMutex
+	subscribers map[uuid.UUID]map[*LiveSubscription]struct{}
+	// Races recently ended, subscribing to them returns a closed subscription.
+	// The database can still show them in progress until they are saved.
+	ended map[uuid.UUID]time.Time
+}
+
+type LiveSubscription struct {
+	Race uuid.UUID
+	// Closed when the race ends
+	C <-chan models.Point
+
+	c       chan models.Point
+	dropped atomic.Uint64
+}
+
+func NewLiveHub() *LiveHub {
+	return &LiveHub{
+		subscribers: make(map[uuid.UUID]map[*LiveSubscription]struct{}),
+		ended:       make(map[uuid.UUID]time.Time),
+	}
+}
+
+// Subscribe returns a subscription to the points of the race, already closed when the race ended recently
+func (h *LiveHub) Subscribe(race uuid.UUID) *LiveSubscription {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	c := make(chan models.Point, LIVE_BUFFER)
+	sub := &LiveSubscription{Race: race, C: c, c: c}
+	if endedAt, ok := h.ended[race]; ok && time.Since(endedAt) < LIVE_ENDED_TTL {
+		close(c)
+		return sub
+	}
+	if _, ok := h.subscribers[race]; !ok {
+		h.subscribers[race] = make(map[*LiveSubscription]struct{})
+	}
+	h.subscribers[race][sub] = struct{}{}
+	return sub
+}
+
+func (h *LiveHub) Unsubscribe(sub *LiveSubscription) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	subs, ok := h.subscribers[sub.Race]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subscribers, sub.Race)
+	}
+	close(sub.c)
+}
+
+// Subscribers returns the count of subscribers of a race
+func (h *LiveHub) Subscribers(race uuid.UUID) int {
+	h.m.RLock()
+	defer h.m.RUnlock()
+	return len(h.subscribers[race])
+}
+
+// Publish sends a point to the subscribers of its race without blocking, slow subscribers miss points.
+// A point of a race ended, resumed since, makes it live again.
+func (h *LiveHub) Publish(point models.Point) {
+	h.m.RLock()
+	_, ended := h.ended[point.Race]
+	for sub := range h.subscribers[point.Race] {
+		select {
+		case sub.c <- point:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+	h.m.RUnlock()
+
+	if ended {
+		h.m.Lock()
+		delete(h.ended, point.Race)
+		h.m.Unlock()
+	}
+}
+
+// End closes the subscriptions of a race, the race is remembered as ended for LIVE_ENDED_TTL
+func (h *LiveHub) End(race uuid.UUID) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for sub := range h.subscribers[race] {
+		close(sub.c)
+	}
+	delete(h.subscribers, race)
+
+	now := time.Now()
+	for id, endedAt := range h.ended {
+		if now.Sub(endedAt) >= LIVE_ENDED_TTL {
+			delete(h.ended, id)
+		}
+	}
+	h.ended[race] = now
+}
+
+// Dropped returns the count of points the subscriber missed because it was too slow
+func (s *LiveSubscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
@@ -0,0 +1,61 @@
+package telemetry_test
+
+import (
+	"testing"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+)
+
+func TestLiveHub(t *testing.T) {
+	hub := telemetry.NewLiveHub()
+	race := testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b")
+
+	sub := hub.Subscribe(race)
+	slow := hub.Subscribe(race)
+	other := hub.Subscribe(testutils.ParseUUID("a4fff587-4394-46be-baed-da3c178862a9"))
+
+	for range telemetry.LIVE_BUFFER + 1 {
+		hub.Publish(models.Point{Race: race})
+		<-sub.C
+	}
+	if sub.Dropped() != 0 {
+		t.Errorf("expected 0 got %v", sub.Dropped())
+	}
+	if slow.Dropped() != 1 {
+		t.Errorf("expected 1 got %v", slow.Dropped())
+	}
+	if len(other.C) != 0 {
+		t.Errorf("expected 0 got %v", len(other.C))
+	}
+
+	hub.Unsubscribe(slow)
+	if hub.Subscribers(race) != 1 {
+		t.Errorf("expected 1 got %v", hub.Subscribers(race))
+	}
+
+	hub.End(race)
+	if _, ok := <-sub.C; ok {
+		t.Errorf("expected closed subscription")
+	}
+	// unsubscribing an ended subscription is a no-op
+	hub.Unsubscribe(sub)
+	hub.Unsubscribe(other)
+
+	// subscribing after the end, the race still in progress in the database, returns a closed subscription
+	late := hub.Subscribe(race)
+	if _, ok := <-late.C; ok {
+		t.Errorf("expected closed subscription")
+	}
+	hub.Unsubscribe(late)
+
+	// a point of the race resumed makes it live again
+	hub.Publish(models.Point{Race: race})
+	resumed := hub.Subscribe(race)
+	hub.Publish(models.Point{Race: race})
+	if len(resumed.C) != 1 {
+		t.Errorf("expected 1 got %v", len(resumed.C))
+	}
+	hub.Unsubscribe(resumed)
+}
@@ -0,0 +1,30 @@
+package telemetry
+
+import (
+	"forzatelemetry/metrics"
+)
+
+// Reasons a valid packet is dropped, in addition to the reasons a packet is rejected
+const (
+	REASON_ZERO_TIMESTAMP = "zeroTimestamp"
+	REASON_CHANNEL_FULL   = "channelFull"
+)
+
+var (
+	packetsReceived = metrics.NewCounter(metrics.Default, "forzatelemetry_packets_received_total", "Datagrams received by the telemetry server.")
+	packetsDecoded  = metrics.NewCounter(metrics.Default, "forzatelemetry_packets_decoded_total", "Packets decoded and validated, per packet format.", "format")
+	packetsDropped  = metrics.NewCounter(metrics.Default, "forzatelemetry_packets_dropped_total", "Packets dropped, per reason.", "reason")
+	sessionsActive  = metrics.NewGauge(metrics.Default, "forzatelemetry_sessions_active", "Sources with an active session.")
+	raceBoundaries  = metrics.NewCounter(metrics.Default, "forzatelemetry_race_boundaries_total", "Races ended by a segmentation rule, per rule.", "rule")
+
+	checkpointPoints   = metrics.NewHistogram(metrics.Default, "forzatelemetry_checkpoint_points", "Points saved per session checkpoint.", []float64{1, 10, 50, 100, 200, 300, 400})
+	checkpointDuration = metrics.NewHistogram(metrics.Default, "forzatelemetry_checkpoint_duration_seconds", "Duration of session checkpoints.", metrics.DURATION_BUCKETS)
+
+	batchPending  = metrics.NewGauge(metrics.Default, "forzatelemetry_batch_pending_points", "Points waiting to be written or being written by the batch writer.")
+	batchPoints   = metrics.NewHistogram(metrics.Default, "forzatelemetry_batch_points", "Points written per batch.", []float64{10, 100, 500, 1000, 2000, 5000, 10000, 50000})
+	batchDuration = metrics.NewHistogram(metrics.Default, "forzatelemetry_batch_duration_seconds", "Duration of batch writes, retries included.", metrics.DURATION_BUCKETS)
+	batchRetries  = metrics.NewCounter(metrics.Default, "forzatelemetry_batch_retries_total", "Failed batch writes attempted again.")
+	batchDropped  = metrics.NewCounter(metrics.Default, "forzatelemetry_batch_dropped_points_total", "Points dropped by the batch writer, per reason.", "reason")
+
+	walRemoveFailed = metrics.NewCounter(metrics.Default, "forzatelemetry_wal_remove_failed_total", "WAL segments of saved points that couldn't be deleted.")
+)
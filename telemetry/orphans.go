@@ -0,0 +1,85 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/metrics"
+	"forzatelemetry/models"
+)
+
+// Races in progress without points for that long, and not part of an active session, are ended
+const ORPHAN_RACE_TIMEOUT = 10 * time.Minute
+const ORPHAN_CHECK_INTERVAL = 10 * time.Minute
+
+var orphanedRaces = metrics.NewCounter(metrics.Default, "forzatelemetry_orphaned_races_total", "Races left in progress by a crash or a restart, then ended.")
+
+// RecoverOrphanedRaces ends the races left in progress without points since before, with their last point.
+// The races of the active sessions are left untouched. Returns the races ended.
+func (s *Server) RecoverOrphanedRaces(before time.Time, ctx context.Context) ([]models.Race, error) {
+	if s.store == nil {
+		return nil, nil
+	}
+	db := s.store.db
+
+	candidates, err := db.SelectOrphanedRaces(before, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed selecting orphaned races: %w", err)
+	}
+
+	active := make(map[uuid.UUID]bool)
+	for _, session := range s.Sessions() {
+		active[session.Race] = true
+	}
+
+	var recovered []models.Race
+	for _, race := range candidates {
+		if active[race.ID] {
+			continue
+		}
+
+		point, err := db.SelectLastPoint(race.ID.String(), ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			race.InProgress = false
+			race.FinishedAt = race.StartedAt
+		} else if err != nil {
+			return recovered, fmt.Errorf("failed reading last point of race %s: %w", race.ID, err)
+		} else {
+			race = race.End(point)
+		}
+
+		err = db.UpsertRaces(ctx, race)
+		if err != nil {
+			return recovered, fmt.Errorf("failed ending orphaned race %s: %w", race.ID, err)
+		}
+		orphanedRaces.Inc()
+		slog.Warn("ended orphaned race", "race", race.ID, "session", race.SessionID, "startedAt", race.StartedAt, "finishedAt", race.FinishedAt)
+		recovered = append(recovered, race)
+	}
+	return recovered, nil
+}
+
+// recoverOrphanedRaces checks for orphaned races every ORPHAN_CHECK_INTERVAL until done is closed
+func (s *Server) recoverOrphanedRaces(done chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(ORPHAN_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, err := s.RecoverOrphanedRaces(time.Now().Add(-ORPHAN_RACE_TIMEOUT), context.Background())
+			if err != nil {
+				slog.Error("failed recovering orphaned races", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package telemetry_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+)
+
+func TestRecoverOrphanedRacesOnStart(t *testing.T) {
+	store := testutils.NewStore()
+	defer store.Close()
+
+	now := time.Now()
+	races := []models.Race{
+		{ID: testutils.ParseUUID("5b0c0e3e-4b8f-4a51-9f45-6e6f1b0d2a01"), InProgress: true, StartedAt: now.Add(-time.Hour)},
+		{ID: testutils.ParseUUID("5b0c0e3e-4b8f-4a51-9f45-6e6f1b0d2a02"), InProgress: true, StartedAt: now.Add(-time.Hour)},
+	}
+	err := store.UpsertRaces(context.Background(), races...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	last := now.Add(-30 * time.Minute).Truncate(time.Millisecond)
+	err = store.InsertPoints([]models.Point{
+		{Race: races[0].ID, CreatedAt: now.Add(-time.Hour), TelemetryPoint: models.TelemetryPoint{CurrentRaceTime: 1}},
+		{Race: races[0].ID, CreatedAt: last, TelemetryPoint: models.TelemetryPoint{CurrentRaceTime: 1800, RacePosition: 3}},
+	}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	server := telemetry.NewServer("127.0.0.1:0", store, time.Hour)
+	startServer(t, server)
+	defer shutdownServer(t, server)
+
+	ended, _, err := store.SelectRaces(nil, 0, context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, race := range ended {
+		if race.InProgress {
+			t.Errorf("expected race %v to be ended", race.ID)
+		}
+		if race.ID == races[0].ID {
+			if !race.FinishedAt.Equal(last) || race.RaceTime != 1800 || race.Position != 3 {
+				t.Errorf("expected the race ended with its last point got %v", race.Race)
+			}
+		} else if !race.FinishedAt.Equal(race.StartedAt) {
+			t.Errorf("expected %v got %v", race.StartedAt, race.FinishedAt)
+		}
+	}
+}
+
+func TestRecoverOrphanedRacesActiveSession(t *testing.T) {
+	store := testutils.NewStore()
+	defer store.Close()
+
+	server := telemetry.NewServer("127.0.0.1:0", store, time.Hour)
+	con := startServer(t, server)
+	defer shutdownServer(t, server)
+
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1)
+	err := binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	recovered, err := server.RecoverOrphanedRaces(time.Now().Add(time.Hour), context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(recovered) != 0 {
+		t.Errorf("expected 0 got %v", recovered)
+	}
+}
@@ -0,0 +1,134 @@
+package telemetry
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+)
+
+// SessionInfo describes the session of an active telemetry source
+type SessionInfo struct {
+	Source            string        `json:"source"`
+	Session           uuid.UUID     `json:"session"`
+	Race              uuid.UUID     `json:"race"`
+	Game              models.Game   `json:"game"`
+	Format            string        `json:"format"`
+	StartedAt         time.Time     `json:"startedAt"`
+	Received          uint64        `json:"received"`
+	Dropped           uint64        `json:"dropped"` // packets dropped because the session channel was full
+	LastPacketAt      time.Time     `json:"lastPacketAt"`
+	CheckpointLatency time.Duration `json:"checkpointLatency"` // duration of the last checkpoint
+}
+
+// listener is the registry entry of an active source, processed by its own goroutine
+type listener struct {
+	source    string
+	startedAt time.Time
+	c         chan Packet
+	cOnce     sync.Once    // the idle timeout and the shutdown may both close c
+	limiter   *rateLimiter // optional, only used by the goroutine reading the datagrams
+
+	// Closed to force the session to close
+	kill     chan struct{}
+	killOnce sync.Once
+
+	received     atomic.Uint64
+	dropped      atomic.Uint64
+	lastPacketAt atomic.Int64
+
+	m                 sync.Mutex
+	session           uuid.UUID
+	race              uuid.UUID
+	game              models.Game
+	format            string
+	checkpointLatency time.Duration
+}
+
+func newListener(source string) *listener {
+	return &listener{
+		source:    source,
+		startedAt: time.Now(),
+		c:         make(chan Packet, SESSION_CHANNEL_SIZE),
+		kill:      make(chan struct{}),
+	}
+}
+
+func (l *listener) update(session *Session) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.session = session.ID
+	l.race = session.RaceID()
+	l.game = session.Format.Game
+	l.format = session.Format.Version
+}
+
+func (l *listener) checkpointed(latency time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.checkpointLatency = latency
+}
+
+func (l *listener) close() {
+	l.killOnce.Do(func() { close(l.kill) })
+}
+
+// stop closes the packets channel, the session is closed once the remaining packets are processed
+func (l *listener) stop() {
+	l.cOnce.Do(func() { close(l.c) })
+}
+
+func (l *listener) info() SessionInfo {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	info := SessionInfo{
+		Source:            l.source,
+		Session:           l.session,
+		Race:              l.race,
+		Game:              l.game,
+		Format:            l.format,
+		StartedAt:         l.startedAt,
+		Received:          l.received.Load(),
+		Dropped:           l.dropped.Load(),
+		CheckpointLatency: l.checkpointLatency,
+	}
+	if last := l.lastPacketAt.Load(); last != 0 {
+		info.LastPacketAt = time.Unix(0, last)
+	}
+	return info
+}
+
+// Sessions returns the sessions of the active sources, ordered by source
+func (s *Server) Sessions() []SessionInfo {
+	sessions := []SessionInfo{}
+	s.listeners.Range(func(k any, v any) bool {
+		sessions = append(sessions, v.(*listener).info())
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Source < sessions[j].Source })
+	return sessions
+}
+
+// CloseSession forces a session to close, the next packet of its source starts a new session.
+// Returns false if the session isn't active.
+func (s *Server) CloseSession(id uuid.UUID) bool {
+	closed := false
+	s.listeners.Range(func(k any, v any) bool {
+		l := v.(*listener)
+		l.m.Lock()
+		match := l.session == id
+		l.m.Unlock()
+		if match {
+			l.close()
+			closed = true
+			return false
+		}
+		return true
+	})
+	return closed
+}
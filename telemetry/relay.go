@@ -0,0 +1,130 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Datagrams waiting to be sent to a destination, newer datagrams are dropped when full
+const RELAY_BUFFER = 256
+
+type RelayDestination struct {
+	Addr    string   // UDP address to forward to
+	Sources []string // IP or IP:port to forward, every source when empty
+}
+
+type RelayStats struct {
+	Addr    string `json:"addr"`
+	Sent    uint64 `json:"sent"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// Relay forwards raw datagrams, unchanged, to other UDP destinations.
+// Each destination is written to by its own goroutine so a slow destination never blocks the server.
+type Relay struct {
+	wg           sync.WaitGroup
+	destinations []*relayDestination
+}
+
+type relayDestination struct {
+	addr    string
+	sources map[string]bool
+	conn    net.Conn
+	c       chan []byte
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func NewRelay(destinations []RelayDestination) (*Relay, error) {
+	relay := &Relay{}
+	for _, destination := range destinations {
+		conn, err := net.Dial("udp", destination.Addr)
+		if err != nil {
+			relay.Close()
+			return nil, fmt.Errorf("failed connecting to relay destination %s: %w", destination.Addr, err)
+		}
+
+		d := &relayDestination{
+			addr:    destination.Addr,
+			sources: make(map[string]bool),
+			conn:    conn,
+			c:       make(chan []byte, RELAY_BUFFER),
+		}
+		for _, source := range destination.Sources {
+			d.sources[source] = true
+		}
+		relay.destinations = append(relay.destinations, d)
+
+		relay.wg.Add(1)
+		go relay.send(d)
+	}
+	return relay, nil
+}
+
+func (d *relayDestination) accepts(source string) bool {
+	if len(d.sources) == 0 || d.sources[source] {
+		return true
+	}
+	host, _, err := net.SplitHostPort(source)
+	return err == nil && d.sources[host]
+}
+
+func (r *Relay) send(d *relayDestination) {
+	defer r.wg.Done()
+	for data := range d.c {
+		_, err := d.conn.Write(data)
+		if err != nil {
+			slog.Debug("failed relaying datagram", "error", err, "destination", d.addr)
+			continue
+		}
+		d.sent.Add(1)
+	}
+}
+
+// Forward queues a datagram for the destinations accepting its source, without blocking
+func (r *Relay) Forward(source string, data []byte) {
+	var datagram []byte
+	for _, d := range r.destinations {
+		if !d.accepts(source) {
+			continue
+		}
+		// The read buffer is reused by the server, copy once and share it between destinations
+		if datagram == nil {
+			datagram = make([]byte, len(data))
+			copy(datagram, data)
+		}
+		select {
+		case d.c <- datagram:
+		default:
+			d.dropped.Add(1)
+		}
+	}
+}
+
+// Stats returns the count of datagrams sent and dropped per destination
+func (r *Relay) Stats() []RelayStats {
+	stats := make([]RelayStats, 0, len(r.destinations))
+	for _, d := range r.destinations {
+		stats = append(stats, RelayStats{Addr: d.addr, Sent: d.sent.Load(), Dropped: d.dropped.Load()})
+	}
+	return stats
+}
+
+// Close stops forwarding once the queued datagrams are sent. Forward must not be called after Close.
+func (r *Relay) Close() error {
+	for _, d := range r.destinations {
+		close(d.c)
+	}
+	r.wg.Wait()
+
+	var errs []error
+	for _, d := range r.destinations {
+		errs = append(errs, d.conn.Close())
+	}
+	return errors.Join(errs...)
+}
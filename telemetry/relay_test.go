@@ -0,0 +1,98 @@
+package telemetry_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"forzatelemetry/telemetry"
+)
+
+func listenUDP(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readDatagrams(t *testing.T, conn net.PacketConn) []string {
+	var datagrams []string
+	buf := make([]byte, telemetry.MAX_PACKET_SIZE)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return datagrams
+		}
+		datagrams = append(datagrams, string(buf[:n]))
+	}
+}
+
+func TestRelay(t *testing.T) {
+	all := listenUDP(t)
+	filtered := listenUDP(t)
+
+	relay, err := telemetry.NewRelay([]telemetry.RelayDestination{
+		{Addr: all.LocalAddr().String()},
+		{Addr: filtered.LocalAddr().String(), Sources: []string{"10.0.0.1", "10.0.0.2:1000"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	buf := []byte("10.0.0.1:1000")
+	relay.Forward("10.0.0.1:1000", buf)
+	// the buffer is reused by the server
+	copy(buf, "xxxxxxxxxxxxx")
+	relay.Forward("10.0.0.2:1000", []byte("10.0.0.2:1000"))
+	relay.Forward("10.0.0.2:2000", []byte("10.0.0.2:2000"))
+
+	err = relay.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	datagrams := readDatagrams(t, all)
+	if len(datagrams) != 3 || datagrams[0] != "10.0.0.1:1000" {
+		t.Errorf("expected 3 datagrams got %v", datagrams)
+	}
+	datagrams = readDatagrams(t, filtered)
+	if len(datagrams) != 2 || datagrams[0] != "10.0.0.1:1000" || datagrams[1] != "10.0.0.2:1000" {
+		t.Errorf("expected 2 datagrams got %v", datagrams)
+	}
+
+	stats := relay.Stats()
+	if stats[0].Sent != 3 || stats[1].Sent != 2 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+}
+
+func TestRelayFull(t *testing.T) {
+	relay, err := telemetry.NewRelay([]telemetry.RelayDestination{{Addr: listenUDP(t).LocalAddr().String()}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Forwarding never blocks, datagrams over the buffer are dropped
+	for range 100 * telemetry.RELAY_BUFFER {
+		relay.Forward("10.0.0.1:1000", []byte("data"))
+	}
+	err = relay.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	stats := relay.Stats()
+	if stats[0].Sent+stats[0].Dropped != 100*telemetry.RELAY_BUFFER {
+		t.Errorf("expected %v got %v", 100*telemetry.RELAY_BUFFER, stats[0].Sent+stats[0].Dropped)
+	}
+}
+
+func TestRelayInvalidDestination(t *testing.T) {
+	_, err := telemetry.NewRelay([]telemetry.RelayDestination{{Addr: "invalid"}})
+	if err == nil {
+		t.Errorf("expected error got nil")
+	}
+}
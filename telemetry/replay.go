@@ -0,0 +1,53 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// Replay sends the datagrams of a capture to w, usually a UDP connection.
+// The gaps between datagrams are kept and divided by speed, a speed of 0 sends them as fast as possible.
+// Returns the number of datagrams sent.
+func Replay(ctx context.Context, reader *CaptureReader, w io.Writer, speed float64) (int, error) {
+	var first time.Time
+	start := time.Now()
+	sent := 0
+
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return sent, nil
+		} else if err != nil {
+			return sent, err
+		}
+
+		if speed > 0 {
+			if first.IsZero() {
+				first = record.At
+			}
+			// Schedule from the start of the replay so the delays don't drift
+			wait := time.Until(start.Add(time.Duration(float64(record.At.Sub(first)) / speed)))
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return sent, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+
+		_, err = w.Write(record.Data)
+		if err != nil {
+			return sent, err
+		}
+		sent++
+	}
+}
@@ -0,0 +1,116 @@
+package telemetry_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"forzatelemetry/telemetry"
+)
+
+type recordingWriter struct {
+	data [][]byte
+	at   []time.Time
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.data = append(w.data, append([]byte(nil), p...))
+	w.at = append(w.at, time.Now())
+	return len(p), nil
+}
+
+func openCapture(t *testing.T, gaps ...time.Duration) *telemetry.CaptureReader {
+	dir := t.TempDir()
+	capture, err := telemetry.NewCapture(telemetry.CaptureConfig{Dir: dir, All: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	at := time.UnixMilli(1725479276147)
+	err = capture.Write("10.0.0.1:1000", at, []byte{0})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i, gap := range gaps {
+		at = at.Add(gap)
+		err = capture.Write("10.0.0.1:1000", at, []byte{byte(i + 1)})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	err = capture.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	f, err := os.Open(captureFiles(t, dir)[0])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	reader, err := telemetry.NewCaptureReader(f)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return reader
+}
+
+func TestReplayFast(t *testing.T) {
+	reader := openCapture(t, time.Hour, time.Hour)
+
+	w := &recordingWriter{}
+	sent, err := telemetry.Replay(context.Background(), reader, w, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if sent != 3 {
+		t.Errorf("expected 3 got %v", sent)
+	}
+	for i, data := range w.data {
+		if len(data) != 1 || data[0] != byte(i) {
+			t.Errorf("expected [%d] got %v", i, data)
+		}
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	reader := openCapture(t, 100*time.Millisecond, 300*time.Millisecond)
+
+	w := &recordingWriter{}
+	_, err := telemetry.Replay(context.Background(), reader, w, 2)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(w.at) != 3 {
+		t.Fatalf("expected 3 got %v", len(w.at))
+	}
+
+	// Delays are scheduled from the start of the replay, a late datagram doesn't delay the next ones
+	gap := w.at[1].Sub(w.at[0])
+	if gap < 50*time.Millisecond || gap > 100*time.Millisecond {
+		t.Errorf("expected a gap of 50ms got %v", gap)
+	}
+	gap = w.at[2].Sub(w.at[0])
+	if gap < 200*time.Millisecond || gap > 250*time.Millisecond {
+		t.Errorf("expected a gap of 200ms got %v", gap)
+	}
+}
+
+func TestReplayCancel(t *testing.T) {
+	reader := openCapture(t, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	w := &recordingWriter{}
+	sent, err := telemetry.Replay(ctx, reader, w, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v got %v", context.DeadlineExceeded, err)
+	}
+	if sent != 1 {
+		t.Errorf("expected 1 got %v", sent)
+	}
+}
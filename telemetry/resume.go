@@ -0,0 +1,107 @@
+package telemetry
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"forzatelemetry/models"
+)
+
+// Race time may run a bit ahead of the wall clock between the last point before the drop and the first one after
+const RESUME_RACE_TIME_TOLERANCE = time.Second
+
+// closedRace is the race of a session closed while in progress, it can be resumed by the next session of the source
+type closedRace struct {
+	race     models.Race
+	last     models.Point
+	format   string
+	closedAt time.Time
+}
+
+// closedRaces keeps the last race of the recently closed sessions, per source address
+type closedRaces struct {
+	m     sync.Mutex
+	races map[string]closedRace
+}
+
+func (c *closedRaces) add(source string, race closedRace, gracePeriod time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.races == nil {
+		c.races = make(map[string]closedRace)
+	}
+	for key, closed := range c.races {
+		if time.Since(closed.closedAt) > gracePeriod {
+			delete(c.races, key)
+		}
+	}
+	c.races[source] = race
+}
+
+// take returns the race closed by the source if the packet continues it, within the grace period.
+// It's called with the first packet on track of the next session, the race stays until then or until the grace period ends.
+func (c *closedRaces) take(source string, packet Packet, gracePeriod time.Duration) (closedRace, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	closed, ok := c.races[source]
+	if !ok || packet.OnTrack == 0 {
+		return closedRace{}, false
+	}
+	delete(c.races, source)
+
+	elapsed := time.Since(closed.closedAt)
+	if elapsed > gracePeriod || closed.format != packet.Format.Version {
+		return closedRace{}, false
+	}
+	if packet.CarOrdinal != closed.race.Car || packet.TrackOrdinal != closed.race.Track {
+		return closedRace{}, false
+	}
+	if packet.LapNumber < closed.last.LapNumber {
+		return closedRace{}, false
+	}
+
+	// The race time carries on, at most by the time elapsed since the last point
+	delta := float64(packet.CurrentRaceTime - closed.last.CurrentRaceTime)
+	maxDelta := time.Since(closed.last.CreatedAt) + RESUME_RACE_TIME_TOLERANCE
+	if delta < 0 || delta > maxDelta.Seconds() {
+		return closedRace{}, false
+	}
+	return closed, true
+}
+
+// closeSession closes a session, its race can be resumed during the grace period
+func (s *Server) closeSession(key string, session *Session) {
+	err := session.Close()
+	if err != nil {
+		slog.Error("failed closing session", "error", err, "session", session.ID)
+	}
+	err = session.publishSession(time.Now())
+	if err != nil {
+		slog.Error("failed closing session", "error", err, "session", session.ID)
+	}
+
+	if s.resumeGracePeriod <= 0 || session.RaceID() == EMPTY_UUID || session.last.CreatedAt.IsZero() {
+		return
+	}
+	s.closedRaces.add(key, closedRace{
+		race:     session.race,
+		last:     session.last,
+		format:   session.Format.Version,
+		closedAt: time.Now(),
+	}, s.resumeGracePeriod)
+}
+
+// resume re-attaches the session to a race closed by a previous session of the same source, the race moves to the session
+func (s *Session) resume(race models.Race, last models.Point) error {
+	race.SessionID = s.ID
+	race.InProgress = true
+	race.Paused = false
+	race.FinishedAt = time.Time{}
+	s.race = race
+	s.last = last
+	slog.Info("resuming race", "race", race.ID, "session", s.ID)
+	return s.publishRace(RACE_RESUMED)
+}
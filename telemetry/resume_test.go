@@ -0,0 +1,88 @@
+package telemetry_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+)
+
+func TestServerResumeRace(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		car      int32
+		raceTime float32
+		offTrack int // packets off track, in the menus, before the race continues
+		resumed  bool
+	}{
+		{"continuous race time", 1, 0.2, 0, true},
+		{"starting off track", 1, 0.2, 3, true},
+		{"other car", 2, 0.2, 0, false},
+		{"race time going backward", 1, -1, 0, false},
+		{"race time jumping forward", 1, 60, 0, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sink := &telemetry.MemorySink{}
+			flushInterval := 50 * time.Millisecond
+			server := telemetry.NewServer("127.0.0.1:0", nil, flushInterval)
+			server.AddSink(sink)
+			server.SetResumeGracePeriod(time.Minute)
+			con := startServer(t, server)
+
+			point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1)
+			point.CurrentRaceTime = 10
+			err := binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			// Wait for the session to be closed by the idle timeout
+			time.Sleep(20 * time.Millisecond)
+			for len(server.Listeners()) != 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			offTrack := point
+			offTrack.OnTrack = 0
+			for range test.offTrack {
+				err = binary.Write(con, binary.LittleEndian, offTrack.TelemetryPoint)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+			}
+
+			point.CarOrdinal = test.car
+			point.CurrentRaceTime += test.raceTime
+			err = binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			time.Sleep(20 * time.Millisecond)
+			shutdownServer(t, server)
+
+			races := map[string]bool{}
+			resumed := false
+			for _, event := range sink.Events() {
+				races[event.Race.ID.String()] = true
+				if event.Type == telemetry.RACE_RESUMED {
+					resumed = true
+					// the race moves to the session resuming it
+					sessions := sink.Sessions()
+					if last := sessions[len(sessions)-1]; event.Race.SessionID != last.ID {
+						t.Errorf("expected %v got %v", last.ID, event.Race.SessionID)
+					}
+				}
+			}
+			if resumed != test.resumed {
+				t.Errorf("expected resumed %v got %v", test.resumed, resumed)
+			}
+			if test.resumed && len(races) != 1 {
+				t.Errorf("expected 1 race got %v", len(races))
+			} else if !test.resumed && len(races) != 2 {
+				t.Errorf("expected 2 races got %v", len(races))
+			}
+		})
+	}
+}
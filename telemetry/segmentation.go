@@ -0,0 +1,99 @@
+package telemetry
+
+import (
+	"fmt"
+	"strings"
+
+	"forzatelemetry/models"
+)
+
+// Names of the segmentation rules, see SEGMENTATION_RULES
+const (
+	RULE_RACE_RESTART    = "raceRestart"
+	RULE_RACE_TIME_RESET = "raceTimeReset"
+	RULE_CAR             = "car"
+	RULE_TRACK           = "track"
+	RULE_PERFORMANCE     = "performanceIndex"
+	RULE_DRIVETRAIN      = "drivetrain"
+)
+
+// SegmentationRule decides if a point starts a new race. Split returns why the race ends, or an empty string.
+// Rules compare the point with the race.
+type SegmentationRule struct {
+	Name  string
+	Split func(race models.Race, point models.TelemetryPoint) string
+}
+
+// SEGMENTATION_RULES are the known rules, by name
+var SEGMENTATION_RULES = map[string]SegmentationRule{
+	// The race time starts at 0 and going backward usually means a new race, except on rewinds in solo play
+	// or when re-joining a multiplayer session. In this case we hope that at least one lap was done, then the lastLap value is set.
+	RULE_RACE_RESTART: {RULE_RACE_RESTART, func(race models.Race, point models.TelemetryPoint) string {
+		if point.CurrentRaceTime < 1 && point.CurrentRaceTime < race.RaceTime && point.LastLap == 0 {
+			return fmt.Sprintf("race time restarted from %.3f to %.3f", race.RaceTime, point.CurrentRaceTime)
+		}
+		return ""
+	}},
+	// Stricter than RULE_RACE_RESTART, re-joining a multiplayer session after a lap starts a new race too
+	RULE_RACE_TIME_RESET: {RULE_RACE_TIME_RESET, func(race models.Race, point models.TelemetryPoint) string {
+		if point.CurrentRaceTime < 1 && point.CurrentRaceTime < race.RaceTime {
+			return fmt.Sprintf("race time reset from %.3f to %.3f", race.RaceTime, point.CurrentRaceTime)
+		}
+		return ""
+	}},
+	// Switching car or track in free practice doesn't reset the race time
+	RULE_CAR: {RULE_CAR, func(race models.Race, point models.TelemetryPoint) string {
+		if point.CarOrdinal != race.Car {
+			return fmt.Sprintf("car changed from %d to %d", race.Car, point.CarOrdinal)
+		}
+		return ""
+	}},
+	RULE_TRACK: {RULE_TRACK, func(race models.Race, point models.TelemetryPoint) string {
+		if point.TrackOrdinal != race.Track {
+			return fmt.Sprintf("track changed from %d to %d", race.Track, point.TrackOrdinal)
+		}
+		return ""
+	}},
+	// Upgrading or tuning the car
+	RULE_PERFORMANCE: {RULE_PERFORMANCE, func(race models.Race, point models.TelemetryPoint) string {
+		if point.CarPerformanceIndex != race.CarPerformanceIndex {
+			return fmt.Sprintf("performance index changed from %d to %d", race.CarPerformanceIndex, point.CarPerformanceIndex)
+		}
+		return ""
+	}},
+	RULE_DRIVETRAIN: {RULE_DRIVETRAIN, func(race models.Race, point models.TelemetryPoint) string {
+		if point.DrivetrainType != race.Drivetrain {
+			return fmt.Sprintf("drivetrain changed from %d to %d", race.Drivetrain, point.DrivetrainType)
+		}
+		return ""
+	}},
+}
+
+// DEFAULT_SEGMENTATION_RULES are used by sessions unless configured otherwise
+var DEFAULT_SEGMENTATION_RULES = []SegmentationRule{
+	SEGMENTATION_RULES[RULE_RACE_RESTART],
+	SEGMENTATION_RULES[RULE_CAR],
+	SEGMENTATION_RULES[RULE_TRACK],
+	SEGMENTATION_RULES[RULE_PERFORMANCE],
+	SEGMENTATION_RULES[RULE_DRIVETRAIN],
+}
+
+// ParseSegmentationRules parses a comma separated list of rule names, like "raceTimeReset,car,track"
+func ParseSegmentationRules(value string) ([]SegmentationRule, error) {
+	var rules []SegmentationRule
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		rule, ok := SEGMENTATION_RULES[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown segmentation rule %q", name)
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no segmentation rule in %q", value)
+	}
+	return rules, nil
+}
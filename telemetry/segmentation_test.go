@@ -0,0 +1,75 @@
+package telemetry_test
+
+import (
+	"testing"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+)
+
+func countRaces(events []telemetry.RaceEvent) int {
+	count := 0
+	for _, event := range events {
+		if event.Type == telemetry.RACE_STARTED {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSessionSegmentation(t *testing.T) {
+	first := models.TelemetryPoint{OnTrack: 1, CurrentLap: 1, CurrentRaceTime: 100, LastLap: 60, CarOrdinal: 1, TrackOrdinal: 1, CarPerformanceIndex: 800, DrivetrainType: 1}
+	for _, test := range []struct {
+		name  string
+		rules []telemetry.SegmentationRule
+		next  func(point models.TelemetryPoint) models.TelemetryPoint
+		races int
+	}{
+		{"same race", nil, func(p models.TelemetryPoint) models.TelemetryPoint { p.CurrentRaceTime = 101; return p }, 1},
+		{"car change", nil, func(p models.TelemetryPoint) models.TelemetryPoint { p.CarOrdinal = 2; return p }, 2},
+		{"track change", nil, func(p models.TelemetryPoint) models.TelemetryPoint { p.TrackOrdinal = 2; return p }, 2},
+		{"performance index change", nil, func(p models.TelemetryPoint) models.TelemetryPoint { p.CarPerformanceIndex = 900; return p }, 2},
+		{"drivetrain change", nil, func(p models.TelemetryPoint) models.TelemetryPoint { p.DrivetrainType = 2; return p }, 2},
+		{"car change without car rule", []telemetry.SegmentationRule{telemetry.SEGMENTATION_RULES[telemetry.RULE_TRACK]}, func(p models.TelemetryPoint) models.TelemetryPoint { p.CarOrdinal = 2; return p }, 1},
+		// Re-joining a multiplayer session after a lap
+		{"rejoin", nil, func(p models.TelemetryPoint) models.TelemetryPoint { p.CurrentRaceTime = 0.5; return p }, 1},
+		{"rejoin with reset rule", []telemetry.SegmentationRule{telemetry.SEGMENTATION_RULES[telemetry.RULE_RACE_TIME_RESET]}, func(p models.TelemetryPoint) models.TelemetryPoint { p.CurrentRaceTime = 0.5; return p }, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sink := &telemetry.MemorySink{}
+			session := telemetry.NewSession(sink, telemetry.FORMAT_FM8)
+			if test.rules != nil {
+				session.SetSegmentationRules(test.rules)
+			}
+
+			for _, point := range []models.TelemetryPoint{first, test.next(first)} {
+				err := session.Add(point)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+			}
+
+			races := countRaces(sink.Events())
+			if races != test.races {
+				t.Errorf("expected %v got %v", test.races, races)
+			}
+		})
+	}
+}
+
+func TestParseSegmentationRules(t *testing.T) {
+	rules, err := telemetry.ParseSegmentationRules("raceTimeReset, car,")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(rules) != 2 || rules[0].Name != telemetry.RULE_RACE_TIME_RESET || rules[1].Name != telemetry.RULE_CAR {
+		t.Errorf("unexpected rules %v", rules)
+	}
+
+	for _, value := range []string{"", ",", "car,unknown"} {
+		_, err = telemetry.ParseSegmentationRules(value)
+		if err == nil {
+			t.Errorf("%q: expected error got nil", value)
+		}
+	}
+}
@@ -1,17 +1,15 @@
 package telemetry
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"forzatelemetry/models"
 	"forzatelemetry/storage"
 )
 
@@ -27,24 +25,92 @@ type Server struct {
 
 	addr string
 
-	listeners sync.Map
-	db        *storage.Store
-	server    net.PacketConn
-
-	sessionCheckpointInterval time.Duration
+	listeners  sync.Map
+	sessions   atomic.Int64 // count of listeners
+	limits     Limits
+	sinks      []Sink
+	store      *StoreSink
+	batch      BatchConfig
+	fanout     *Fanout
+	wal        *WAL
+	server     net.PacketConn
+	rejections *Rejections
+	capture    *Capture
+	relay      *Relay
+	live       *LiveHub
+	drivers    driverBindings
+	// Rate limiters of the sources without a listener yet, handed to their listener.
+	// Only used by the goroutine reading the datagrams.
+	limiters map[string]*rateLimiter
+
+	flushInterval     time.Duration
+	idleTimeout       time.Duration
+	resumeGracePeriod time.Duration
+	closedRaces       closedRaces
+	rules             []SegmentationRule
 
 	running bool
+	done    chan struct{}
 }
 
-func NewServer(addr string, db *storage.Store, sessionCheckpointInterval time.Duration) *Server {
+// NewServer creates a server saving races to db, if not nil, with a BatchWriter. Other sinks are added with AddSink.
+// Sessions are checkpointed every flushInterval and closed after flushInterval without packets, see SetIdleTimeout.
+func NewServer(addr string, db *storage.Store, flushInterval time.Duration) *Server {
 	if addr == "" {
 		addr = ":8000"
 	}
 
-	return &Server{
-		addr:                      addr,
-		db:                        db,
-		sessionCheckpointInterval: sessionCheckpointInterval,
+	server := &Server{
+		addr:          addr,
+		rejections:    NewRejections(),
+		live:          NewLiveHub(),
+		flushInterval: flushInterval,
+		idleTimeout:   flushInterval,
+		batch:         DEFAULT_BATCH_CONFIG,
+		limiters:      make(map[string]*rateLimiter),
+	}
+	if db != nil {
+		server.store = NewStoreSink(db)
+	}
+	return server
+}
+
+// SetBatchConfig sets how the sessions, races and points of every session are coalesced before being written to the database,
+// DEFAULT_BATCH_CONFIG by default. The Interval must be positive. Must be called before ListenAndProcess.
+func (s *Server) SetBatchConfig(config BatchConfig) {
+	s.batch = config
+}
+
+// SetIdleTimeout sets how long a source can be silent before its session is closed.
+// It's checked every flush interval. Must be called before ListenAndProcess.
+func (s *Server) SetIdleTimeout(timeout time.Duration) {
+	s.idleTimeout = timeout
+}
+
+// SetResumeGracePeriod lets a source resume its race when its packets come back after its session was closed,
+// if the car, the track and the race time carry on. Disabled by default. Must be called before ListenAndProcess.
+func (s *Server) SetResumeGracePeriod(gracePeriod time.Duration) {
+	s.resumeGracePeriod = gracePeriod
+}
+
+// SetSegmentationRules sets the rules deciding when a race ends and a new one starts in a session,
+// DEFAULT_SEGMENTATION_RULES by default. Must be called before ListenAndProcess.
+func (s *Server) SetSegmentationRules(rules []SegmentationRule) {
+	s.rules = rules
+}
+
+// SetLimits sets the sources allowed to send telemetry, the maximum count of sessions and the packet rate of a source.
+// Rejected packets are counted in Rejections. Must be called before ListenAndProcess.
+func (s *Server) SetLimits(limits Limits) {
+	s.limits = limits
+}
+
+// SetWAL keeps the points not checkpointed yet in a write-ahead log. Must be called before ListenAndProcess,
+// which saves the points left in the log by a previous run.
+func (s *Server) SetWAL(wal *WAL) {
+	s.wal = wal
+	if s.store != nil {
+		s.store.wal = wal
 	}
 }
 
@@ -68,6 +134,47 @@ func (s *Server) Listeners() []string {
 	return listeners
 }
 
+// AddSink publishes the races and points of every session to sink. Must be called before ListenAndProcess.
+func (s *Server) AddSink(sink Sink) {
+	s.sinks = append(s.sinks, sink)
+}
+
+// SinkStats returns the count of failed and dropped messages per sink
+func (s *Server) SinkStats() []SinkStats {
+	if s.fanout == nil {
+		return nil
+	}
+	return s.fanout.Stats()
+}
+
+// Live returns the hub broadcasting points as they are received
+func (s *Server) Live() *LiveHub {
+	return s.live
+}
+
+// SetCapture enables the recording of raw datagrams. Must be called before ListenAndProcess.
+func (s *Server) SetCapture(capture *Capture) {
+	s.capture = capture
+}
+
+// SetRelay enables the forwarding of raw datagrams to other tools. Must be called before ListenAndProcess.
+func (s *Server) SetRelay(relay *Relay) {
+	s.relay = relay
+}
+
+// RelayStats returns the count of forwarded datagrams per destination
+func (s *Server) RelayStats() []RelayStats {
+	if s.relay == nil {
+		return nil
+	}
+	return s.relay.Stats()
+}
+
+// Rejections returns the count of malformed packets per source address and reason
+func (s *Server) Rejections() []Rejection {
+	return s.rejections.List()
+}
+
 func (s *Server) ListenAndProcess() error {
 	err := s.listen()
 	if err != nil {
@@ -85,9 +192,37 @@ func (s *Server) listen() error {
 		return ErrAlreadyRunning
 	}
 
-	var err error
+	if s.wal != nil && s.store != nil {
+		recovered, err := s.wal.Recover(context.Background(), s.store)
+		if err != nil {
+			slog.Error("failed recovering wal", "error", err)
+		} else if recovered > 0 {
+			slog.Info("recovered points from wal", "points", recovered)
+		}
+	}
+
+	// Nothing is running yet, every race in progress is orphaned
+	_, err := s.RecoverOrphanedRaces(time.Now(), context.Background())
+	if err != nil {
+		slog.Error("failed recovering orphaned races", "error", err)
+	}
+	err = s.RefreshDrivers(context.Background())
+	if err != nil {
+		slog.Error("failed loading drivers", "error", err)
+	}
+
 	s.server, err = net.ListenPacket("udp", s.addr)
+	sinks := s.sinks
+	if s.store != nil {
+		sinks = append([]Sink{Required(NewBatchWriter(s.store, s.batch))}, sinks...)
+	}
+	s.fanout = NewFanout(sinks...)
 	s.running = true
+	if err == nil {
+		s.done = make(chan struct{})
+		s.wg.Add(1)
+		go s.recoverOrphanedRaces(s.done)
+	}
 	return err
 }
 
@@ -120,11 +255,33 @@ func (s *Server) shutdown() error {
 	}
 
 	s.listeners.Range(func(k any, v any) bool {
-		close(v.(chan models.TelemetryPoint))
+		v.(*listener).stop()
 		return true
 	})
+	if s.done != nil {
+		close(s.done)
+	}
 
 	s.wg.Wait()
+
+	err = s.fanout.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close sinks: %v", err)
+	}
+
+	if s.relay != nil {
+		err = s.relay.Close()
+		if err != nil {
+			return fmt.Errorf("failed to close relay: %v", err)
+		}
+	}
+
+	if s.capture != nil {
+		err = s.capture.Close()
+		if err != nil {
+			return fmt.Errorf("failed to close capture: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -140,83 +297,249 @@ func (s *Server) read() {
 }
 
 func (s *Server) readOne(buf []byte) {
-	_, addr, err := s.server.ReadFrom(buf)
+	n, addr, err := s.server.ReadFrom(buf)
 	if err != nil {
 		slog.Debug("failed reading from udp", "error", err)
 		return
 	}
 
-	var point models.TelemetryPoint
-	binary.Read(bytes.NewReader(buf), binary.LittleEndian, &point)
+	key := addr.String()
+	packetsReceived.Inc()
 
-	if point.TimestampMS == 0 {
+	// Checked before anything else, unwanted traffic is only counted
+	if !s.limits.allowed(addr) {
+		s.reject(key, REASON_DENIED)
+		return
+	}
+	if limiter := s.rateLimiter(key); limiter != nil && !limiter.allow(time.Now()) {
+		s.reject(key, REASON_RATE_LIMIT)
+		return
+	}
+
+	if s.capture != nil {
+		err = s.capture.Write(key, time.Now(), buf[:n])
+		if err != nil {
+			slog.Error("failed capturing datagram", "error", err, "session", key)
+		}
+	}
+
+	if s.relay != nil {
+		s.relay.Forward(key, buf[:n])
+	}
+
+	packet, err := Decode(buf[:n])
+	if err == nil {
+		err = Validate(packet)
+	}
+	if err != nil {
+		var invalid *InvalidPacketError
+		if errors.As(err, &invalid) {
+			s.rejections.Add(key, invalid.Reason)
+			packetsDropped.Inc(invalid.Reason)
+		}
+		slog.Debug("rejecting packet", "error", err, "session", key)
+		return
+	}
+
+	if packet.TimestampMS == 0 {
+		packetsDropped.Inc(REASON_ZERO_TIMESTAMP)
 		slog.Debug("discarding 0 timestamp point")
 		return
 	}
+	packetsDecoded.Inc(packet.Format.Version)
 
-	key := addr.String()
+	l := s.findListener(key)
+	if l == nil {
+		s.reject(key, REASON_SESSION_LIMIT)
+		return
+	}
+	l.received.Add(1)
+	l.lastPacketAt.Store(time.Now().UnixNano())
 	select {
-	case s.findChannel(key) <- point:
+	case l.c <- packet:
 		return
 	default:
+		l.dropped.Add(1)
+		packetsDropped.Inc(REASON_CHANNEL_FULL)
 		slog.Warn("session channel full", "session", key)
 	}
 }
 
-func (s *Server) findChannel(key string) chan models.TelemetryPoint {
-	sessionC, loaded := s.listeners.Load(key)
+func (s *Server) reject(key string, reason string) {
+	s.rejections.Add(key, reason)
+	packetsDropped.Inc(reason)
+	slog.Debug("rejecting packet", "reason", reason, "session", key)
+}
+
+// rateLimiter returns the rate limiter of the source, the first packet of a new source is counted before its listener starts
+func (s *Server) rateLimiter(key string) *rateLimiter {
+	if l, ok := s.listeners.Load(key); ok {
+		return l.(*listener).limiter
+	}
+	limiter, ok := s.limiters[key]
+	if ok {
+		return limiter
+	}
+	limiter = s.limits.newRateLimiter()
+	if limiter == nil {
+		return nil
+	}
+	// Sources sending only invalid packets never start a listener
+	if len(s.limiters) >= PENDING_LIMITERS {
+		now := time.Now()
+		for source, pending := range s.limiters {
+			if pending.full(now) {
+				delete(s.limiters, source)
+			}
+		}
+	}
+	s.limiters[key] = limiter
+	return limiter
+}
+
+// findListener returns the listener of the source, a new one is started unless the maximum count of sessions is reached
+func (s *Server) findListener(key string) *listener {
+	l, loaded := s.listeners.Load(key)
 	if !loaded {
-		c := make(chan models.TelemetryPoint, SESSION_CHANNEL_SIZE)
-		sessionC, loaded = s.listeners.LoadOrStore(key, c)
+		if s.limits.MaxSessions > 0 && s.sessions.Load() >= int64(s.limits.MaxSessions) {
+			return nil
+		}
+		newL := newListener(key)
+		newL.limiter = s.limiters[key]
+		delete(s.limiters, key)
+		if newL.limiter == nil {
+			newL.limiter = s.limits.newRateLimiter()
+		}
+		l, loaded = s.listeners.LoadOrStore(key, newL)
 		if !loaded {
-			go s.process(key, c)
+			s.sessions.Add(1)
+			// Added before starting the goroutine, shutdown must wait for the session to publish its last points
+			s.wg.Add(1)
+			go s.process(newL)
 		}
 	}
-	return sessionC.(chan models.TelemetryPoint)
+	return l.(*listener)
 }
 
-func (s *Server) process(key string, c chan models.TelemetryPoint) {
-	s.wg.Add(1)
-	defer s.wg.Done()
+// newSession records the ID of the capture of the source, if any, on the session so captures can be matched with races
+func (s *Server) newSession(key string, format Format) *Session {
+	session := NewSession(s.fanout, format)
+	session.source = key
+	if s.capture != nil {
+		session.capture, _ = s.capture.CaptureID(key)
+	}
+	session.driver = s.drivers.resolve(key)
+	session.live = s.live
+	session.wal = s.wal
+	if s.rules != nil {
+		session.SetSegmentationRules(s.rules)
+	}
 
-	session := NewSession(s.db)
-	defer func() {
-		err := session.Close()
-		if err != nil {
-			slog.Error("failed closing session", "error", err, "session", session.ID)
+	err := session.publishSession(time.Time{})
+	if err != nil {
+		slog.Error("failed starting session", "error", err, "session", session.ID)
+	}
+	return session
+}
+
+func (s *Server) process(l *listener) {
+	defer s.wg.Done()
+	defer s.sessions.Add(-1)
+	sessionsActive.Add(1)
+	defer sessionsActive.Add(-1)
+	key := l.source
+	c := l.c
+
+	// The session is created with the first packet, its length tells which game is sending telemetry
+	var session *Session
+	var droppedAt uint64 // packets of the source dropped before the session started
+	updateStats := func() {
+		session.dropped = int64(l.dropped.Load() - droppedAt)
+	}
+	closeSession := func() {
+		if session == nil {
+			return
 		}
-	}()
+		updateStats()
+		s.closeSession(key, session)
+	}
+	defer closeSession()
+
+	if s.capture != nil {
+		defer func() {
+			err := s.capture.CloseSource(key)
+			if err != nil {
+				slog.Error("failed closing capture", "error", err, "session", key)
+			}
+		}()
+	}
 
 	received := false
+	resuming := false // a race closed by the source may be resumed by the first packet on track
+	lastPacketAt := time.Now()
 	var ok bool
 	var err error
-	var point models.TelemetryPoint
+	var packet Packet
 
-	ticker := time.NewTicker(s.sessionCheckpointInterval)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
 	for {
 		select {
-		case point, ok = <-c:
+		case packet, ok = <-c:
 			if !ok {
 				s.listeners.Delete(key)
 				return
 			}
 			received = true
+			lastPacketAt = time.Now()
+			if session == nil || session.Format.Size != packet.Format.Size {
+				closeSession()
+				session = s.newSession(key, packet.Format)
+				droppedAt = l.dropped.Load()
+				err = nil
+				resuming = s.resumeGracePeriod > 0
+			}
+			if resuming && packet.OnTrack != 0 {
+				resuming = false
+				if closed, ok := s.closedRaces.take(key, packet, s.resumeGracePeriod); ok {
+					err = session.resume(closed.race, closed.last)
+					if err != nil {
+						slog.Error("failed resuming race", "error", err, "session", session.ID)
+					}
+				}
+			}
+			session.received++
 			if err == nil {
-				err = session.Add(point)
+				err = session.Add(packet.TelemetryPoint)
 				if err != nil {
 					slog.Error("failed processing point", "error", err, "session", session.ID)
 				}
 			}
+			l.update(session)
+		case <-l.kill:
+			slog.Info("force closing session", "session", key)
+			s.listeners.Delete(key)
+			return
 		case <-ticker.C:
 			if !received {
+				if time.Since(lastPacketAt) < s.idleTimeout {
+					continue
+				}
 				s.listeners.Delete(key)
-				close(c)
+				l.stop()
 				return
 			}
+			start := time.Now()
 			err = session.Checkpoint()
 			if err != nil {
 				slog.Error("failed checkpointing", "error", err, "session", session.ID)
 			}
+			l.checkpointed(time.Since(start))
+			updateStats()
+			err = session.publishSession(time.Time{})
+			if err != nil {
+				slog.Error("failed updating session", "error", err, "session", session.ID)
+			}
 			received = false
 		}
 	}
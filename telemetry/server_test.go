@@ -107,13 +107,21 @@ func TestServerGarbageData(t *testing.T) {
 		t.Fatalf("unexpected error %v", err)
 	}
 
-	time.Sleep(10 * time.Microsecond)
+	time.Sleep(100 * time.Millisecond)
 
 	listeners := server.Listeners()
 	if len(listeners) != 0 {
 		t.Errorf("expected 0 got %v", len(listeners))
 	}
 
+	rejections := server.Rejections()
+	if len(rejections) != 1 {
+		t.Fatalf("expected 1 got %v", len(rejections))
+	}
+	if rejections[0].Reason != telemetry.REASON_LENGTH || rejections[0].Count != 1 {
+		t.Errorf("expected %v got %+v", telemetry.REASON_LENGTH, rejections[0])
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	err = server.Shutdown(ctx)
 	if err != nil {
@@ -127,6 +135,7 @@ func TestServerValidData(t *testing.T) {
 	defer store.Close()
 
 	server := telemetry.NewServer("127.0.0.1:0", store, 5*time.Second)
+	server.SetBatchConfig(testBatchConfig)
 	go func(t *testing.T) {
 		err := server.ListenAndProcess()
 		if err == nil {
@@ -247,6 +256,7 @@ func TestServerCheckpoint(t *testing.T) {
 
 	checkpointInterval := 500 * time.Millisecond
 	server := telemetry.NewServer("127.0.0.1:0", store, checkpointInterval)
+	server.SetBatchConfig(testBatchConfig)
 	go func(t *testing.T) {
 		err := server.ListenAndProcess()
 		if err == nil {
@@ -326,3 +336,124 @@ func TestServerCheckpoint(t *testing.T) {
 		t.Fatalf("expected 0 got %v", len(listeners))
 	}
 }
+
+func TestServerHorizonData(t *testing.T) {
+	store := testutils.NewStore()
+	defer store.Close()
+
+	server := telemetry.NewServer("127.0.0.1:0", store, 5*time.Second)
+	server.SetBatchConfig(testBatchConfig)
+	go func(t *testing.T) {
+		err := server.ListenAndProcess()
+		if err == nil {
+			t.Error("expected error got nil")
+		} else if !errors.Is(err, telemetry.ErrServerClosed) {
+			t.Errorf("expected %v got %v", telemetry.ErrServerClosed, err)
+		}
+	}(t)
+
+	addr := server.Addr()
+	for addr == nil {
+		time.Sleep(10 * time.Microsecond)
+		addr = server.Addr()
+	}
+
+	con, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	point := testutils.Point(
+		testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"),
+		time.Now(),
+		1,
+	)
+
+	_, err = con.Write(encodeFH(t, point.TelemetryPoint))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	races, _, err := store.SelectRaces(nil, 0, ctx, "")
+	cancel()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(races) != 1 {
+		t.Fatalf("expected 1 got %v", len(races))
+	}
+	if races[0].Game != models.GAME_FH {
+		t.Errorf("expected %v got %v", models.GAME_FH, races[0].Game)
+	}
+	if races[0].Track != models.UNKNOWN_TRACK {
+		t.Errorf("expected %v got %v", models.UNKNOWN_TRACK, races[0].Track)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	err = server.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	cancel()
+}
+
+func TestServerDriver(t *testing.T) {
+	store := testutils.NewStore()
+	defer store.Close()
+
+	err := store.UpsertDrivers(context.Background(), models.Driver{Name: "local", Sources: []models.DriverSource{{Source: "127.0.0.1"}}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	server := telemetry.NewServer("127.0.0.1:0", store, 5*time.Second)
+	con := startServer(t, server)
+
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1)
+	err = binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// the next session of the source records the driver bound after the refresh
+	err = store.UpsertDrivers(context.Background(), models.Driver{Name: "port", Sources: []models.DriverSource{{Source: con.LocalAddr().String()}}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = server.RefreshDrivers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sessions := server.Sessions()
+	if len(sessions) != 1 || !server.CloseSession(sessions[0].Session) {
+		t.Fatalf("expected to close the session of %v", sessions)
+	}
+	for len(server.Listeners()) != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	shutdownServer(t, server)
+
+	races, count, err := store.SelectRaces(nil, 0, context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 got %v", count)
+	}
+	drivers := map[string]bool{}
+	for _, race := range races {
+		drivers[race.DriverMetadata.Name] = true
+	}
+	if !drivers["local"] || !drivers["port"] {
+		t.Errorf("expected drivers local and port got %v", drivers)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/google/uuid"
 
 	"forzatelemetry/models"
-	"forzatelemetry/storage"
 )
 
 // Allocate the array once. We receive 60 points per second maximum, for 5s we need a capacity of 300. We take a small margin to make sure we don't need to grow the array
@@ -20,20 +19,39 @@ const SESSION_CHANNEL_SIZE = 60
 var EMPTY_UUID = uuid.UUID{}
 
 type Session struct {
-	ID uuid.UUID
-	db *storage.Store
+	ID     uuid.UUID
+	Format Format
+	sink   Sink
+	rules  []SegmentationRule
+	live   *LiveHub // optional
+	wal    *WAL     // optional
+
+	// WAL segment of the points since the last checkpoint
+	segment *walSegment
 
 	points []models.Point
+	last   models.Point
 	race   models.Race
+
+	// Published with the session, set by the server
+	source    string
+	capture   uuid.UUID // ID of the capture of the source, zero when the source isn't captured
+	startedAt time.Time
+	received  int64
+	dropped   int64
+	driver    int64 // recorded on races, 0 when the source isn't bound to a driver
 }
 
-func NewSession(db *storage.Store) *Session {
+func NewSession(sink Sink, format Format) *Session {
 	session := &Session{
-		ID:     uuid.New(),
-		db:     db,
-		points: make([]models.Point, 0, POINT_ARRAY_LENGTH),
+		ID:        uuid.New(),
+		Format:    format,
+		sink:      sink,
+		rules:     DEFAULT_SEGMENTATION_RULES,
+		points:    make([]models.Point, 0, POINT_ARRAY_LENGTH),
+		startedAt: time.Now(),
 	}
-	slog.Info("new session", "session", session.ID)
+	slog.Info("new session", "session", session.ID, "game", format.Game)
 	return session
 }
 
@@ -47,6 +65,7 @@ func (s *Session) Close() error {
 		slog.Error("failed checkpointing before closing session", "error", err, "session", s.ID)
 	}
 	err = s.endRace()
+	s.closeSegment()
 	return err
 }
 
@@ -55,17 +74,19 @@ func (s *Session) Add(point models.TelemetryPoint) error {
 		return s.pause()
 	}
 
-	if point.CurrentLap == 0 {
+	// Formats without the dash section (Forza Motorsport 7 sled) never have a current lap
+	if point.CurrentLap == 0 && s.Format.Fields.Has(models.FIELDS_DASH) {
 		return nil
 	}
 
 	var err error
 	if s.race.ID == EMPTY_UUID {
-		s.race = models.MakeRace(point, s.ID)
-		slog.Info("new race", "id", s.race.ID, "session", s.ID)
-		err = s.saveRace()
-	} else if s.isNewRace(point) {
-		slog.Info("finished race", "race", s.race.ID, "session", s.ID)
+		s.newRace(point)
+		err = s.publishRace(RACE_STARTED)
+	} else if rule, reason := s.boundary(point); rule != "" {
+		// Decision log, explains why the race was split
+		slog.Info("finished race", "race", s.race.ID, "session", s.ID, "rule", rule, "reason", reason)
+		raceBoundaries.Inc(rule)
 		err = s.Checkpoint()
 		if err != nil {
 			return err
@@ -75,22 +96,31 @@ func (s *Session) Add(point models.TelemetryPoint) error {
 			return err
 		}
 
-		newRace := models.MakeRace(point, s.ID)
-		slog.Info("new race", "id", newRace.ID, "session", s.ID)
-		err = s.db.UpsertRaces(context.Background(), s.race, newRace)
+		s.newRace(point)
+		err = s.publishRace(RACE_STARTED)
 		if err != nil {
 			return err
 		}
-		s.race = newRace
 	} else if s.race.Paused {
 		err = s.unpause()
 	}
 
-	s.points = append(s.points, models.Point{TelemetryPoint: point, Race: s.race.ID, CreatedAt: time.Now()})
+	s.last = models.Point{TelemetryPoint: point, Race: s.race.ID, CreatedAt: time.Now()}
+	s.points = append(s.points, s.last)
+	s.appendWAL(s.last)
+	if s.live != nil {
+		s.live.Publish(s.last)
+	}
 	s.race.RaceTime = point.CurrentRaceTime
 	return err
 }
 
+func (s *Session) newRace(point models.TelemetryPoint) {
+	s.race = models.MakeRace(point, s.ID, s.Format.Game, s.Format.Fields)
+	s.race.Driver = s.driver
+	slog.Info("new race", "id", s.race.ID, "session", s.ID, "driver", s.driver)
+}
+
 func (s *Session) pause() error {
 	if (s.race.ID != EMPTY_UUID) && !s.race.Paused {
 		slog.Info("pausing", "race", s.race.ID)
@@ -99,7 +129,7 @@ func (s *Session) pause() error {
 			return fmt.Errorf("failed to pause race: %w", err)
 		}
 		s.race.Paused = true
-		err = s.saveRace()
+		err = s.publishRace(RACE_PAUSED)
 		if err != nil {
 			return fmt.Errorf("failed to pause race: %w", err)
 		}
@@ -110,7 +140,7 @@ func (s *Session) pause() error {
 func (s *Session) unpause() error {
 	slog.Info("unpausing", "race", s.race.ID)
 	s.race.Paused = false
-	err := s.saveRace()
+	err := s.publishRace(RACE_RESUMED)
 	if err != nil {
 		return fmt.Errorf("failed to unpause race: %w", err)
 	}
@@ -123,44 +153,119 @@ func (s *Session) Checkpoint() error {
 		return nil
 	}
 	start := time.Now()
+	// The points are in the WAL before they are published, a failed checkpoint keeps them for the next one
+	s.flushSegment()
 
 	s.race = s.race.Update(s.points[len(s.points)-1])
-	s.saveRace()
+	s.publishRace(RACE_UPDATED)
 
-	err := s.db.InsertPoints(s.points, context.Background())
+	err := s.sink.PublishPoints(context.Background(), s.points)
 	if err != nil {
 		return fmt.Errorf("failed checkpointing race %s: %w", s.race.ID, err)
 	}
+	s.closeSegment()
+	checkpointPoints.Observe(float64(len(s.points)))
+	checkpointDuration.Observe(time.Since(start).Seconds())
 	s.points = s.points[:0]
 	slog.Debug("checkpoint", "race", s.race.ID, "points", len(s.points), "cap", cap(s.points), "duration", time.Since(start))
 	return nil
 }
 
-func (s *Session) isNewRace(point models.TelemetryPoint) bool {
-	// Figuring out when a "race" ends or start is tricky as it heavily depends on what you consider a race and the type of lobby (solo vs multiplayer).
-	// We can mostly based ourselve on the currentRaceTime. It starts a 0 and if it ever goes backward we can expect it to be a new race.
-	// But that's not enough, there are some exceptions were the time goes backward :'(
-	// * rewind in solo play
-	// * re-joining a multiplayer session
-	// In this case we hope that at least one lap was done, then the lastLap value is set.
-	return point.CurrentRaceTime < 1 && point.CurrentRaceTime < s.race.RaceTime && point.LastLap == 0
+// appendWAL buffers the point in the segment of the current batch, the segment is flushed at checkpoint.
+// Failures are logged, the point is still saved at the next checkpoint.
+func (s *Session) appendWAL(point models.Point) {
+	if s.wal == nil {
+		return
+	}
+	var err error
+	if s.segment == nil {
+		// Named after the first point of the batch, like the points published at checkpoint
+		s.segment, err = s.wal.open(s.points[0])
+		if err != nil {
+			slog.Error("failed opening wal segment", "error", err, "session", s.ID)
+			return
+		}
+	}
+	err = s.segment.Append(point)
+	if err != nil {
+		slog.Error("failed writing to wal", "error", err, "session", s.ID)
+	}
 }
 
-func (s *Session) saveRace() error {
-	err := s.db.UpsertRaces(context.Background(), s.race)
+func (s *Session) flushSegment() {
+	if s.segment == nil {
+		return
+	}
+	err := s.segment.Flush()
 	if err != nil {
-		return fmt.Errorf("failed to upsert race: %w", err)
+		slog.Error("failed flushing wal segment", "error", err, "session", s.ID)
 	}
-	return err
 }
 
-func (s *Session) endRace() error {
-	var err error
-	point, err := s.db.SelectLastPoint(s.race.ID.String(), context.Background())
+func (s *Session) closeSegment() {
+	if s.segment == nil {
+		return
+	}
+	err := s.segment.Close()
+	if err != nil {
+		slog.Error("failed closing wal segment", "error", err, "session", s.ID)
+	}
+	s.segment = nil
+}
+
+// RaceID returns the ID of the current race, EMPTY_UUID before the first race
+func (s *Session) RaceID() uuid.UUID {
+	return s.race.ID
+}
+
+// SetSegmentationRules replaces the rules deciding when a race ends and a new one starts, DEFAULT_SEGMENTATION_RULES by default
+func (s *Session) SetSegmentationRules(rules []SegmentationRule) {
+	s.rules = rules
+}
+
+// boundary returns the first rule splitting the race at point and its reason, an empty rule if the point continues the race.
+// Figuring out when a "race" ends or start is tricky as it heavily depends on what you consider a race and the type of lobby (solo vs multiplayer).
+func (s *Session) boundary(point models.TelemetryPoint) (string, string) {
+	for _, rule := range s.rules {
+		if reason := rule.Split(s.race, point); reason != "" {
+			return rule.Name, reason
+		}
+	}
+	return "", ""
+}
+
+func (s *Session) publishRace(eventType RaceEventType) error {
+	err := s.sink.PublishRace(context.Background(), RaceEvent{Type: eventType, Race: s.race})
 	if err != nil {
-		return fmt.Errorf("failed to read last point of race: %w", err)
+		return fmt.Errorf("failed to publish race: %w", err)
 	}
+	return nil
+}
 
-	s.race = s.race.End(point)
-	return s.saveRace()
+// publishSession publishes the session and its packet stats, finishedAt is zero while the session is active
+func (s *Session) publishSession(finishedAt time.Time) error {
+	err := s.sink.PublishSession(context.Background(), models.Session{
+		ID:         s.ID,
+		Source:     s.source,
+		Game:       s.Format.Game,
+		Format:     s.Format.Version,
+		Capture:    s.capture,
+		StartedAt:  s.startedAt,
+		FinishedAt: finishedAt,
+		Received:   s.received,
+		Dropped:    s.dropped,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish session: %w", err)
+	}
+	return nil
+}
+
+// endRace ends the race with its last point, Checkpoint must be called first
+func (s *Session) endRace() error {
+	s.race = s.race.End(s.last)
+	if s.live != nil {
+		s.live.End(s.race.ID)
+	}
+	return s.publishRace(RACE_ENDED)
 }
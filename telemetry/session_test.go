@@ -30,7 +30,7 @@ func TestSessionCreateRace(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	session := telemetry.NewSession(db)
+	session := telemetry.NewSession(telemetry.NewStoreSink(db), telemetry.FORMAT_FM8)
 	session.Add(models.TelemetryPoint{
 		OnTrack:    1,
 		CurrentLap: 1,
@@ -50,7 +50,7 @@ func TestSessionPauseRace(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	session := telemetry.NewSession(db)
+	session := telemetry.NewSession(telemetry.NewStoreSink(db), telemetry.FORMAT_FM8)
 	session.Add(models.TelemetryPoint{
 		OnTrack:    1,
 		CurrentLap: 1,
@@ -98,7 +98,7 @@ func TestSessionPauseNoRace(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	session := telemetry.NewSession(db)
+	session := telemetry.NewSession(telemetry.NewStoreSink(db), telemetry.FORMAT_FM8)
 	session.Add(models.TelemetryPoint{
 		OnTrack:    0,
 		CurrentLap: 1,
@@ -110,7 +110,7 @@ func TestSessionNoCurrentLap(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	session := telemetry.NewSession(db)
+	session := telemetry.NewSession(telemetry.NewStoreSink(db), telemetry.FORMAT_FM8)
 	session.Add(models.TelemetryPoint{
 		OnTrack:    1,
 		CurrentLap: 0,
@@ -122,7 +122,7 @@ func TestSessionCloseNoRace(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	session := telemetry.NewSession(db)
+	session := telemetry.NewSession(telemetry.NewStoreSink(db), telemetry.FORMAT_FM8)
 	session.Add(models.TelemetryPoint{
 		OnTrack:    0,
 		CurrentLap: 0,
@@ -136,7 +136,7 @@ func TestSessionCloseRaceUpdate(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	session := telemetry.NewSession(db)
+	session := telemetry.NewSession(telemetry.NewStoreSink(db), telemetry.FORMAT_FM8)
 	session.Add(models.TelemetryPoint{
 		OnTrack:         1,
 		CurrentLap:      1,
@@ -182,7 +182,7 @@ func TestSessionNewRace(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	session := telemetry.NewSession(db)
+	session := telemetry.NewSession(telemetry.NewStoreSink(db), telemetry.FORMAT_FM8)
 	session.Add(models.TelemetryPoint{
 		OnTrack:         1,
 		CurrentLap:      1,
@@ -247,9 +247,32 @@ func TestSessionChecpoi(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	session := telemetry.NewSession(db)
+	session := telemetry.NewSession(telemetry.NewStoreSink(db), telemetry.FORMAT_FM8)
 	err := session.Checkpoint()
 	if err != nil {
 		t.Errorf("expected nil got %v", err)
 	}
 }
+
+func TestSessionSledRace(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	// Sled packets don't have a current lap, races are still recorded
+	session := telemetry.NewSession(telemetry.NewStoreSink(db), telemetry.FORMAT_FM7_SLED)
+	session.Add(models.TelemetryPoint{
+		OnTrack:    1,
+		CurrentLap: 0,
+	})
+
+	races, count, err := db.SelectRaces(nil, 0, context.Background(), "")
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 got %v", count)
+	}
+	if races[0].Fields != models.FIELDS_SLED {
+		t.Errorf("expected %v got %v", models.FIELDS_SLED, races[0].Fields)
+	}
+}
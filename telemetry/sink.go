@@ -0,0 +1,285 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"forzatelemetry/models"
+	"forzatelemetry/storage"
+)
+
+// Messages waiting to be published to a sink by a Fanout. Sessions publish points every checkpoint interval,
+// a sink can fall that many checkpoints behind before messages are dropped.
+const SINK_BUFFER = 64
+
+type RaceEventType string
+
+const (
+	RACE_STARTED RaceEventType = "started"
+	RACE_UPDATED RaceEventType = "updated"
+	RACE_PAUSED  RaceEventType = "paused"
+	RACE_RESUMED RaceEventType = "resumed"
+	RACE_ENDED   RaceEventType = "ended"
+)
+
+type RaceEvent struct {
+	Type RaceEventType
+	Race models.Race
+}
+
+// A Sink receives the sessions, the races lifecycle events and the points recorded by sessions.
+// Points of a race are published after the RACE_STARTED event of the race.
+type Sink interface {
+	// PublishSession is called when a session starts, at checkpoints with its packet stats and when it's closed
+	PublishSession(ctx context.Context, session models.Session) error
+	PublishRace(ctx context.Context, event RaceEvent) error
+	// PublishPoints must not keep the points slice, it's reused by the session
+	PublishPoints(ctx context.Context, points []models.Point) error
+}
+
+// StoreSink saves races and points to the database
+type StoreSink struct {
+	db  *storage.Store
+	wal *WAL // optional, segments of the saved points are deleted
+}
+
+func NewStoreSink(db *storage.Store) *StoreSink {
+	return &StoreSink{db: db}
+}
+
+func (s *StoreSink) PublishSession(ctx context.Context, session models.Session) error {
+	return s.db.UpsertSessions(ctx, session)
+}
+
+func (s *StoreSink) PublishRace(ctx context.Context, event RaceEvent) error {
+	return s.db.UpsertRaces(ctx, event.Race)
+}
+
+func (s *StoreSink) PublishPoints(ctx context.Context, points []models.Point) error {
+	err := s.db.InsertPoints(points, ctx)
+	if err != nil {
+		return err
+	}
+	s.removeWAL(points)
+	return nil
+}
+
+// WriteBatch saves a batch in one transaction, then deletes the WAL segments of its checkpoints.
+// A saved batch must not be written again, a segment that can't be deleted is only logged and counted.
+// Its points would be saved again by the recovery of the next run.
+func (s *StoreSink) WriteBatch(ctx context.Context, batch Batch) error {
+	var points []models.Point
+	for _, checkpoint := range batch.Checkpoints {
+		points = append(points, checkpoint...)
+	}
+	err := s.db.WriteBatch(ctx, batch.Sessions, batch.Races, points)
+	if err != nil {
+		return err
+	}
+
+	for _, checkpoint := range batch.Checkpoints {
+		s.removeWAL(checkpoint)
+	}
+	return nil
+}
+
+// removeWAL deletes the WAL segment of saved points
+func (s *StoreSink) removeWAL(points []models.Point) {
+	if s.wal == nil {
+		return
+	}
+	err := s.wal.Remove(points)
+	if err != nil {
+		walRemoveFailed.Inc()
+		slog.Error("failed removing wal segment", "error", err)
+	}
+}
+
+// MemorySink keeps everything published in memory
+type MemorySink struct {
+	m        sync.Mutex
+	sessions []models.Session
+	events   []RaceEvent
+	points   []models.Point
+}
+
+func (s *MemorySink) PublishSession(ctx context.Context, session models.Session) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.sessions = append(s.sessions, session)
+	return nil
+}
+
+func (s *MemorySink) PublishRace(ctx context.Context, event RaceEvent) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *MemorySink) PublishPoints(ctx context.Context, points []models.Point) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.points = append(s.points, points...)
+	return nil
+}
+
+func (s *MemorySink) Sessions() []models.Session {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]models.Session(nil), s.sessions...)
+}
+
+func (s *MemorySink) Events() []RaceEvent {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]RaceEvent(nil), s.events...)
+}
+
+func (s *MemorySink) Points() []models.Point {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]models.Point(nil), s.points...)
+}
+
+type SinkStats struct {
+	Sink    string `json:"sink"`
+	Failed  uint64 `json:"failed"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// Fanout publishes to several sinks at the same time. Each sink is called from its own goroutine,
+// a slow optional sink drops its own messages once its buffer is full and a failing sink only logs errors.
+// A required sink never drops messages, publishing waits for room in its buffer.
+type Fanout struct {
+	wg    sync.WaitGroup
+	sinks []*fanoutSink
+}
+
+type fanoutSink struct {
+	name     string
+	sink     Sink
+	required bool
+	c        chan sinkMessage
+
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+type sinkMessage struct {
+	session *models.Session
+	event   *RaceEvent
+	points  []models.Point
+}
+
+// requiredSink marks a sink whose messages a Fanout must not drop
+type requiredSink struct {
+	Sink
+}
+
+// Required wraps a sink so a Fanout never drops its messages, the sink must publish quickly as it slows down every session.
+// The sink saving the points is required, the BatchWriter only appends to its pending batch.
+func Required(sink Sink) Sink {
+	return requiredSink{sink}
+}
+
+func NewFanout(sinks ...Sink) *Fanout {
+	f := &Fanout{}
+	for _, sink := range sinks {
+		required, ok := sink.(requiredSink)
+		if ok {
+			sink = required.Sink
+		}
+		s := &fanoutSink{
+			name:     fmt.Sprintf("%T", sink),
+			sink:     sink,
+			required: ok,
+			c:        make(chan sinkMessage, SINK_BUFFER),
+		}
+		f.sinks = append(f.sinks, s)
+
+		f.wg.Add(1)
+		go f.run(s)
+	}
+	return f
+}
+
+func (f *Fanout) run(s *fanoutSink) {
+	defer f.wg.Done()
+	for message := range s.c {
+		var err error
+		if message.session != nil {
+			err = s.sink.PublishSession(context.Background(), *message.session)
+		} else if message.event != nil {
+			err = s.sink.PublishRace(context.Background(), *message.event)
+		} else {
+			err = s.sink.PublishPoints(context.Background(), message.points)
+		}
+		if err != nil {
+			s.failed.Add(1)
+			slog.Error("failed publishing to sink", "error", err, "sink", s.name)
+		}
+	}
+}
+
+func (f *Fanout) publish(message sinkMessage) {
+	for _, s := range f.sinks {
+		if s.required {
+			s.c <- message
+			continue
+		}
+		select {
+		case s.c <- message:
+		default:
+			s.dropped.Add(1)
+			slog.Warn("sink buffer full", "sink", s.name)
+		}
+	}
+}
+
+func (f *Fanout) PublishSession(ctx context.Context, session models.Session) error {
+	f.publish(sinkMessage{session: &session})
+	return nil
+}
+
+func (f *Fanout) PublishRace(ctx context.Context, event RaceEvent) error {
+	f.publish(sinkMessage{event: &event})
+	return nil
+}
+
+func (f *Fanout) PublishPoints(ctx context.Context, points []models.Point) error {
+	// Sinks run later, copy once and share the copy between sinks
+	f.publish(sinkMessage{points: append([]models.Point(nil), points...)})
+	return nil
+}
+
+// Stats returns the count of failed and dropped messages per sink
+func (f *Fanout) Stats() []SinkStats {
+	stats := make([]SinkStats, 0, len(f.sinks))
+	for _, s := range f.sinks {
+		stats = append(stats, SinkStats{Sink: s.name, Failed: s.failed.Load(), Dropped: s.dropped.Load()})
+	}
+	return stats
+}
+
+// Close waits for the sinks to publish their buffered messages, then closes the sinks implementing io.Closer.
+// Nothing must be published after Close.
+func (f *Fanout) Close() error {
+	for _, s := range f.sinks {
+		close(s.c)
+	}
+	f.wg.Wait()
+
+	var errs []error
+	for _, s := range f.sinks {
+		if closer, ok := s.sink.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,256 @@
+package telemetry_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+)
+
+func eventTypes(events []telemetry.RaceEvent) []telemetry.RaceEventType {
+	var types []telemetry.RaceEventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestSessionSinkEvents(t *testing.T) {
+	sink := &telemetry.MemorySink{}
+	session := telemetry.NewSession(sink, telemetry.FORMAT_FM8)
+
+	for _, point := range []models.TelemetryPoint{
+		{OnTrack: 1, CurrentLap: 1, CurrentRaceTime: 1000},
+		{OnTrack: 0, CurrentLap: 1, CurrentRaceTime: 1000},
+		{OnTrack: 1, CurrentLap: 1, CurrentRaceTime: 2000},
+		{OnTrack: 1, CurrentLap: 1, CurrentRaceTime: 0.5},
+	} {
+		err := session.Add(point)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	err := session.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	events := sink.Events()
+	expected := []telemetry.RaceEventType{
+		telemetry.RACE_STARTED,
+		telemetry.RACE_UPDATED,
+		telemetry.RACE_PAUSED,
+		telemetry.RACE_RESUMED,
+		telemetry.RACE_UPDATED,
+		telemetry.RACE_ENDED,
+		telemetry.RACE_STARTED,
+		telemetry.RACE_UPDATED,
+		telemetry.RACE_ENDED,
+	}
+	if !reflect.DeepEqual(eventTypes(events), expected) {
+		t.Fatalf("expected %v got %v", expected, eventTypes(events))
+	}
+	if events[0].Race.ID == events[6].Race.ID {
+		t.Errorf("expected a new race got %v", events[6].Race.ID)
+	}
+	if events[5].Race.InProgress || events[5].Race.RaceTime != 2000 {
+		t.Errorf("expected the race ended at 2000 got %v", events[5].Race)
+	}
+
+	points := sink.Points()
+	if len(points) != 3 {
+		t.Fatalf("expected 3 got %v", len(points))
+	}
+	if points[2].Race != events[6].Race.ID {
+		t.Errorf("expected %v got %v", events[6].Race.ID, points[2].Race)
+	}
+}
+
+type blockingSink struct {
+	telemetry.MemorySink
+	release chan struct{}
+}
+
+func (s *blockingSink) PublishPoints(ctx context.Context, points []models.Point) error {
+	<-s.release
+	return s.MemorySink.PublishPoints(ctx, points)
+}
+
+type failingSink struct{}
+
+func (s failingSink) PublishSession(ctx context.Context, session models.Session) error {
+	return errors.New("failing")
+}
+
+func (s failingSink) PublishRace(ctx context.Context, event telemetry.RaceEvent) error {
+	return errors.New("failing")
+}
+
+func (s failingSink) PublishPoints(ctx context.Context, points []models.Point) error {
+	return errors.New("failing")
+}
+
+func TestFanout(t *testing.T) {
+	slow := &blockingSink{release: make(chan struct{})}
+	fast := &telemetry.MemorySink{}
+	fanout := telemetry.NewFanout(slow, fast, failingSink{})
+
+	// the slow sink doesn't stall the others
+	points := []models.Point{{TelemetryPoint: models.TelemetryPoint{CurrentLap: 1}}}
+	for i := range telemetry.SINK_BUFFER + 10 {
+		err := fanout.PublishPoints(context.Background(), points)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for len(fast.Points()) != i+1 && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Microsecond)
+		}
+		if len(fast.Points()) != i+1 {
+			t.Fatalf("expected %v got %v", i+1, len(fast.Points()))
+		}
+	}
+	// the published points are copied, the session reuses its slice
+	points[0].CurrentLap = 2
+
+	close(slow.release)
+	err := fanout.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, point := range fast.Points() {
+		if point.CurrentLap != 1 {
+			t.Fatalf("expected 1 got %v", point.CurrentLap)
+		}
+	}
+
+	stats := fanout.Stats()
+	if stats[0].Dropped == 0 || len(slow.Points())+int(stats[0].Dropped) != telemetry.SINK_BUFFER+10 {
+		t.Errorf("unexpected slow sink stats %v", stats[0])
+	}
+	if stats[1].Dropped != 0 || stats[1].Failed != 0 {
+		t.Errorf("unexpected fast sink stats %v", stats[1])
+	}
+	if stats[2].Failed+stats[2].Dropped != telemetry.SINK_BUFFER+10 {
+		t.Errorf("unexpected failing sink stats %v", stats[2])
+	}
+}
+
+func TestFanoutRequired(t *testing.T) {
+	slow := &blockingSink{release: make(chan struct{})}
+	fanout := telemetry.NewFanout(telemetry.Required(slow))
+
+	// publishing waits for the required sink instead of dropping
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for range telemetry.SINK_BUFFER + 10 {
+			fanout.PublishPoints(context.Background(), []models.Point{{}})
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-published:
+		t.Fatalf("expected publishing to wait for the sink")
+	default:
+	}
+
+	close(slow.release)
+	<-published
+	err := fanout.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(slow.Points()) != telemetry.SINK_BUFFER+10 {
+		t.Errorf("expected %v got %v", telemetry.SINK_BUFFER+10, len(slow.Points()))
+	}
+	stats := fanout.Stats()
+	if stats[0].Dropped != 0 || stats[0].Sink != "*telemetry_test.blockingSink" {
+		t.Errorf("unexpected stats %v", stats[0])
+	}
+}
+
+func TestServerPublishSessions(t *testing.T) {
+	sink := &telemetry.MemorySink{}
+	server := telemetry.NewServer("127.0.0.1:0", nil, time.Second)
+	server.AddSink(sink)
+	con := startServer(t, server)
+
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1)
+	for range 2 {
+		err := binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	shutdownServer(t, server)
+
+	sessions := sink.Sessions()
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 got %v", len(sessions))
+	}
+	if sessions[0].Source != con.LocalAddr().String() || !sessions[0].FinishedAt.IsZero() || sessions[0].Format != telemetry.FORMAT_FM8.Version {
+		t.Errorf("unexpected started session %+v", sessions[0])
+	}
+	if sessions[1].ID != sessions[0].ID || sessions[1].FinishedAt.IsZero() || sessions[1].Received != 2 {
+		t.Errorf("unexpected closed session %+v", sessions[1])
+	}
+
+	events := sink.Events()
+	if len(events) == 0 || events[0].Race.SessionID != sessions[0].ID {
+		t.Errorf("expected races of session %v got %v", sessions[0].ID, events)
+	}
+}
+
+func TestServerPublishSessionsCapture(t *testing.T) {
+	capture, err := telemetry.NewCapture(telemetry.CaptureConfig{Dir: t.TempDir(), All: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sink := &telemetry.MemorySink{}
+	server := telemetry.NewServer("127.0.0.1:0", nil, time.Second)
+	server.AddSink(sink)
+	server.SetCapture(capture)
+	con := startServer(t, server)
+
+	// the format change starts a new session of the same capture
+	var buf bytes.Buffer
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1)
+	err = binary.Write(&buf, binary.LittleEndian, point.TelemetryPoint)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, size := range []int{telemetry.FM8_PACKET_SIZE, telemetry.FM7_SLED_PACKET_SIZE} {
+		_, err = con.Write(buf.Bytes()[:size])
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	shutdownServer(t, server)
+
+	ids := map[uuid.UUID]string{}
+	for _, session := range sink.Sessions() {
+		if session.Capture == uuid.Nil || session.Capture == session.ID {
+			t.Errorf("unexpected capture of session %+v", session)
+		}
+		if format, ok := ids[session.ID]; ok && format != session.Format {
+			t.Errorf("expected %v got %v", format, session.Format)
+		}
+		ids[session.ID] = session.Format
+	}
+	if len(ids) != 2 {
+		t.Errorf("expected 2 sessions got %v", ids)
+	}
+}
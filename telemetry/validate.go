@@ -0,0 +1,124 @@
+package telemetry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"forzatelemetry/models"
+)
+
+// Reasons a packet is rejected
+const (
+	REASON_LENGTH            = "length"
+	REASON_DECODE            = "decode"
+	REASON_CAR_CLASS         = "carClass"
+	REASON_PERFORMANCE_INDEX = "performanceIndex"
+	REASON_NOT_FINITE        = "notFinite"
+)
+
+// Only keep track of that many sources, others are counted together as REJECTIONS_OTHER_SOURCE
+const MAX_REJECTION_SOURCES = 1024
+const REJECTIONS_OTHER_SOURCE = "other"
+
+type InvalidPacketError struct {
+	Reason string
+	Msg    string
+	err    error
+}
+
+func (e *InvalidPacketError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("invalid packet: %s: %v", e.Msg, e.err)
+	}
+	return fmt.Sprintf("invalid packet: %s", e.Msg)
+}
+
+func (e *InvalidPacketError) Unwrap() error {
+	return e.err
+}
+
+// Validate checks the values of a decoded packet are sane.
+// The car is only checked while racing, the game sends zeros in menus.
+func Validate(packet Packet) error {
+	if packet.OnTrack != 0 {
+		if packet.CarClass < 0 || packet.CarClass >= int32(len(models.CarClasses)) {
+			return &InvalidPacketError{Reason: REASON_CAR_CLASS, Msg: fmt.Sprintf("car class %d out of range", packet.CarClass)}
+		}
+		if packet.CarPerformanceIndex < 100 || packet.CarPerformanceIndex > 999 {
+			return &InvalidPacketError{Reason: REASON_PERFORMANCE_INDEX, Msg: fmt.Sprintf("performance index %d out of range", packet.CarPerformanceIndex)}
+		}
+	}
+
+	if field := notFiniteField(&packet.TelemetryPoint); field != "" {
+		return &InvalidPacketError{Reason: REASON_NOT_FINITE, Msg: fmt.Sprintf("%s is not finite", field)}
+	}
+	return nil
+}
+
+type Rejection struct {
+	Source string    `json:"source"`
+	Reason string    `json:"reason"`
+	Count  uint64    `json:"count"`
+	LastAt time.Time `json:"lastAt"`
+}
+
+type rejectionKey struct {
+	source string
+	reason string
+}
+
+// Rejections counts the rejected packets per source address and reason
+type Rejections struct {
+	m       sync.Mutex
+	counts  map[rejectionKey]*Rejection
+	sources map[string]struct{}
+}
+
+func NewRejections() *Rejections {
+	return &Rejections{
+		counts:  make(map[rejectionKey]*Rejection),
+		sources: make(map[string]struct{}),
+	}
+}
+
+func (r *Rejections) Add(source string, reason string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, ok := r.sources[source]; !ok {
+		if len(r.sources) >= MAX_REJECTION_SOURCES {
+			source = REJECTIONS_OTHER_SOURCE
+		} else {
+			r.sources[source] = struct{}{}
+		}
+	}
+
+	key := rejectionKey{source: source, reason: reason}
+	rejection, ok := r.counts[key]
+	if !ok {
+		rejection = &Rejection{Source: source, Reason: reason}
+		r.counts[key] = rejection
+	}
+	rejection.Count++
+	rejection.LastAt = time.Now()
+}
+
+// List returns a copy of the counters, ordered by source and reason
+func (r *Rejections) List() []Rejection {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rejections := make([]Rejection, 0, len(r.counts))
+	for _, rejection := range r.counts {
+		rejections = append(rejections, *rejection)
+	}
+	sort.Slice(rejections, func(i, j int) bool {
+		if rejections[i].Source == rejections[j].Source {
+			return rejections[i].Reason < rejections[j].Reason
+		}
+		return rejections[i].Source < rejections[j].Source
+	})
+	return rejections
+}
@@ -0,0 +1,95 @@
+package telemetry_test
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+)
+
+type validateRun struct {
+	update func(*models.TelemetryPoint)
+	reason string
+}
+
+func TestValidate(t *testing.T) {
+	runs := map[string]validateRun{
+		"ok":                    {update: func(p *models.TelemetryPoint) {}},
+		"carClass":              {update: func(p *models.TelemetryPoint) { p.CarClass = 9 }, reason: telemetry.REASON_CAR_CLASS},
+		"negativeCarClass":      {update: func(p *models.TelemetryPoint) { p.CarClass = -1 }, reason: telemetry.REASON_CAR_CLASS},
+		"performanceIndexLow":   {update: func(p *models.TelemetryPoint) { p.CarPerformanceIndex = 99 }, reason: telemetry.REASON_PERFORMANCE_INDEX},
+		"performanceIndexHigh":  {update: func(p *models.TelemetryPoint) { p.CarPerformanceIndex = 1000 }, reason: telemetry.REASON_PERFORMANCE_INDEX},
+		"notOnTrack":            {update: func(p *models.TelemetryPoint) { p.OnTrack = 0; p.CarClass = 0; p.CarPerformanceIndex = 0 }},
+		"nan":                   {update: func(p *models.TelemetryPoint) { p.Speed = float32(math.NaN()) }, reason: telemetry.REASON_NOT_FINITE},
+		"inf":                   {update: func(p *models.TelemetryPoint) { p.TireWearRearRight = float32(math.Inf(-1)) }, reason: telemetry.REASON_NOT_FINITE},
+		"notOnTrackStillFinite": {update: func(p *models.TelemetryPoint) { p.OnTrack = 0; p.Fuel = float32(math.Inf(1)) }, reason: telemetry.REASON_NOT_FINITE},
+	}
+
+	for name, run := range runs {
+		t.Run(name, func(t *testing.T) {
+			point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1).TelemetryPoint
+			run.update(&point)
+
+			err := telemetry.Validate(telemetry.Packet{TelemetryPoint: point, Format: telemetry.FORMAT_FM8})
+			if run.reason == "" {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+
+			var invalid *telemetry.InvalidPacketError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("expected %T got %v", invalid, err)
+			}
+			if invalid.Reason != run.reason {
+				t.Errorf("expected %v got %v", run.reason, invalid.Reason)
+			}
+		})
+	}
+}
+
+// Every float field is checked, a field added to TelemetryPoint must be added to the checks
+func TestValidateEveryFloat(t *testing.T) {
+	typ := reflect.TypeOf(models.TelemetryPoint{})
+	for i := range typ.NumField() {
+		if typ.Field(i).Type.Kind() != reflect.Float32 {
+			continue
+		}
+		point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1).TelemetryPoint
+		reflect.ValueOf(&point).Elem().Field(i).SetFloat(math.NaN())
+
+		err := telemetry.Validate(telemetry.Packet{TelemetryPoint: point, Format: telemetry.FORMAT_FM8})
+		var invalid *telemetry.InvalidPacketError
+		if !errors.As(err, &invalid) || invalid.Reason != telemetry.REASON_NOT_FINITE || invalid.Msg != typ.Field(i).Name+" is not finite" {
+			t.Errorf("%s: expected %v got %v", typ.Field(i).Name, telemetry.REASON_NOT_FINITE, err)
+		}
+	}
+}
+
+func TestRejections(t *testing.T) {
+	rejections := telemetry.NewRejections()
+	rejections.Add("10.0.0.2:1000", telemetry.REASON_LENGTH)
+	rejections.Add("10.0.0.1:1000", telemetry.REASON_LENGTH)
+	rejections.Add("10.0.0.1:1000", telemetry.REASON_LENGTH)
+	rejections.Add("10.0.0.1:1000", telemetry.REASON_CAR_CLASS)
+
+	var result [][]any
+	for _, rejection := range rejections.List() {
+		result = append(result, []any{rejection.Source, rejection.Reason, rejection.Count})
+	}
+
+	expected := [][]any{
+		{"10.0.0.1:1000", telemetry.REASON_CAR_CLASS, uint64(1)},
+		{"10.0.0.1:1000", telemetry.REASON_LENGTH, uint64(2)},
+		{"10.0.0.2:1000", telemetry.REASON_LENGTH, uint64(1)},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v got %v", expected, result)
+	}
+}
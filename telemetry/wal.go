@@ -0,0 +1,174 @@
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+)
+
+// The write-ahead log keeps the points of a session until they are saved in the database. Each batch of points
+// between two checkpoints is a segment file named after its first point, deleted once the batch is inserted.
+// Segments left by a crash or a failed insert are replayed when the server starts.
+// A record is the point in the Forza Motorsport 8 layout, its race ID and its creation time in unix nanoseconds.
+const WAL_EXTENSION = ".wal"
+const WAL_RECORD_SIZE = FM8_PACKET_SIZE + 16 + 8
+
+// Records are buffered until the segment is flushed, by the flush ticker of the session or at checkpoint
+const WAL_BUFFER_SIZE = 64 * 1024
+
+type WAL struct {
+	dir string
+}
+
+func NewWAL(dir string) (*WAL, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating wal directory: %w", err)
+	}
+	return &WAL{dir: dir}, nil
+}
+
+func (w *WAL) segmentPath(first models.Point) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%s-%d%s", first.Race, first.CreatedAt.UnixNano(), WAL_EXTENSION))
+}
+
+type walSegment struct {
+	file   *os.File
+	w      *bufio.Writer
+	record [WAL_RECORD_SIZE]byte
+}
+
+func (w *WAL) open(first models.Point) (*walSegment, error) {
+	file, err := os.OpenFile(w.segmentPath(first), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating wal segment: %w", err)
+	}
+	return &walSegment{file: file, w: bufio.NewWriterSize(file, WAL_BUFFER_SIZE)}, nil
+}
+
+// Append buffers the record of the point, it survives a crash of the process once the segment is flushed.
+// Encoded field by field like decodeLayout, it's called for every packet.
+func (s *walSegment) Append(point models.Point) error {
+	encodeLayout(&point.TelemetryPoint, s.record[:])
+	copy(s.record[FM8_PACKET_SIZE:], point.Race[:])
+	binary.LittleEndian.PutUint64(s.record[FM8_PACKET_SIZE+16:], uint64(point.CreatedAt.UnixNano()))
+	_, err := s.w.Write(s.record[:])
+	return err
+}
+
+func (s *walSegment) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *walSegment) Close() error {
+	return errors.Join(s.w.Flush(), s.file.Close())
+}
+
+// Remove deletes the segment of a batch of points once they are saved
+func (w *WAL) Remove(points []models.Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	err := os.Remove(w.segmentPath(points[0]))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Segments returns the paths of the segments, oldest first
+func (w *WAL) Segments() ([]string, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), WAL_EXTENSION) {
+			segments = append(segments, filepath.Join(w.dir, entry.Name()))
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segmentTime(segments[i]) < segmentTime(segments[j]) })
+	return segments, nil
+}
+
+func segmentTime(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), WAL_EXTENSION)
+	i := strings.LastIndex(name, "-")
+	// pad to compare the timestamps as strings
+	return fmt.Sprintf("%020s", name[i+1:])
+}
+
+// ReadWALSegment returns the points of a segment. A truncated last record, written during a crash, is ignored.
+func ReadWALSegment(path string) ([]models.Point, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var points []models.Point
+	r := bufio.NewReader(f)
+	record := make([]byte, WAL_RECORD_SIZE)
+	for {
+		_, err := io.ReadFull(r, record)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return points, nil
+		} else if err != nil {
+			return points, err
+		}
+
+		var point models.Point
+		err = FORMAT_FM8.Decode(record[:FM8_PACKET_SIZE], &point.TelemetryPoint)
+		if err != nil {
+			return points, err
+		}
+		point.Race = uuid.UUID(record[FM8_PACKET_SIZE : FM8_PACKET_SIZE+16])
+		point.CreatedAt = time.Unix(0, int64(binary.LittleEndian.Uint64(record[FM8_PACKET_SIZE+16:])))
+		points = append(points, point)
+	}
+}
+
+// Recover publishes the points of the segments left by a previous run to sink, then deletes the segments.
+// Must be called before sessions start writing to the WAL.
+func (w *WAL) Recover(ctx context.Context, sink Sink) (int, error) {
+	segments, err := w.Segments()
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, segment := range segments {
+		points, err := ReadWALSegment(segment)
+		if err != nil {
+			return recovered, fmt.Errorf("failed reading wal segment %s: %w", segment, err)
+		}
+		if len(points) > 0 {
+			err = sink.PublishPoints(ctx, points)
+			if err != nil {
+				return recovered, fmt.Errorf("failed recovering wal segment %s: %w", segment, err)
+			}
+		}
+		err = os.Remove(segment)
+		if err != nil {
+			return recovered, err
+		}
+		slog.Info("recovered wal segment", "path", segment, "points", len(points))
+		recovered += len(points)
+	}
+	return recovered, nil
+}
@@ -0,0 +1,185 @@
+package telemetry_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+)
+
+func startServer(t *testing.T, server *telemetry.Server) net.Conn {
+	go func() {
+		err := server.ListenAndProcess()
+		if !errors.Is(err, telemetry.ErrServerClosed) {
+			t.Errorf("expected %v got %v", telemetry.ErrServerClosed, err)
+		}
+	}()
+
+	addr := server.Addr()
+	for addr == nil {
+		time.Sleep(10 * time.Microsecond)
+		addr = server.Addr()
+	}
+
+	con, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return con
+}
+
+func shutdownServer(t *testing.T, server *telemetry.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestServerWAL(t *testing.T) {
+	store := testutils.NewStore()
+	defer store.Close()
+
+	dir := t.TempDir()
+	wal, err := telemetry.NewWAL(dir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// without a store nothing removes the segments, as if the server crashed before saving the points
+	flushInterval := 200 * time.Millisecond
+	server := telemetry.NewServer("127.0.0.1:0", nil, flushInterval)
+	server.AddSink(&telemetry.MemorySink{})
+	server.SetWAL(wal)
+	con := startServer(t, server)
+
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1)
+	for i := range 3 {
+		point.CurrentRaceTime = float32(i + 1)
+		err = binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	time.Sleep(flushInterval / 4)
+
+	// the points are buffered until the checkpoint
+	segments, err := wal.Segments()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("expected 1 got %v", len(segments))
+	}
+	points, err := telemetry.ReadWALSegment(segments[0])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(points) != 0 {
+		t.Fatalf("expected 0 points got %v", points)
+	}
+
+	time.Sleep(flushInterval)
+	points, err = telemetry.ReadWALSegment(segments[0])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(points) != 3 || points[2].TelemetryPoint != point.TelemetryPoint {
+		t.Fatalf("expected 3 points got %v", points)
+	}
+	shutdownServer(t, server)
+
+	crashDir := t.TempDir()
+	data, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// a record partially written during the crash
+	data = append(data, 1, 2, 3)
+	err = os.WriteFile(filepath.Join(crashDir, filepath.Base(segments[0])), data, 0o644)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	crashWAL, err := telemetry.NewWAL(crashDir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sink := &telemetry.MemorySink{}
+	recovered, err := crashWAL.Recover(context.Background(), sink)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if recovered != 3 || len(sink.Points()) != 3 {
+		t.Errorf("expected 3 got %v %v", recovered, len(sink.Points()))
+	}
+	if sink.Points()[0].Race != points[0].Race || !sink.Points()[0].CreatedAt.Equal(points[0].CreatedAt) {
+		t.Errorf("expected %v got %v", points[0], sink.Points()[0])
+	}
+	segments, err = crashWAL.Segments()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(segments) != 0 {
+		t.Errorf("expected 0 got %v", segments)
+	}
+
+	// the segments are deleted once the points are saved
+	walDir := t.TempDir()
+	wal, err = telemetry.NewWAL(walDir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	server = telemetry.NewServer("127.0.0.1:0", store, flushInterval)
+	server.SetWAL(wal)
+	con = startServer(t, server)
+	err = binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	time.Sleep(2 * flushInterval)
+	shutdownServer(t, server)
+	segments, err = wal.Segments()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(segments) != 0 {
+		t.Errorf("expected 0 got %v", segments)
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	store := testutils.NewStore()
+	defer store.Close()
+
+	flushInterval := 100 * time.Millisecond
+	server := telemetry.NewServer("127.0.0.1:0", store, flushInterval)
+	server.SetIdleTimeout(5 * flushInterval)
+	con := startServer(t, server)
+	defer shutdownServer(t, server)
+
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1)
+	err := binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// silent for a few flush intervals, the session is kept
+	time.Sleep(3 * flushInterval)
+	if len(server.Listeners()) != 1 {
+		t.Fatalf("expected 1 got %v", len(server.Listeners()))
+	}
+
+	time.Sleep(5 * flushInterval)
+	if len(server.Listeners()) != 0 {
+		t.Fatalf("expected 0 got %v", len(server.Listeners()))
+	}
+}
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"forzatelemetry/models"
+)
+
+func (h *Handler) drivers(w http.ResponseWriter, r *http.Request) {
+	drivers, err := h.db.SelectDrivers(r.Context())
+	if err != nil {
+		Render(w, r, StorageErrorRenderer(err))
+		return
+	}
+
+	Render(w, r, DriversRenderer{Count: len(drivers), Items: drivers, TemplateData: NewTemplateData(r)})
+}
+
+type DriversRenderer struct {
+	TemplateData `json:"-"`
+	Renderer     `json:"-"`
+
+	Count int             `json:"count"`
+	Items []models.Driver `json:"items"`
+}
+
+func (rd DriversRenderer) HTML(w http.ResponseWriter, r *http.Request) string {
+	return RenderTemplate(r, "drivers.html", rd)
+}
+
+type putDriverRequest struct {
+	Sources []string `json:"sources"`
+}
+
+// putDriver creates the driver or replaces its sources
+func (h *Handler) putDriver(w http.ResponseWriter, r *http.Request) {
+	var body putDriverRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		Render(w, r, NewErrorRenderer(http.StatusBadRequest, "invalid body", err, nil))
+		return
+	}
+
+	driver := models.Driver{Name: chi.URLParam(r, "name")}
+	for _, source := range body.Sources {
+		source, err = models.ParseSource(source)
+		if err != nil {
+			Render(w, r, NewErrorRenderer(http.StatusBadRequest, "invalid source", err, nil))
+			return
+		}
+		driver.Sources = append(driver.Sources, models.DriverSource{Source: source})
+	}
+
+	err = h.db.UpsertDrivers(r.Context(), driver)
+	if err != nil {
+		Render(w, r, StorageErrorRenderer(err))
+		return
+	}
+
+	h.refreshDrivers(r)
+	h.drivers(w, r)
+}
+
+func (h *Handler) deleteDriver(w http.ResponseWriter, r *http.Request) {
+	err := h.db.DeleteDriver(chi.URLParam(r, "name"), r.Context())
+	if err != nil {
+		Render(w, r, StorageErrorRenderer(err))
+		return
+	}
+
+	h.refreshDrivers(r)
+	h.drivers(w, r)
+}
+
+// refreshDrivers makes the telemetry server bind the new sessions to the drivers saved
+func (h *Handler) refreshDrivers(r *http.Request) {
+	if h.telemetry == nil {
+		return
+	}
+	err := h.telemetry.RefreshDrivers(r.Context())
+	if err != nil {
+		slog.Error("failed refreshing drivers", "error", err)
+	}
+}
@@ -0,0 +1,126 @@
+package web_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"forzatelemetry/models"
+	"forzatelemetry/testutils"
+	"forzatelemetry/web"
+)
+
+type getDriversResponse struct {
+	Count int             `json:"count"`
+	Items []models.Driver `json:"items"`
+}
+
+func TestDrivers(t *testing.T) {
+	db := testutils.NewStore("drivers.yaml")
+	defer db.Close()
+
+	router := web.Router(db, nil, "version", "https://localhost", "secret")
+
+	req, err := http.NewRequest("PUT", "/drivers/carol", strings.NewReader(`{"sources": ["10.0.0.2", "10.0.0.3:5000"]}`))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	resp := testutils.ExecuteRequest(req, router)
+	if resp.Code != 401 {
+		t.Fatalf("expected 401 got %v", resp.Code)
+	}
+
+	req, err = http.NewRequest("PUT", "/drivers/carol", strings.NewReader(`{"sources": ["10.0.0.2", "10.0.0.3:5000"]}`))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	req.SetBasicAuth("admin", "secret")
+	resp = testutils.ExecuteRequest(req, router)
+	if resp.Code != 200 {
+		t.Fatalf("expected 200 got %v", resp.Code)
+	}
+
+	var respData getDriversResponse
+	err = json.NewDecoder(resp.Body).Decode(&respData)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if respData.Count != 3 || respData.Items[2].Name != "carol" || len(respData.Items[2].Sources) != 2 {
+		t.Errorf("unexpected drivers %+v", respData.Items)
+	}
+
+	for _, source := range []string{"", "localhost", "10.0.0.256", "10.0.0.2:port", "10.0.0.0/24"} {
+		req, err = http.NewRequest("PUT", "/drivers/dave", strings.NewReader(`{"sources": ["`+source+`"]}`))
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		req.SetBasicAuth("admin", "secret")
+		resp = testutils.ExecuteRequest(req, router)
+		if resp.Code != 400 {
+			t.Errorf("%s: expected 400 got %v", source, resp.Code)
+		}
+	}
+
+	req, err = http.NewRequest("DELETE", "/drivers/bob", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	req.SetBasicAuth("admin", "secret")
+	resp = testutils.ExecuteRequest(req, router)
+	if resp.Code != 200 {
+		t.Fatalf("expected 200 got %v", resp.Code)
+	}
+
+	req, err = http.NewRequest("DELETE", "/drivers/bob", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	req.SetBasicAuth("admin", "secret")
+	resp = testutils.ExecuteRequest(req, router)
+	if resp.Code != 404 {
+		t.Fatalf("expected 404 got %v", resp.Code)
+	}
+
+	req, err = http.NewRequest("GET", "/drivers", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	req.Header.Set("Accept", "text/html")
+	resp = testutils.ExecuteRequest(req, router)
+	if !strings.Contains(resp.Body.String(), "10.0.0.3:5000") || strings.Contains(resp.Body.String(), "bob") {
+		t.Errorf("unexpected drivers in %v", resp.Body.String())
+	}
+}
+
+func TestGetRacesDriver(t *testing.T) {
+	db := testutils.NewStore("races.yaml", "drivers.yaml")
+	defer db.Close()
+
+	router := web.Router(db, nil, "version", "https://localhost", "")
+
+	req, err := http.NewRequest("GET", "/races?filter=driver:in:1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	resp := testutils.ExecuteRequest(req, router)
+	if resp.Code != 200 {
+		t.Fatalf("expected 200 got %v", resp.Code)
+	}
+
+	var respData getRacesResponse
+	err = json.NewDecoder(resp.Body).Decode(&respData)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if respData.Count != 1 || respData.Items[0].DriverMetadata.Name != "alice" {
+		t.Fatalf("unexpected races %+v", respData.Items)
+	}
+
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("HX-Request", "true")
+	resp = testutils.ExecuteRequest(req, router)
+	if !strings.Contains(resp.Body.String(), "alice") {
+		t.Errorf("expected driver in %v", resp.Body.String())
+	}
+}
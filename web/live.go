@@ -0,0 +1,139 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"forzatelemetry/models"
+)
+
+// Comment sent to keep idle connections open
+const LIVE_KEEPALIVE_INTERVAL = 15 * time.Second
+
+// live streams the points of an in progress race as Server-Sent Events, as they are received by the telemetry server.
+// Query parameters:
+//   - every: only send one point out of every N
+//   - channels: comma separated list of the fields of models.ApiPoint to send, all by default
+func (h *Handler) live(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		Render(w, r, NewErrorRenderer(http.StatusBadRequest, "invalid race id", err, nil))
+		return
+	}
+
+	every := 1
+	if value := r.URL.Query().Get("every"); value != "" {
+		every, err = strconv.Atoi(value)
+		if err != nil || every < 1 {
+			Render(w, r, NewErrorRenderer(http.StatusBadRequest, "invalid every", err, map[string]any{"every": value}))
+			return
+		}
+	}
+
+	channels, errRd := parseChannels(r.URL.Query().Get("channels"))
+	if errRd != nil {
+		Render(w, r, errRd)
+		return
+	}
+
+	if h.telemetry == nil {
+		Render(w, r, NewErrorRenderer(http.StatusNotFound, "live telemetry not available", nil, nil))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		Render(w, r, NewErrorRenderer(http.StatusInternalServerError, "streaming not supported", fmt.Errorf("%T is not a http.Flusher", w), nil))
+		return
+	}
+
+	// Subscribed before reading the race, a race ending in between closes the subscription and sends the end event
+	hub := h.telemetry.Live()
+	sub := hub.Subscribe(id)
+	defer hub.Unsubscribe(sub)
+
+	race, err := h.db.SelectRace(id.String(), r.Context(), h.dashboardBaseUrl)
+	if err != nil {
+		Render(w, r, StorageErrorRenderer(err))
+		return
+	}
+	if !race.InProgress {
+		Render(w, r, NewErrorRenderer(http.StatusNotFound, "race not in progress", nil, nil))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(LIVE_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+
+	received := 0
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case point, ok := <-sub.C:
+			if !ok {
+				fmt.Fprint(w, "event: end\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			received++
+			if (received-1)%every != 0 {
+				continue
+			}
+			data, err := json.Marshal(selectChannels(point, channels))
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: point\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// parseChannels returns the fields of models.ApiPoint named in a comma separated list, all the fields when empty
+func parseChannels(value string) ([]protoreflect.FieldDescriptor, *ErrorRenderer) {
+	fields := (&models.ApiPoint{}).ProtoReflect().Descriptor().Fields()
+
+	var channels []protoreflect.FieldDescriptor
+	if value == "" {
+		for i := range fields.Len() {
+			channels = append(channels, fields.Get(i))
+		}
+		return channels, nil
+	}
+
+	for _, name := range strings.Split(value, ",") {
+		field := fields.ByJSONName(strings.TrimSpace(name))
+		if field == nil {
+			return nil, NewErrorRenderer(http.StatusBadRequest, "invalid channel", nil, map[string]any{"channel": name})
+		}
+		channels = append(channels, field)
+	}
+	return channels, nil
+}
+
+func selectChannels(point models.Point, channels []protoreflect.FieldDescriptor) map[string]any {
+	message := point.ToProto().ProtoReflect()
+	values := make(map[string]any, len(channels))
+	for _, field := range channels {
+		values[field.JSONName()] = message.Get(field).Interface()
+	}
+	return values
+}
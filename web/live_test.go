@@ -0,0 +1,133 @@
+package web_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+	"forzatelemetry/web"
+)
+
+const LIVE_RACE = "db1e4ffd-9476-48fc-b611-154cfc9e7c02"
+
+func TestLiveErrors(t *testing.T) {
+	db := testutils.NewStore("races.yaml")
+	defer db.Close()
+
+	server := telemetry.NewServer("127.0.0.1:0", nil, 5*time.Second)
+
+	runs := []struct {
+		path   string
+		server *telemetry.Server
+		status int
+	}{
+		{"/races/invalid/live", server, 400},
+		{"/races/" + LIVE_RACE + "/live?every=0", server, 400},
+		{"/races/" + LIVE_RACE + "/live?channels=speed,invalid", server, 400},
+		{"/races/" + LIVE_RACE + "/live", nil, 404},
+		{"/races/0abd1c08-5bd1-4ab2-a5e8-3c2b1b4c1a1d/live", server, 404},
+		{"/races/44e22d85-3883-4552-9ff4-91a7211e0639/live", server, 404},
+	}
+	for _, run := range runs {
+		t.Run(run.path, func(t *testing.T) {
+			router := web.Router(db, run.server, "version", "https://localhost", "")
+			req, err := http.NewRequest("GET", run.path, nil)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			resp := testutils.ExecuteRequest(req, router)
+			if resp.Code != run.status {
+				t.Errorf("expected %v got %v", run.status, resp.Code)
+			}
+		})
+	}
+}
+
+func TestLive(t *testing.T) {
+	db := testutils.NewStore("races.yaml")
+	defer db.Close()
+
+	server := telemetry.NewServer("127.0.0.1:0", nil, 5*time.Second)
+	httpServer := httptest.NewServer(web.Router(db, server, "version", "https://localhost", ""))
+	defer httpServer.Close()
+
+	resp, err := http.Get(httpServer.URL + "/races/" + LIVE_RACE + "/live?every=2&channels=speed,gear")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200 got %v", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("expected text/event-stream got %v", resp.Header.Get("Content-Type"))
+	}
+
+	race := testutils.ParseUUID(LIVE_RACE)
+	for server.Live().Subscribers(race) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := range 4 {
+		server.Live().Publish(models.Point{
+			TelemetryPoint: models.TelemetryPoint{Speed: float32(i), Gear: uint8(i), Fuel: 1},
+			Race:           race,
+		})
+	}
+	// points of other races are not sent
+	server.Live().Publish(models.Point{Race: testutils.ParseUUID("44e22d85-3883-4552-9ff4-91a7211e0639")})
+	server.Live().End(race)
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+	}
+
+	expected := []string{
+		"event: point",
+		`data: {"gear":0,"speed":0}`,
+		"event: point",
+		`data: {"gear":2,"speed":2}`,
+		"event: end",
+		"data: {}",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %v got %v", expected, lines)
+	}
+}
+
+// The race ended before the request, still in progress in the database, the stream ends at once
+func TestLiveEnded(t *testing.T) {
+	db := testutils.NewStore("races.yaml")
+	defer db.Close()
+
+	server := telemetry.NewServer("127.0.0.1:0", nil, 5*time.Second)
+	httpServer := httptest.NewServer(web.Router(db, server, "version", "https://localhost", ""))
+	defer httpServer.Close()
+	server.Live().End(testutils.ParseUUID(LIVE_RACE))
+
+	resp, err := http.Get(httpServer.URL + "/races/" + LIVE_RACE + "/live")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200 got %v", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if string(body) != "event: end\ndata: {}\n\n" {
+		t.Errorf("expected the end event got %q", body)
+	}
+}
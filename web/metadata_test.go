@@ -15,7 +15,7 @@ func TestTracks(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	router := web.Router(db, "version", "https://localhost")
+	router := web.Router(db, nil, "version", "https://localhost", "")
 
 	req, err := http.NewRequest("GET", "/metadata/tracks", nil)
 	if err != nil {
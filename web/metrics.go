@@ -0,0 +1,35 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"forzatelemetry/metrics"
+)
+
+var httpDuration = metrics.NewHistogram(metrics.Default, "forzatelemetry_http_request_duration_seconds", "Duration of HTTP requests, per route.", metrics.DURATION_BUCKETS, "method", "route", "status")
+
+// routeMetrics measures the duration of requests, labelled with the chi route pattern to keep a bounded number of series
+func routeMetrics(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpDuration.Observe(time.Since(start).Seconds(), r.Method, route, strconv.Itoa(status))
+	}
+
+	return http.HandlerFunc(fn)
+}
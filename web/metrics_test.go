@@ -0,0 +1,49 @@
+package web_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"forzatelemetry/testutils"
+	"forzatelemetry/web"
+)
+
+func TestMetrics(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	router := web.Router(db, nil, "version", "https://localhost", "")
+
+	req, err := http.NewRequest("GET", "/races/invalid/live", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	testutils.ExecuteRequest(req, router)
+
+	req, err = http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	resp := testutils.ExecuteRequest(req, router)
+	if resp.Code != 200 {
+		t.Fatalf("expected 200 got %v", resp.Code)
+	}
+	if !strings.HasPrefix(resp.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected text/plain got %v", resp.Header().Get("Content-Type"))
+	}
+
+	body := resp.Body.String()
+	for _, expected := range []string{
+		`forzatelemetry_http_request_duration_seconds_count{method="GET",route="/races/{id}/live",status="400"}`,
+		"# TYPE forzatelemetry_packets_received_total counter",
+		"# TYPE forzatelemetry_sessions_active gauge",
+		"# TYPE forzatelemetry_checkpoint_duration_seconds histogram",
+		"# TYPE forzatelemetry_db_errors_total counter",
+		"# TYPE forzatelemetry_cleanup_runs_total counter",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %v in %v", expected, body)
+		}
+	}
+}
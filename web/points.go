@@ -20,7 +20,11 @@ func (h *Handler) points(w http.ResponseWriter, r *http.Request) {
 			streamer.Fail(err)
 			return
 		}
-		streamer.Send(point.ToProto())
+		// The query is canceled with the request context when the client disconnects
+		err = streamer.Send(point.ToProto())
+		if err != nil {
+			return
+		}
 	}
 }
 
@@ -52,9 +56,12 @@ func (rd *protobufStreamer) Send(v *models.ApiPoint) error {
 
 	size := make([]byte, 4)
 	binary.LittleEndian.PutUint32(size, uint32(len(data)))
-	rd.w.Write(size)
-	rd.w.Write(data)
-	return nil
+	_, err = rd.w.Write(size)
+	if err != nil {
+		return err
+	}
+	_, err = rd.w.Write(data)
+	return err
 }
 
 func (rd *protobufStreamer) Fail(err error) {
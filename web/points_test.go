@@ -23,7 +23,7 @@ func TestStreamRacePointsHistory(t *testing.T) {
 	db := testutils.NewStore("races.yaml", "points.yaml")
 	defer db.Close()
 
-	router := web.Router(db, "version", "https://localhost")
+	router := web.Router(db, nil, "version", "https://localhost", "")
 
 	runs := map[string]testStreamRacePointsHistoryRun{
 		"ok":       {code: 200, raceID: "44e22d85-3883-4552-9ff4-91a7211e0639", result: []byte{0, 0, 0, 0, 7, 0, 0, 0, 13, 0, 0, 240, 66, 24, 1, 7, 0, 0, 0, 13, 0, 0, 112, 67, 24, 2}},
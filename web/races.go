@@ -1,20 +1,29 @@
 package web
 
 import (
+	"errors"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
 
 	"forzatelemetry/models"
+	"forzatelemetry/storage"
 )
 
 var RacesFilters = []Filter{
 	MakeFilter("inProgress", "races.in_progress", "bool", []string{"eq", "neq"}, "inProgress:eq:true"),
 	MakeFilter("paused", "races.paused", "bool", []string{"eq", "neq"}, "paused:neq:true"),
+	MakeFilter("pinned", "races.pinned", "bool", []string{"eq", "neq"}, "pinned:eq:true"),
+	MakeFilter("archived", "races.archived", "bool", []string{"eq", "neq"}, "archived:eq:true"),
 	MakeFilter("carClass", "races.car_class", "[]int", []string{"in"}, "carClass:in:1,2"),
 	MakeFilter("carPI", "races.car_performance_index", "int32", []string{"eq", "neq", "gt", "ge", "lt", "le"}, "carPI:gt:100"),
 	MakeFilter("track", "races.track", "int32", []string{"eq", "neq"}, "track:eq:2"),
+	MakeFilter("game", "races.game", "[]string", []string{"in"}, "game:in:fm8,fh"),
+	MakeFilter("driver", "races.driver", "[]int", []string{"in"}, "driver:in:1,2"),
 	MakeFilter("startedAt", "races.started_at", "time", []string{"gt", "lt"}, "startedAt:gt:1725479276147"),
 	MakeFilter("finishedAt", "races.finished_at", "time", []string{"gt", "lt"}, "finishedAt:gt:1725479276147"),
 }
@@ -35,7 +44,21 @@ func (h *Handler) races(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	Render(w, r, RacesRenderer{Count: count, Items: races})
+	// The HTML page groups the races under the headers of their sessions
+	var sessions []models.Session
+	if render.GetAcceptedContentType(r) == render.ContentTypeHTML {
+		ids := make([]uuid.UUID, 0, len(races))
+		for _, race := range races {
+			ids = append(ids, race.SessionID)
+		}
+		sessions, err = h.db.SelectSessions(ids, r.Context())
+		if err != nil {
+			Render(w, r, StorageErrorRenderer(err))
+			return
+		}
+	}
+
+	Render(w, r, RacesRenderer{Count: count, Items: races, sessions: sessions})
 }
 
 type RacesRenderer struct {
@@ -43,6 +66,8 @@ type RacesRenderer struct {
 
 	Count int              `json:"count"`
 	Items []models.APIRace `json:"items"`
+
+	sessions []models.Session // sessions of the races, only loaded for HTML
 }
 
 func (rd RacesRenderer) HTML(w http.ResponseWriter, r *http.Request) string {
@@ -50,6 +75,7 @@ func (rd RacesRenderer) HTML(w http.ResponseWriter, r *http.Request) string {
 		TemplateData: NewTemplateData(r),
 		Count:        rd.Count,
 		Items:        rd.Items,
+		Sessions:     groupBySession(rd.Items, rd.sessions),
 		Polling:      r.Header.Get("HX-Polling"),
 	}
 
@@ -65,9 +91,42 @@ func (rd RacesRenderer) HTML(w http.ResponseWriter, r *http.Request) string {
 type RacesTemplateData struct {
 	TemplateData
 
-	Count   int
-	Items   []models.APIRace
-	Polling string
+	Count    int
+	Items    []models.APIRace
+	Sessions []SessionGroup
+	Polling  string
+}
+
+// SessionGroup is a session and its races in the list, in the order of the list
+type SessionGroup struct {
+	Session models.Session
+	Races   []models.APIRace
+}
+
+// groupBySession groups the races by session, sessions are ordered by their first race in the list.
+// A session not saved, of races recorded before the sessions, is headed with the start of its first race.
+func groupBySession(races []models.APIRace, sessions []models.Session) []SessionGroup {
+	saved := make(map[uuid.UUID]models.Session, len(sessions))
+	for _, session := range sessions {
+		saved[session.ID] = session
+	}
+
+	groups := make([]SessionGroup, 0)
+	index := make(map[uuid.UUID]int)
+	for _, race := range races {
+		i, ok := index[race.SessionID]
+		if !ok {
+			session, ok := saved[race.SessionID]
+			if !ok {
+				session = models.Session{ID: race.SessionID, StartedAt: race.StartedAt}
+			}
+			i = len(groups)
+			index[race.SessionID] = i
+			groups = append(groups, SessionGroup{Session: session})
+		}
+		groups[i].Races = append(groups[i].Races, race)
+	}
+	return groups
 }
 
 func (td RacesTemplateData) PollNew() bool {
@@ -82,8 +141,9 @@ func (td RacesTemplateData) PollNewTime() int64 {
 	}
 }
 
-func (td RacesTemplateData) PollPrevious(index int) bool {
-	if (td.Polling == "previous" || td.Polling == "all") && index+1 == len(td.Items) {
+// PollPrevious tells if the race is the last of the list, the oldest, which loads the previous races when revealed
+func (td RacesTemplateData) PollPrevious(race models.APIRace) bool {
+	if (td.Polling == "previous" || td.Polling == "all") && race.ID == td.Items[len(td.Items)-1].ID {
 		return true
 	}
 	return false
@@ -105,6 +165,51 @@ func (h *Handler) race(w http.ResponseWriter, r *http.Request) {
 	Render(w, r, RaceResponse{Race: raceDetail, TemplateData: NewTemplateData(r)})
 }
 
+// pinRace keeps the race when the database is cleaned up
+func (h *Handler) pinRace(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, true)
+}
+
+func (h *Handler) unpinRace(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, false)
+}
+
+func (h *Handler) setPinned(w http.ResponseWriter, r *http.Request, pinned bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		Render(w, r, NewErrorRenderer(http.StatusBadRequest, "invalid race id", err, nil))
+		return
+	}
+
+	err = h.db.PinRace(id.String(), pinned, r.Context())
+	if err != nil {
+		Render(w, r, StorageErrorRenderer(err))
+		return
+	}
+
+	h.race(w, r)
+}
+
+// restoreRace imports the points of an archived race back
+func (h *Handler) restoreRace(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		Render(w, r, NewErrorRenderer(http.StatusBadRequest, "invalid race id", err, nil))
+		return
+	}
+
+	err = h.db.RestoreRace(id, r.Context())
+	if errors.Is(err, storage.ErrNoArchive) || errors.Is(err, os.ErrNotExist) {
+		Render(w, r, NewErrorRenderer(http.StatusNotFound, "archive not found", err, nil))
+		return
+	} else if err != nil {
+		Render(w, r, StorageErrorRenderer(err))
+		return
+	}
+
+	h.race(w, r)
+}
+
 type RaceResponse struct {
 	TemplateData `json:"-"`
 	Renderer     `json:"-"`
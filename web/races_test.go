@@ -1,14 +1,21 @@
 package web_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+
+	"forzatelemetry/archive"
 	"forzatelemetry/models"
+	"forzatelemetry/storage"
 	"forzatelemetry/testutils"
 	"forzatelemetry/web"
 )
@@ -30,12 +37,14 @@ func TestGetRaces(t *testing.T) {
 	db := testutils.NewStore("races.yaml")
 	defer db.Close()
 
-	router := web.Router(db, "version", "https://localhost")
+	router := web.Router(db, nil, "version", "https://localhost", "")
 
 	runs := map[string]testGetRacesRun{
 		"empty":         {code: 200, countTotal: 10, countItems: 10, params: ""},
 		"inProgress":    {code: 200, countTotal: 1, countItems: 1, params: "filter=inProgress:eq:true"},
 		"notInProgress": {code: 200, countTotal: 9, countItems: 9, params: "filter=inProgress:eq:false"},
+		"game":          {code: 200, countTotal: 10, countItems: 10, params: "filter=game:in:fm8,fh"},
+		"otherGame":     {code: 200, countTotal: 0, countItems: 0, params: "filter=game:in:fh"},
 		"wrongFilter":   {code: 400, countTotal: 0, countItems: 0, params: "filter=inProgress:eq:aaa", errorMsg: "invalid filter 'inProgress:eq:aaa': invalid value: invalid syntax"},
 	}
 
@@ -96,6 +105,8 @@ func makeRaceDetailled(id string, sessionID string, startedAt string, finishedAt
 			Race: models.Race{
 				ID:         testutils.ParseUUID(id),
 				SessionID:  testutils.ParseUUID(sessionID),
+				Game:       models.GAME_FM8,
+				Fields:     models.FIELDS_ALL,
 				StartedAt:  testutils.ParseTime(startedAt),
 				FinishedAt: testutils.ParseTime(finishedAt),
 				Car:        100,
@@ -110,7 +121,7 @@ func TestGetRace(t *testing.T) {
 	db := testutils.NewStore("races.yaml", "points.yaml")
 	defer db.Close()
 
-	router := web.Router(db, "version", "https://localhost")
+	router := web.Router(db, nil, "version", "https://localhost", "")
 
 	runs := map[string]testGetRaceRun{
 		"exist": {
@@ -166,3 +177,160 @@ func testGetRaceCheckSuccess(resp *httptest.ResponseRecorder, run testGetRaceRun
 		t.Errorf("expected %+v got %+v", run.race, respData.Race)
 	}
 }
+
+func TestPinRace(t *testing.T) {
+	db := testutils.NewStore("races.yaml", "points.yaml")
+	defer db.Close()
+
+	router := web.Router(db, nil, "version", "https://localhost", "secret")
+	path := "/races/44e22d85-3883-4552-9ff4-91a7211e0639/pin"
+
+	runs := []struct {
+		method   string
+		path     string
+		password string
+		status   int
+		pinned   bool
+	}{
+		{"PUT", path, "", 401, false},
+		{"PUT", "/races/invalid/pin", "secret", 400, false},
+		{"PUT", "/races/44e22d85-3883-4552-9ff4-aaaaaaaaaaaa/pin", "secret", 404, false},
+		{"PUT", path, "secret", 200, true},
+		{"DELETE", path, "secret", 200, false},
+	}
+	for _, run := range runs {
+		req, err := http.NewRequest(run.method, run.path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if run.password != "" {
+			req.SetBasicAuth("admin", run.password)
+		}
+		resp := testutils.ExecuteRequest(req, router)
+		if resp.Code != run.status {
+			t.Fatalf("%s %s: expected %v got %v", run.method, run.path, run.status, resp.Code)
+		}
+		if resp.Code != 200 {
+			continue
+		}
+
+		var respData struct {
+			Race models.APIRaceDetailled `json:"race"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&respData)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if respData.Race.Pinned != run.pinned {
+			t.Errorf("%s %s: expected %v got %v", run.method, run.path, run.pinned, respData.Race.Pinned)
+		}
+	}
+}
+
+func TestRestoreRace(t *testing.T) {
+	db := testutils.NewStore("races.yaml", "points.yaml")
+	defer db.Close()
+
+	router := web.Router(db, nil, "version", "https://localhost", "secret")
+	path := "/races/44e22d85-3883-4552-9ff4-91a7211e0639/restore"
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		req.SetBasicAuth("admin", "secret")
+		return testutils.ExecuteRequest(req, router)
+	}
+
+	// without archive
+	resp := request(path)
+	if resp.Code != 404 {
+		t.Fatalf("expected 404 got %v", resp.Code)
+	}
+
+	dir, err := archive.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	db.SetArchive(dir)
+	_, err = db.Cleanup(context.Background(), storage.RetentionPolicy{MaxPoints: 1})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	runs := []struct {
+		path   string
+		status int
+	}{
+		{"/races/invalid/restore", 400},
+		{"/races/44e22d85-3883-4552-9ff4-aaaaaaaaaaaa/restore", 404},
+		{path, 200},
+	}
+	for _, run := range runs {
+		resp := request(run.path)
+		if resp.Code != run.status {
+			t.Fatalf("%s: expected %v got %v", run.path, run.status, resp.Code)
+		}
+		if resp.Code != 200 {
+			continue
+		}
+
+		var respData struct {
+			Race models.APIRaceDetailled `json:"race"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&respData)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if respData.Race.Archived {
+			t.Errorf("expected the race to be restored")
+		}
+	}
+}
+
+// Races of two consoles racing at the same time alternate in the list, they are still grouped by session
+func TestGetRacesSessions(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	start := time.Date(2024, 9, 7, 17, 0, 0, 0, time.UTC)
+	sessions := []models.Session{
+		{ID: uuid.New(), Source: "192.168.1.10:52000", StartedAt: start},
+		{ID: uuid.New(), Source: "192.168.1.11:52000", StartedAt: start.Add(time.Minute)},
+	}
+	err := db.UpsertSessions(context.Background(), sessions...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var races []models.Race
+	for i := range 4 {
+		started := start.Add(time.Duration(10+i) * time.Minute)
+		races = append(races, models.Race{ID: uuid.New(), SessionID: sessions[i%2].ID, StartedAt: started, FinishedAt: started.Add(time.Minute)})
+	}
+	err = db.UpsertRaces(context.Background(), races...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	router := web.Router(db, nil, "version", "https://localhost", "")
+	req, err := http.NewRequest("GET", "/races", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("HX-Request", "true")
+	body := testutils.ExecuteRequest(req, router).Body.String()
+
+	for _, session := range sessions {
+		header := fmt.Sprintf(`href="/sessions/%s">Session of %s</a> from %s`, session.ID, session.StartedAt.Format("Mon Jan _2 15:04"), session.Source)
+		if n := strings.Count(body, header); n != 1 {
+			t.Errorf("expected 1 header %v got %v in %v", header, n, body)
+		}
+	}
+	// the races of the last session to race are listed first
+	first := strings.Index(body, races[1].ID.String())
+	if first < 0 || strings.Index(body, races[3].ID.String()) > first || strings.Index(body, races[2].ID.String()) < first {
+		t.Errorf("expected the races grouped by session in %v", body)
+	}
+}
@@ -1,21 +1,27 @@
 package web
 
 import (
+	"crypto/subtle"
 	"net/http"
 
+	"forzatelemetry/metrics"
 	"forzatelemetry/storage"
+	"forzatelemetry/telemetry"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-func Router(db *storage.Store, revision string, dashboardBaseUrl string) chi.Router {
+// Router serves the API. Admin routes are disabled when adminToken is empty.
+func Router(db *storage.Store, telemetryServer *telemetry.Server, revision string, dashboardBaseUrl string, adminToken string) chi.Router {
 	router := chi.NewRouter()
 	router.Use(middleware.Recoverer)
+	router.Use(routeMetrics)
 
-	hdlr := &Handler{db: db, revision: revision, dashboardBaseUrl: dashboardBaseUrl}
+	hdlr := &Handler{db: db, telemetry: telemetryServer, revision: revision, dashboardBaseUrl: dashboardBaseUrl}
 
 	router.Get("/ping", hdlr.pong)
+	router.Method("GET", "/metrics", metrics.Default)
 
 	router.Group(func(r chi.Router) {
 		r.Use(middleware.RequestID)
@@ -29,7 +35,24 @@ func Router(db *storage.Store, revision string, dashboardBaseUrl string) chi.Rou
 		r.Get("/races", hdlr.races)
 		r.Get("/races/{id}", hdlr.race)
 		r.Get("/races/{id}/points", hdlr.points)
+		r.Get("/races/{id}/live", hdlr.live)
 		r.Get("/metadata/tracks", hdlr.tracksMetadata)
+		r.Get("/telemetry/rejections", hdlr.rejections)
+		r.Get("/sessions", hdlr.sessions)
+		r.Get("/sessions/{id}", hdlr.session)
+		r.Get("/sessions/{id}/races", hdlr.sessionRaces)
+		r.Get("/drivers", hdlr.drivers)
+
+		r.Group(func(r chi.Router) {
+			r.Use(adminAuth(adminToken))
+			r.Post("/sessions/{id}/close", hdlr.closeSession)
+			r.Put("/drivers/{name}", hdlr.putDriver)
+			r.Delete("/drivers/{name}", hdlr.deleteDriver)
+			r.Put("/races/{id}/pin", hdlr.pinRace)
+			r.Delete("/races/{id}/pin", hdlr.unpinRace)
+			r.Post("/races/{id}/restore", hdlr.restoreRace)
+		})
+
 		r.NotFound(notFound)
 		r.MethodNotAllowed(notAllowed)
 	})
@@ -39,6 +62,7 @@ func Router(db *storage.Store, revision string, dashboardBaseUrl string) chi.Rou
 
 type Handler struct {
 	db               *storage.Store
+	telemetry        *telemetry.Server
 	dashboardBaseUrl string
 	revision         string
 }
@@ -88,6 +112,27 @@ func corsHeader(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// adminAuth requires the admin token as the password of a basic authentication, so browsers prompt for it
+func adminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				Render(w, r, NewErrorRenderer(http.StatusForbidden, "admin disabled", nil, nil))
+				return
+			}
+			_, password, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="forzatelemetry admin"`)
+				Render(w, r, NewErrorRenderer(http.StatusUnauthorized, "unauthorized", nil, nil))
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
 func notFound(w http.ResponseWriter, r *http.Request) {
 	Render(w, r, NewErrorRenderer(404, "not found", nil, nil))
 }
@@ -20,7 +20,7 @@ func TestApi(t *testing.T) {
 	db := testutils.NewStore()
 	defer db.Close()
 
-	router := http.Router(db, "version", "https://localhost")
+	router := http.Router(db, nil, "version", "https://localhost", "")
 
 	runs := map[string]testAPIRun{
 		"index":       {code: 200, method: "GET", path: "/"},
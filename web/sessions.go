@@ -0,0 +1,90 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+)
+
+func (h *Handler) sessions(w http.ResponseWriter, r *http.Request) {
+	sessions := []telemetry.SessionInfo{}
+	if h.telemetry != nil {
+		sessions = h.telemetry.Sessions()
+	}
+
+	Render(w, r, SessionsRenderer{Count: len(sessions), Items: sessions, TemplateData: NewTemplateData(r)})
+}
+
+type SessionsRenderer struct {
+	TemplateData `json:"-"`
+	Renderer     `json:"-"`
+
+	Count int                     `json:"count"`
+	Items []telemetry.SessionInfo `json:"items"`
+}
+
+func (rd SessionsRenderer) HTML(w http.ResponseWriter, r *http.Request) string {
+	return RenderTemplate(r, "sessions.html", rd)
+}
+
+func (h *Handler) closeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		Render(w, r, NewErrorRenderer(http.StatusBadRequest, "invalid session id", err, nil))
+		return
+	}
+
+	if h.telemetry == nil || !h.telemetry.CloseSession(id) {
+		Render(w, r, NewErrorRenderer(http.StatusNotFound, "not found", nil, nil))
+		return
+	}
+
+	h.sessions(w, r)
+}
+
+func (h *Handler) session(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		Render(w, r, NewErrorRenderer(http.StatusBadRequest, "invalid session id", err, nil))
+		return
+	}
+
+	session, err := h.db.SelectSession(id.String(), r.Context())
+	if err != nil {
+		Render(w, r, StorageErrorRenderer(err))
+		return
+	}
+
+	Render(w, r, SessionRenderer{Session: session, TemplateData: NewTemplateData(r)})
+}
+
+type SessionRenderer struct {
+	TemplateData `json:"-"`
+	Renderer     `json:"-"`
+
+	Session models.APISession `json:"session"`
+}
+
+func (rd SessionRenderer) HTML(w http.ResponseWriter, r *http.Request) string {
+	return RenderTemplate(r, "session.html", rd)
+}
+
+func (h *Handler) sessionRaces(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		Render(w, r, NewErrorRenderer(http.StatusBadRequest, "invalid session id", err, nil))
+		return
+	}
+
+	races, err := h.db.SelectSessionRaces(id.String(), r.Context(), h.dashboardBaseUrl)
+	if err != nil {
+		Render(w, r, StorageErrorRenderer(err))
+		return
+	}
+
+	Render(w, r, RacesRenderer{Count: len(races), Items: races})
+}
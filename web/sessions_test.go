@@ -0,0 +1,216 @@
+package web_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"forzatelemetry/models"
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+	"forzatelemetry/web"
+)
+
+type getSessionsResponse struct {
+	Count int                     `json:"count"`
+	Items []telemetry.SessionInfo `json:"items"`
+}
+
+func getSessions(t *testing.T, router chi.Router) getSessionsResponse {
+	req, err := http.NewRequest("GET", "/sessions", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	resp := testutils.ExecuteRequest(req, router)
+	if resp.Code != 200 {
+		t.Fatalf("expected 200 got %v", resp.Code)
+	}
+
+	var respData getSessionsResponse
+	err = json.NewDecoder(resp.Body).Decode(&respData)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	return respData
+}
+
+func TestGetSessionsNoServer(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	router := web.Router(db, nil, "version", "https://localhost", "")
+	respData := getSessions(t, router)
+	if respData.Count != 0 {
+		t.Errorf("expected 0 got %v", respData.Count)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	server := telemetry.NewServer("127.0.0.1:0", db, 5*time.Second)
+	// races are written right away
+	batch := telemetry.DEFAULT_BATCH_CONFIG
+	batch.Interval = 10 * time.Millisecond
+	server.SetBatchConfig(batch)
+	go server.ListenAndProcess()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	addr := server.Addr()
+	for addr == nil {
+		time.Sleep(10 * time.Microsecond)
+		addr = server.Addr()
+	}
+
+	con, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	point := testutils.Point(testutils.ParseUUID("cfb8395b-1bd3-4723-a2de-eb192365865b"), time.Now(), 1)
+	err = binary.Write(con, binary.LittleEndian, point.TelemetryPoint)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	router := web.Router(db, server, "version", "https://localhost", "secret")
+
+	respData := getSessions(t, router)
+	if respData.Count != 1 {
+		t.Fatalf("expected 1 got %v", respData.Count)
+	}
+	session := respData.Items[0]
+	if session.Source != con.LocalAddr().String() {
+		t.Errorf("expected %v got %v", con.LocalAddr().String(), session.Source)
+	}
+	if session.Received != 1 || session.Dropped != 0 {
+		t.Errorf("expected 1 received got %v", session)
+	}
+	if session.Race == telemetry.EMPTY_UUID || session.Session == telemetry.EMPTY_UUID {
+		t.Errorf("expected a session and a race got %v", session)
+	}
+
+	req, err := http.NewRequest("GET", "/sessions", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	req.Header.Set("Accept", "text/html")
+	resp := testutils.ExecuteRequest(req, router)
+	if !strings.Contains(resp.Body.String(), session.Session.String()) {
+		t.Errorf("expected %v in %v", session.Session, resp.Body.String())
+	}
+
+	// admin action
+	path := "/sessions/" + session.Session.String() + "/close"
+	runs := []struct {
+		router   chi.Router
+		password string
+		path     string
+		status   int
+	}{
+		{web.Router(db, server, "version", "https://localhost", ""), "", path, 403},
+		{router, "", path, 401},
+		{router, "wrong", path, 401},
+		{router, "secret", "/sessions/invalid/close", 400},
+		{router, "secret", "/sessions/0abd1c08-5bd1-4ab2-a5e8-3c2b1b4c1a1d/close", 404},
+		{router, "secret", path, 200},
+	}
+	for _, run := range runs {
+		req, err := http.NewRequest("POST", run.path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if run.password != "" {
+			req.SetBasicAuth("admin", run.password)
+		}
+		resp := testutils.ExecuteRequest(req, run.router)
+		if resp.Code != run.status {
+			t.Errorf("%s %s: expected %v got %v", run.password, run.path, run.status, resp.Code)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	respData = getSessions(t, router)
+	if respData.Count != 0 {
+		t.Errorf("expected 0 got %v", respData.Count)
+	}
+
+	races, _, err := db.SelectRaces(nil, 0, context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if races[0].InProgress {
+		t.Errorf("expected the race to be closed")
+	}
+}
+
+func TestGetSession(t *testing.T) {
+	db := testutils.NewStore("races.yaml", "sessions.yaml")
+	defer db.Close()
+
+	router := web.Router(db, nil, "version", "https://localhost", "")
+
+	for _, run := range []struct {
+		path string
+		code int
+	}{
+		{"/sessions/665078b0-1130-48a9-8a35-0e7cbfd7704c", 200},
+		{"/sessions/665078b0-1130-48a9-8a35-aaaaaaaaaaaa", 404},
+		{"/sessions/aaaa", 400},
+		{"/sessions/665078b0-1130-48a9-8a35-0e7cbfd7704c/races", 200},
+		{"/sessions/aaaa/races", 400},
+	} {
+		req, err := http.NewRequest("GET", run.path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		resp := testutils.ExecuteRequest(req, router)
+		if resp.Code != run.code {
+			t.Errorf("%s: expected %v got %v", run.path, run.code, resp.Code)
+		}
+	}
+
+	req, err := http.NewRequest("GET", "/sessions/665078b0-1130-48a9-8a35-0e7cbfd7704c", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	var session struct {
+		Session models.APISession `json:"session"`
+	}
+	err = json.NewDecoder(testutils.ExecuteRequest(req, router).Body).Decode(&session)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if session.Session.Source != "192.168.1.10:52000" || session.Session.Races != 1 {
+		t.Errorf("unexpected session %+v", session.Session)
+	}
+
+	req.Header.Set("Accept", "text/html")
+	resp := testutils.ExecuteRequest(req, router)
+	if !strings.Contains(resp.Body.String(), "/sessions/665078b0-1130-48a9-8a35-0e7cbfd7704c/races") {
+		t.Errorf("expected races link in %v", resp.Body.String())
+	}
+
+	req, err = http.NewRequest("GET", "/races", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("HX-Request", "true")
+	resp = testutils.ExecuteRequest(req, router)
+	if !strings.Contains(resp.Body.String(), `href="/sessions/665078b0-1130-48a9-8a35-0e7cbfd7704c"`) {
+		t.Errorf("expected session link in %v", resp.Body.String())
+	}
+}
@@ -0,0 +1,28 @@
+package web
+
+import (
+	"net/http"
+
+	"forzatelemetry/telemetry"
+)
+
+func (h *Handler) rejections(w http.ResponseWriter, r *http.Request) {
+	rejections := []telemetry.Rejection{}
+	if h.telemetry != nil {
+		rejections = h.telemetry.Rejections()
+	}
+
+	Render(w, r, RejectionsRenderer{Count: len(rejections), Items: rejections, TemplateData: NewTemplateData(r)})
+}
+
+type RejectionsRenderer struct {
+	TemplateData `json:"-"`
+	Renderer     `json:"-"`
+
+	Count int                   `json:"count"`
+	Items []telemetry.Rejection `json:"items"`
+}
+
+func (rd RejectionsRenderer) HTML(w http.ResponseWriter, r *http.Request) string {
+	return RenderTemplate(r, "rejections.html", rd)
+}
@@ -0,0 +1,109 @@
+package web_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"forzatelemetry/telemetry"
+	"forzatelemetry/testutils"
+	"forzatelemetry/web"
+)
+
+type getRejectionsResponse struct {
+	Count int                   `json:"count"`
+	Items []telemetry.Rejection `json:"items"`
+}
+
+func TestGetRejectionsNoServer(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	router := web.Router(db, nil, "version", "https://localhost", "")
+
+	req, err := http.NewRequest("GET", "/telemetry/rejections", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	resp := testutils.ExecuteRequest(req, router)
+	if resp.Code != 200 {
+		t.Fatalf("expected 200 got %v", resp.Code)
+	}
+
+	var respData getRejectionsResponse
+	err = json.NewDecoder(resp.Body).Decode(&respData)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if respData.Count != 0 {
+		t.Errorf("expected 0 got %v", respData.Count)
+	}
+}
+
+func TestGetRejections(t *testing.T) {
+	db := testutils.NewStore()
+	defer db.Close()
+
+	server := telemetry.NewServer("127.0.0.1:0", db, 5*time.Second)
+	go server.ListenAndProcess()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	addr := server.Addr()
+	for addr == nil {
+		time.Sleep(10 * time.Microsecond)
+		addr = server.Addr()
+	}
+
+	con, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = con.Write([]byte("hi"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	router := web.Router(db, server, "version", "https://localhost", "")
+
+	req, err := http.NewRequest("GET", "/telemetry/rejections", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	resp := testutils.ExecuteRequest(req, router)
+	if resp.Code != 200 {
+		t.Fatalf("expected 200 got %v", resp.Code)
+	}
+
+	var respData getRejectionsResponse
+	err = json.NewDecoder(resp.Body).Decode(&respData)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if respData.Count != 1 {
+		t.Fatalf("expected 1 got %v", respData.Count)
+	}
+	if respData.Items[0].Reason != telemetry.REASON_LENGTH {
+		t.Errorf("expected %v got %v", telemetry.REASON_LENGTH, respData.Items[0].Reason)
+	}
+	if respData.Items[0].Source != con.LocalAddr().String() {
+		t.Errorf("expected %v got %v", con.LocalAddr().String(), respData.Items[0].Source)
+	}
+
+	req.Header.Set("Accept", "text/html")
+	resp = testutils.ExecuteRequest(req, router)
+	if resp.Code != 200 {
+		t.Fatalf("expected 200 got %v", resp.Code)
+	}
+	if !strings.Contains(resp.Body.String(), telemetry.REASON_LENGTH) {
+		t.Errorf("expected %v in %v", telemetry.REASON_LENGTH, resp.Body.String())
+	}
+}